@@ -0,0 +1,503 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// auditLogSchema records every authenticated admin change, successful or not
+const auditLogSchema = `
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		at TEXT NOT NULL,
+		key_id INTEGER,
+		key_name TEXT NOT NULL DEFAULT '',
+		client_ip TEXT NOT NULL DEFAULT '',
+		action TEXT NOT NULL,
+		target TEXT NOT NULL DEFAULT '',
+		detail TEXT NOT NULL DEFAULT '',
+		status INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_audit_log_at ON audit_log(at);`
+
+// crawlQueueSchema mirrors the crawler's crawl_queue table (see
+// crawler/crawlqueue.go), which /admin/requeue writes to
+const crawlQueueSchema = `
+	CREATE TABLE IF NOT EXISTS crawl_queue (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		url TEXT NOT NULL,
+		requested_at TEXT NOT NULL,
+		requested_by TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL DEFAULT 'pending',
+		error TEXT NOT NULL DEFAULT '',
+		finished_at TEXT
+	);
+	CREATE INDEX IF NOT EXISTS idx_crawl_queue_status ON crawl_queue(status);`
+
+const (
+	maxAdminBody   = 1 << 20 // bytes of an admin request body
+	maxRequeueURLs = 1000
+)
+
+// adminError is an admin failure with the status to report it with
+type adminError struct {
+	status int
+	msg    string
+}
+
+func (e *adminError) Error() string { return e.msg }
+
+func badRequest(format string, args ...any) error {
+	return &adminError{http.StatusBadRequest, fmt.Sprintf(format, args...)}
+}
+
+// adminHandler does the work of one admin action. It returns the JSON
+// response and a short description of the target for the audit log.
+type adminHandler func(r *http.Request) (resp any, target string, err error)
+
+// --- Admin plumbing ---
+
+// methods routes a request by method and answers 405 for the others
+type methods map[string]http.HandlerFunc
+
+func (m methods) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h, ok := m[r.Method]
+	if !ok && r.Method == http.MethodHead {
+		h, ok = m[http.MethodGet]
+	}
+	if !ok {
+		allowed := make([]string, 0, len(m))
+		for method := range m {
+			allowed = append(allowed, method)
+		}
+		sort.Strings(allowed)
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		respondJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	h(w, r)
+}
+
+// adminAction serves h and writes an audit_log row for every call that
+// changes something; reads (GET) are not audited
+func adminAction(action string, h adminHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxAdminBody)
+		resp, target, err := h(r)
+		status := http.StatusOK
+		if err != nil {
+			var ae *adminError
+			if errors.As(err, &ae) {
+				status = ae.status
+			} else {
+				status = http.StatusInternalServerError
+				logError("Admin "+action+" failed", err)
+			}
+			resp = ErrorResponse{Error: err.Error()}
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			audit(r, action, target, resp, status)
+			logEvent("Admin", fmt.Sprintf("%s %s -> %d", action, target, status))
+		}
+		respondJSON(w, status, resp)
+	}
+}
+
+func audit(r *http.Request, action, target string, detail any, status int) {
+	var keyID sql.NullInt64
+	var keyName string
+	if k := keyFromContext(r.Context()); k != nil {
+		keyID, keyName = sql.NullInt64{Int64: k.ID, Valid: true}, k.Name
+	}
+	b, _ := json.Marshal(detail)
+	if _, err := db.Exec(`INSERT INTO audit_log (at, key_id, key_name, client_ip, action, target, detail, status) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		time.Now().UTC().Format(time.RFC3339), keyID, keyName, clientKey(clientIP(r)), action, target, string(b), status); err != nil {
+		logError("Failed to write audit log", err)
+	}
+}
+
+// decodeBody reads a JSON request body into v, rejecting unknown fields
+func decodeBody(r *http.Request, v any) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return badRequest("Invalid JSON body: %v", err)
+	}
+	return nil
+}
+
+// --- Deleting pages ---
+
+type deleteRequest struct {
+	URL    string `json:"url"`
+	Domain string `json:"domain"` // also removes its subdomains
+}
+
+type deleteResponse struct {
+	Pages    int64 `json:"pages"`
+	Sections int64 `json:"sections"`
+	Aliases  int64 `json:"aliases"`
+	Links    int64 `json:"links"`
+}
+
+// adminDelete removes one URL or every page of a domain with the rows that
+// hang off them. A domain still listed in categories.json comes back on the
+// next crawl; remove it there too to keep it out.
+func adminDelete(r *http.Request) (any, string, error) {
+	var req deleteRequest
+	if err := decodeBody(r, &req); err != nil {
+		return nil, "", err
+	}
+	req.URL, req.Domain = strings.TrimSpace(req.URL), strings.ToLower(strings.TrimSpace(req.Domain))
+	if (req.URL == "") == (req.Domain == "") {
+		return nil, "", badRequest("Give exactly one of url or domain")
+	}
+
+	var urls []string
+	target := req.URL
+	if req.URL != "" {
+		urls = []string{req.URL}
+		var aliased string
+		if err := db.QueryRow(`SELECT target FROM url_aliases WHERE alias = ?`, req.URL).Scan(&aliased); err == nil {
+			urls = append(urls, aliased)
+		}
+	} else {
+		target = req.Domain
+		if strings.ContainsAny(req.Domain, "/:?# ") {
+			return nil, target, badRequest("Domain must be a bare host name")
+		}
+		var err error
+		if urls, err = domainURLs(req.Domain); err != nil {
+			return nil, target, err
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, target, err
+	}
+	defer tx.Rollback()
+	var resp deleteResponse
+	for _, u := range urls {
+		for _, del := range []struct {
+			query string
+			count *int64
+		}{
+			{`DELETE FROM sections WHERE page_url = ?1`, &resp.Sections},
+			{`DELETE FROM url_aliases WHERE target = ?1 OR alias = ?1`, &resp.Aliases},
+			{`DELETE FROM links WHERE source = ?1`, &resp.Links},
+			{`DELETE FROM pages WHERE url = ?1`, &resp.Pages},
+		} {
+			res, err := tx.Exec(del.query, u)
+			if err != nil {
+				if strings.Contains(err.Error(), "no such table") {
+					continue // the crawler has not created it yet
+				}
+				return nil, target, err
+			}
+			n, _ := res.RowsAffected()
+			*del.count += n
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, target, err
+	}
+	if resp.Pages == 0 {
+		return nil, target, &adminError{http.StatusNotFound, "No stored pages match"}
+	}
+	return resp, target, nil
+}
+
+// domainURLs lists stored page URLs whose host is domain or one of its
+// subdomains, by the indexed pages.domain column (kept without "www.")
+func domainURLs(domain string) ([]string, error) {
+	domain = strings.TrimPrefix(domain, "www.")
+	// the subquery walks the domain index only, the outer query then seeks it
+	rows, err := db.Query(`SELECT url FROM pages WHERE domain IN (
+		SELECT domain FROM pages WHERE domain = ?1 OR SUBSTR(domain, -LENGTH(?2)) = ?2)`,
+		domain, "."+domain)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var urls []string
+	for rows.Next() {
+		var u string
+		if err := rows.Scan(&u); err != nil {
+			return nil, err
+		}
+		urls = append(urls, u)
+	}
+	return urls, rows.Err()
+}
+
+// --- Re-queueing URLs ---
+
+type requeueRequest struct {
+	URLs []string `json:"urls"`
+}
+
+// adminRequeue adds URLs to crawl_queue; the next crawler run fetches them
+// first, even when their domain is not due for a recrawl
+func adminRequeue(r *http.Request) (any, string, error) {
+	var req requeueRequest
+	if err := decodeBody(r, &req); err != nil {
+		return nil, "", err
+	}
+	if len(req.URLs) == 0 || len(req.URLs) > maxRequeueURLs {
+		return nil, "", badRequest("Give between 1 and %d urls", maxRequeueURLs)
+	}
+	for _, raw := range req.URLs {
+		u, err := url.Parse(strings.TrimSpace(raw))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, "", badRequest("%q is not an absolute http(s) URL", raw)
+		}
+	}
+	target := req.URLs[0]
+	if len(req.URLs) > 1 {
+		target = fmt.Sprintf("%s and %d more", req.URLs[0], len(req.URLs)-1)
+	}
+
+	by := ""
+	if k := keyFromContext(r.Context()); k != nil {
+		by = k.Name
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, target, err
+	}
+	defer tx.Rollback()
+	queued := 0
+	for _, raw := range req.URLs {
+		// a URL already waiting is not queued twice
+		res, err := tx.Exec(`INSERT INTO crawl_queue (url, requested_at, requested_by)
+			SELECT ?1, ?2, ?3 WHERE NOT EXISTS (SELECT 1 FROM crawl_queue WHERE url = ?1 AND status = 'pending')`,
+			strings.TrimSpace(raw), now, by)
+		if err != nil {
+			return nil, target, err
+		}
+		n, _ := res.RowsAffected()
+		queued += int(n)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, target, err
+	}
+	return map[string]int{"queued": queued, "already_pending": len(req.URLs) - queued}, target, nil
+}
+
+// --- Categories ---
+
+// adminGetCategories returns categories.json as stored
+func adminGetCategories(r *http.Request) (any, string, error) {
+	data, err := os.ReadFile(catPath)
+	if err != nil {
+		return nil, "", err
+	}
+	return json.RawMessage(data), "", nil
+}
+
+// adminPutCategories replaces categories.json once it passes the checks
+// the crawler runs when it loads the file (see parseCategories), so a bad
+// edit cannot stop later crawls. The previous version is kept as
+// categories.json.bak.
+func adminPutCategories(r *http.Request) (any, string, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, "", badRequest("Cannot read body: %v", err)
+	}
+	set, err := parseCategories(body)
+	if err != nil {
+		return nil, "categories.json", badRequest("%v", err)
+	}
+
+	var pretty bytes.Buffer
+	if err := json.Indent(&pretty, body, "", "  "); err != nil {
+		return nil, "", badRequest("Invalid JSON: %v", err)
+	}
+	pretty.WriteByte('\n')
+	if old, err := os.ReadFile(catPath); err == nil {
+		if err := os.WriteFile(catPath+".bak", old, 0644); err != nil {
+			return nil, "", err
+		}
+	}
+	tmp := catPath + ".tmp"
+	if err := os.WriteFile(tmp, pretty.Bytes(), 0644); err != nil {
+		return nil, "", err
+	}
+	if err := os.Rename(tmp, catPath); err != nil {
+		return nil, "", err
+	}
+	if _, err := categories.reload(true); err != nil {
+		return nil, "categories.json", err
+	}
+	return map[string]int{"categories": len(set.Names)}, "categories.json", nil
+}
+
+// --- Maintenance ---
+
+// adminReindex rebuilds the indexes and refreshes the query planner's statistics
+func adminReindex(r *http.Request) (any, string, error) {
+	start := time.Now()
+	if _, err := db.ExecContext(r.Context(), `REINDEX; ANALYZE;`); err != nil {
+		return nil, "", err
+	}
+	return map[string]string{"took": time.Since(start).Round(time.Millisecond).String()}, "database", nil
+}
+
+// adminVacuum rewrites the database file to reclaim space left by deletions.
+// It needs free disk space about the size of the database and blocks writers
+// while it runs.
+func adminVacuum(r *http.Request) (any, string, error) {
+	before, _ := dbSize()
+	start := time.Now()
+	if _, err := db.ExecContext(r.Context(), `VACUUM`); err != nil {
+		return nil, "", err
+	}
+	after, _ := dbSize()
+	return map[string]any{
+		"took":         time.Since(start).Round(time.Millisecond).String(),
+		"bytes_before": before,
+		"bytes_after":  after,
+	}, "database", nil
+}
+
+func dbSize() (int64, error) {
+	var pages, size int64
+	if err := db.QueryRow(`PRAGMA page_count`).Scan(&pages); err != nil {
+		return 0, err
+	}
+	if err := db.QueryRow(`PRAGMA page_size`).Scan(&size); err != nil {
+		return 0, err
+	}
+	return pages * size, nil
+}
+
+// --- Stats ---
+
+type indexStats struct {
+	Pages      int64            `json:"pages"`
+	ByCategory map[string]int64 `json:"by_category"`
+	Sections   int64            `json:"sections"`
+	Links      int64            `json:"links"`
+	Aliases    int64            `json:"aliases"`
+	Queue      map[string]int64 `json:"crawl_queue"`
+	APIKeys    int64            `json:"active_api_keys"`
+	LastCrawl  string           `json:"last_crawl,omitempty"`
+	DBBytes    int64            `json:"db_bytes"`
+	FreeBytes  int64            `json:"free_bytes"` // reclaimable by VACUUM
+}
+
+func adminStats(r *http.Request) (any, string, error) {
+	s := indexStats{ByCategory: map[string]int64{}, Queue: map[string]int64{}}
+	counts := []struct {
+		query string
+		dst   *int64
+	}{
+		{`SELECT COUNT(*) FROM pages`, &s.Pages},
+		{`SELECT COUNT(*) FROM sections`, &s.Sections},
+		{`SELECT COUNT(*) FROM links`, &s.Links},
+		{`SELECT COUNT(*) FROM url_aliases`, &s.Aliases},
+		{`SELECT COUNT(*) FROM api_keys WHERE revoked_at IS NULL`, &s.APIKeys},
+	}
+	for _, c := range counts {
+		if err := db.QueryRow(c.query).Scan(c.dst); err != nil && !strings.Contains(err.Error(), "no such table") {
+			return nil, "", err
+		}
+	}
+	for _, g := range []struct {
+		query string
+		dst   map[string]int64
+	}{
+		{`SELECT COALESCE(category, ''), COUNT(*) FROM pages GROUP BY category`, s.ByCategory},
+		{`SELECT status, COUNT(*) FROM crawl_queue GROUP BY status`, s.Queue},
+	} {
+		rows, err := db.Query(g.query)
+		if err != nil {
+			return nil, "", err
+		}
+		for rows.Next() {
+			var k string
+			var n int64
+			if err := rows.Scan(&k, &n); err != nil {
+				rows.Close()
+				return nil, "", err
+			}
+			g.dst[k] = n
+		}
+		rows.Close()
+	}
+	var last sql.NullString
+	db.QueryRow(`SELECT MAX(fetched_at) FROM fetch_log`).Scan(&last) // absent before the first crawl
+	s.LastCrawl = last.String
+
+	var err error
+	if s.DBBytes, err = dbSize(); err != nil {
+		return nil, "", err
+	}
+	var free, pageSize int64
+	db.QueryRow(`PRAGMA freelist_count`).Scan(&free)
+	db.QueryRow(`PRAGMA page_size`).Scan(&pageSize)
+	s.FreeBytes = free * pageSize
+	return s, "", nil
+}
+
+// --- Audit log ---
+
+type auditEntry struct {
+	ID       int64           `json:"id"`
+	At       string          `json:"at"`
+	KeyID    *int64          `json:"key_id,omitempty"`
+	KeyName  string          `json:"key_name,omitempty"`
+	ClientIP string          `json:"client_ip"`
+	Action   string          `json:"action"`
+	Target   string          `json:"target,omitempty"`
+	Detail   json.RawMessage `json:"detail,omitempty"`
+	Status   int             `json:"status"`
+}
+
+// adminAudit lists the most recent audit_log rows, newest first (?limit=, default 100)
+func adminAudit(r *http.Request) (any, string, error) {
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 1000 {
+			return nil, "", badRequest("Limit must be between 1 and 1000")
+		}
+		limit = n
+	}
+	rows, err := db.Query(`SELECT id, at, key_id, key_name, client_ip, action, target, detail, status
+		FROM audit_log ORDER BY id DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+	entries := []auditEntry{}
+	for rows.Next() {
+		var e auditEntry
+		var keyID sql.NullInt64
+		var detail string
+		if err := rows.Scan(&e.ID, &e.At, &keyID, &e.KeyName, &e.ClientIP, &e.Action, &e.Target, &detail, &e.Status); err != nil {
+			return nil, "", err
+		}
+		if keyID.Valid {
+			e.KeyID = &keyID.Int64
+		}
+		if json.Valid([]byte(detail)) {
+			e.Detail = json.RawMessage(detail)
+		}
+		entries = append(entries, e)
+	}
+	return entries, "", rows.Err()
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// useTestCategories points catPath and the categories store at a temporary
+// categories.json holding data
+func useTestCategories(t *testing.T, data string) {
+	t.Helper()
+	oldPath, oldStore := catPath, categories
+	catPath = filepath.Join(t.TempDir(), "categories.json")
+	categories = newCategoryStore(catPath)
+	t.Cleanup(func() { catPath, categories = oldPath, oldStore })
+	if err := os.WriteFile(catPath, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := categories.reload(true); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// adminCall serves one request to h as k from 203.0.113.7
+func adminCall(t *testing.T, h http.Handler, method, target, body string, k *apiKey) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.RemoteAddr = "203.0.113.7:4000"
+	if k != nil {
+		r = r.WithContext(context.WithValue(r.Context(), apiKeyCtx{}, k))
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func lastAudit(t *testing.T) auditEntry {
+	t.Helper()
+	var e auditEntry
+	var detail string
+	err := db.QueryRow(`SELECT action, target, key_name, client_ip, detail, status FROM audit_log ORDER BY id DESC LIMIT 1`).
+		Scan(&e.Action, &e.Target, &e.KeyName, &e.ClientIP, &detail, &e.Status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Detail = json.RawMessage(detail)
+	return e
+}
+
+func countRows(t *testing.T, table string) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM ` + table).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestAdminDelete(t *testing.T) {
+	useTestDB(t)
+	admin := insertKey(t, "ops", 0)
+	for _, u := range []string{
+		"https://kali.org/", "https://www.kali.org/docs/", "https://docs.kali.org/install/",
+		"https://evilkali.org/", "https://archlinux.org/",
+	} {
+		if _, err := db.Exec(`INSERT INTO pages (url, title, snippet, category) VALUES (?, 'x', '', 'linux')`, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := backfillDomains(); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO sections (page_url, position, level, heading) VALUES ('https://www.kali.org/docs/', 0, 1, 'Docs'), ('https://archlinux.org/', 0, 1, 'Arch')`,
+		`INSERT INTO url_aliases (alias, target) VALUES ('https://kali.org/old', 'https://kali.org/'), ('https://arch.test/', 'https://archlinux.org/')`,
+		`INSERT INTO links (source, target) VALUES ('https://kali.org/', 'https://archlinux.org/'), ('https://archlinux.org/', 'https://kali.org/')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := adminAction("delete", adminDelete)
+
+	w := adminCall(t, h, http.MethodPost, "/admin/delete", `{"domain": " Kali.org "}`, admin)
+	var resp deleteResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("domain delete: %d %s", w.Code, w.Body)
+	}
+	if want := (deleteResponse{Pages: 3, Sections: 1, Aliases: 1, Links: 1}); resp != want {
+		t.Errorf("deleted %+v, want %+v", resp, want)
+	}
+	var left []string
+	rows, _ := db.Query(`SELECT url FROM pages ORDER BY url`)
+	for rows.Next() {
+		var u string
+		rows.Scan(&u)
+		left = append(left, u)
+	}
+	rows.Close()
+	if want := []string{"https://archlinux.org/", "https://evilkali.org/"}; !reflect.DeepEqual(left, want) {
+		t.Errorf("pages left %q, want %q", left, want)
+	}
+	e := lastAudit(t)
+	if e.Action != "delete" || e.Target != "kali.org" || e.KeyName != "ops" || e.ClientIP != "203.0.113.7" || e.Status != 200 {
+		t.Errorf("audit row = %+v", e)
+	}
+
+	// a URL delete follows its alias to the stored page
+	if w := adminCall(t, h, http.MethodPost, "/admin/delete", `{"url": "https://arch.test/"}`, admin); w.Code != http.StatusOK {
+		t.Errorf("delete by alias: %d %s", w.Code, w.Body)
+	}
+	if n := countRows(t, "pages"); n != 1 {
+		t.Errorf("%d pages left, want 1", n)
+	}
+
+	for _, tt := range []struct {
+		body string
+		code int
+	}{
+		{`{"url": "https://nowhere.test/"}`, http.StatusNotFound},
+		{`{"url": "https://a.test/", "domain": "a.test"}`, http.StatusBadRequest},
+		{`{}`, http.StatusBadRequest},
+		{`{"domain": "a.test/x"}`, http.StatusBadRequest},
+		{`{"domains": "a.test"}`, http.StatusBadRequest},
+		{`not json`, http.StatusBadRequest},
+	} {
+		before := countRows(t, "audit_log")
+		w := adminCall(t, h, http.MethodPost, "/admin/delete", tt.body, admin)
+		if w.Code != tt.code {
+			t.Errorf("%s: status %d, want %d", tt.body, w.Code, tt.code)
+		}
+		// failures are audited too, with the error as detail
+		if countRows(t, "audit_log") != before+1 || lastAudit(t).Status != tt.code || !strings.Contains(string(lastAudit(t).Detail), "error") {
+			t.Errorf("%s: not audited as a %d failure: %+v", tt.body, tt.code, lastAudit(t))
+		}
+	}
+}
+
+func TestAdminRequeue(t *testing.T) {
+	useTestDB(t)
+	k := insertKey(t, "ops", 0)
+	h := adminAction("requeue", adminRequeue)
+
+	w := adminCall(t, h, http.MethodPost, "/admin/requeue", `{"urls": ["https://a.test/1", " https://a.test/2 "]}`, k)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"queued":2`) {
+		t.Fatalf("requeue: %d %s", w.Code, w.Body)
+	}
+	w = adminCall(t, h, http.MethodPost, "/admin/requeue", `{"urls": ["https://a.test/2", "https://a.test/3"]}`, k)
+	if !strings.Contains(w.Body.String(), `"already_pending":1`) || !strings.Contains(w.Body.String(), `"queued":1`) {
+		t.Errorf("pending URL queued again: %s", w.Body)
+	}
+	if e := lastAudit(t); e.Target != "https://a.test/2 and 1 more" {
+		t.Errorf("audit target = %q", e.Target)
+	}
+	var by string
+	if err := db.QueryRow(`SELECT requested_by FROM crawl_queue WHERE url = 'https://a.test/2'`).Scan(&by); err != nil || by != "ops" {
+		t.Errorf("requested_by = %q, %v", by, err)
+	}
+
+	for _, body := range []string{`{"urls": []}`, `{"urls": ["ftp://a.test/"]}`, `{"urls": ["/relative"]}`} {
+		if w := adminCall(t, h, http.MethodPost, "/admin/requeue", body, k); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", body, w.Code)
+		}
+	}
+	if n := countRows(t, "crawl_queue"); n != 3 {
+		t.Errorf("%d queued rows, want 3", n)
+	}
+}
+
+func TestAdminCategories(t *testing.T) {
+	useTestDB(t)
+	useTestCategories(t, `{"linux": ["kali.org"]}`)
+	k := insertKey(t, "ops", 0)
+	h := methods{
+		http.MethodGet: adminAction("categories.get", adminGetCategories),
+		http.MethodPut: adminAction("categories.put", adminPutCategories),
+	}
+
+	w := adminCall(t, h, http.MethodPut, "/admin/categories", `{"linux": ["kali.org", "archlinux.org"], "news": ["bbc.com"]}`, k)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"categories":2`) {
+		t.Fatalf("put: %d %s", w.Code, w.Body)
+	}
+	if set, _ := categories.get(); !reflect.DeepEqual(set.Names, []string{"linux", "news"}) {
+		t.Errorf("store not reloaded: %v", set.Names)
+	}
+	if bak, _ := os.ReadFile(catPath + ".bak"); string(bak) != `{"linux": ["kali.org"]}` {
+		t.Errorf("backup = %q", bak)
+	}
+
+	// a file the crawler would refuse is never written
+	before, _ := os.ReadFile(catPath)
+	w = adminCall(t, h, http.MethodPut, "/admin/categories", `{"linux": ["not a domain"]}`, k)
+	if after, _ := os.ReadFile(catPath); w.Code != http.StatusBadRequest || string(after) != string(before) {
+		t.Errorf("invalid put: %d, file changed: %v", w.Code, string(after) != string(before))
+	}
+	if e := lastAudit(t); e.Action != "categories.put" || e.Status != http.StatusBadRequest {
+		t.Errorf("audit row = %+v", e)
+	}
+
+	audited := countRows(t, "audit_log")
+	w = adminCall(t, h, http.MethodGet, "/admin/categories", "", k)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "archlinux.org") {
+		t.Errorf("get: %d %s", w.Code, w.Body)
+	}
+	if countRows(t, "audit_log") != audited {
+		t.Error("a read was audited")
+	}
+	w = adminCall(t, h, http.MethodDelete, "/admin/categories", "", k)
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET, PUT" {
+		t.Errorf("delete: %d, Allow %q", w.Code, w.Header().Get("Allow"))
+	}
+}
+
+func TestAdminAudit(t *testing.T) {
+	useTestDB(t)
+	k := insertKey(t, "ops", 0)
+	requeue := adminAction("requeue", adminRequeue)
+	for _, u := range []string{"https://a.test/1", "https://a.test/2", "https://a.test/3"} {
+		adminCall(t, requeue, http.MethodPost, "/admin/requeue", `{"urls": ["`+u+`"]}`, k)
+	}
+	adminCall(t, requeue, http.MethodPost, "/admin/requeue", `{"urls": ["https://a.test/4"]}`, nil)
+
+	h := adminAction("audit", adminAudit)
+	w := adminCall(t, h, http.MethodGet, "/admin/audit?limit=3", "", k)
+	var entries []auditEntry
+	if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil || len(entries) != 3 {
+		t.Fatalf("audit: %d %s", w.Code, w.Body)
+	}
+	if entries[0].Target != "https://a.test/4" || entries[0].KeyID != nil || entries[2].Target != "https://a.test/2" {
+		t.Errorf("entries not newest first: %+v", entries)
+	}
+	if entries[1].KeyID == nil || *entries[1].KeyID != k.ID || entries[1].KeyName != "ops" {
+		t.Errorf("key not recorded: %+v", entries[1])
+	}
+	if string(entries[1].Detail) != `{"already_pending":0,"queued":1}` {
+		t.Errorf("detail = %s", entries[1].Detail)
+	}
+	for _, limit := range []string{"0", "1001", "x"} {
+		if w := adminCall(t, h, http.MethodGet, "/admin/audit?limit="+limit, "", k); w.Code != http.StatusBadRequest {
+			t.Errorf("limit=%s: status %d", limit, w.Code)
+		}
+	}
+	if n := countRows(t, "audit_log"); n != 4 {
+		t.Errorf("%d audit rows, want the 4 changes only", n)
+	}
+}
+
+func TestAdminStats(t *testing.T) {
+	useTestDB(t)
+	insertKey(t, "ops", 0)
+	for i, c := range []string{"linux", "linux", "news"} {
+		if _, err := db.Exec(`INSERT INTO pages (url, title, snippet, category) VALUES (?, 'x', '', ?)`, fmt.Sprintf("https://%s.test/%d", c, i), c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w := adminCall(t, adminAction("stats", adminStats), http.MethodGet, "/admin/stats", "", nil)
+	var s indexStats
+	if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("stats: %d %s", w.Code, w.Body)
+	}
+	if s.Pages != 3 || s.ByCategory["linux"] != 2 || s.ByCategory["news"] != 1 || s.APIKeys != 1 || s.DBBytes <= 0 {
+		t.Errorf("stats = %+v", s)
+	}
+	if s.LastCrawl != "" {
+		t.Errorf("last crawl %q before any crawl", s.LastCrawl)
+	}
+}
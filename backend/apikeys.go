@@ -0,0 +1,454 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// apiKeysSchema holds API keys by SHA-256 hash only; the key itself is shown
+// once at creation. Usage is counted per key and UTC day.
+const apiKeysSchema = `
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		prefix TEXT NOT NULL,
+		hash TEXT NOT NULL UNIQUE,
+		scopes TEXT NOT NULL DEFAULT 'search',
+		tier TEXT NOT NULL DEFAULT 'free',
+		daily_quota INTEGER NOT NULL DEFAULT 0,
+		created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+		last_used_at TEXT,
+		revoked_at TEXT
+	);
+	CREATE TABLE IF NOT EXISTS api_key_usage (
+		key_id INTEGER NOT NULL REFERENCES api_keys(id),
+		day TEXT NOT NULL,
+		requests INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (key_id, day)
+	) WITHOUT ROWID;`
+
+// keyTier sets the defaults for a class of API keys
+type keyTier struct {
+	DailyQuota int     // requests per UTC day, 0 for unlimited
+	RateScale  float64 // multiplies KeyRateLimits
+}
+
+var KeyTiers = map[string]keyTier{
+	"free":     {DailyQuota: 1000, RateScale: 1},
+	"partner":  {DailyQuota: 100000, RateScale: 5},
+	"internal": {DailyQuota: 0, RateScale: 20},
+}
+
+const (
+	scopeSearch = "search" // /search, /v2/search, /page, /cache, /categories, /usage
+	scopeAdmin  = "admin"  // everything, including /admin
+)
+
+var knownScopes = []string{scopeSearch, scopeAdmin}
+
+// apiKey is an authenticated key as seen by handlers
+type apiKey struct {
+	ID         int64
+	Name       string
+	Scopes     []string
+	Tier       string
+	DailyQuota int
+}
+
+func (k *apiKey) hasScope(scope string) bool {
+	return slices.Contains(k.Scopes, scopeAdmin) || slices.Contains(k.Scopes, scope)
+}
+
+type apiKeyCtx struct{}
+
+// keyFromContext returns the key that authenticated the request, nil for anonymous ones
+func keyFromContext(ctx context.Context) *apiKey {
+	k, _ := ctx.Value(apiKeyCtx{}).(*apiKey)
+	return k
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// newKeySecret returns a random key; keys are long and random, so a plain
+// SHA-256 is enough to store them
+func newKeySecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "vk_" + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// --- Key lookup and quotas ---
+
+// requestAPIKey returns the key sent as X-API-Key or as a Bearer token
+func requestAPIKey(r *http.Request) string {
+	if k := strings.TrimSpace(r.Header.Get("X-API-Key")); k != "" {
+		return k
+	}
+	if auth := r.Header.Get("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+var errInvalidKey = errors.New("invalid or revoked API key")
+
+func lookupKey(secret string) (*apiKey, error) {
+	var k apiKey
+	var scopes string
+	err := db.QueryRow(`SELECT id, name, scopes, tier, daily_quota FROM api_keys WHERE hash = ? AND revoked_at IS NULL`,
+		hashKey(secret)).Scan(&k.ID, &k.Name, &scopes, &k.Tier, &k.DailyQuota)
+	if err == sql.ErrNoRows {
+		return nil, errInvalidKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	k.Scopes = strings.Split(scopes, ",")
+	return &k, nil
+}
+
+// countUsage adds one request to today's counter of k unless that would
+// exceed its quota; ok is false when the quota is used up
+func countUsage(k *apiKey, now time.Time) (ok bool, err error) {
+	quota := k.DailyQuota
+	if quota <= 0 {
+		quota = -1 // unlimited
+	}
+	res, err := db.Exec(`
+		INSERT INTO api_key_usage (key_id, day, requests) VALUES (?, ?, 1)
+		ON CONFLICT (key_id, day) DO UPDATE SET requests = requests + 1
+		WHERE ? < 0 OR requests < ?`,
+		k.ID, now.UTC().Format("2006-01-02"), quota, quota)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	_, err = db.Exec(`UPDATE api_keys SET last_used_at = ? WHERE id = ?`, now.UTC().Format(time.RFC3339), k.ID)
+	return true, err
+}
+
+// untilUTCMidnight is when daily quotas reset
+func untilUTCMidnight(now time.Time) time.Duration {
+	now = now.UTC()
+	return now.Truncate(24 * time.Hour).Add(24 * time.Hour).Sub(now)
+}
+
+// --- Middleware ---
+
+// authenticated resolves the API key sent with the request, if any, and
+// checks its scope. Requests without a key pass through anonymously unless
+// scope is admin.
+func authenticated(scope string) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			secret := requestAPIKey(r)
+			if secret == "" {
+				if scope == scopeAdmin {
+					w.Header().Set("WWW-Authenticate", `Bearer realm="veydhara"`)
+					respondJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "API key is required"})
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			k, err := lookupKey(secret)
+			if err != nil {
+				if err != errInvalidKey {
+					logError("API key lookup failed", err)
+					respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+					return
+				}
+				strikeClient(r) // repeated bad keys get the client banned like flooding does
+				w.Header().Set("WWW-Authenticate", `Bearer realm="veydhara", error="invalid_token"`)
+				respondJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "Invalid or revoked API key"})
+				return
+			}
+			if !k.hasScope(scope) {
+				respondJSON(w, http.StatusForbidden, ErrorResponse{Error: fmt.Sprintf("API key lacks the %q scope", scope)})
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyCtx{}, k)))
+		})
+	}
+}
+
+// metered counts the request against the daily quota of its API key. It
+// runs after rateLimited so throttled requests do not use up quota.
+func metered(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		k := keyFromContext(r.Context())
+		if k == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		now := time.Now()
+		ok, err := countUsage(k, now)
+		if err != nil {
+			logError("API key usage update failed", err)
+			respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+			return
+		}
+		if !ok {
+			logWarn(fmt.Sprintf("Daily quota exhausted for API key %d (%s)", k.ID, k.Name))
+			tooManyRequests(w, untilUTCMidnight(now), "Daily quota exhausted")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// --- /usage endpoint ---
+
+// keyUsage is the usage report of one key
+type keyUsage struct {
+	ID         int64        `json:"id"`
+	Name       string       `json:"name"`
+	Prefix     string       `json:"prefix"`
+	Scopes     []string     `json:"scopes"`
+	Tier       string       `json:"tier"`
+	DailyQuota int          `json:"daily_quota"` // 0 means unlimited
+	Today      int          `json:"today"`
+	Remaining  *int         `json:"remaining,omitempty"` // nil when unlimited
+	Total      int          `json:"total"`
+	CreatedAt  string       `json:"created_at"`
+	LastUsedAt string       `json:"last_used_at,omitempty"`
+	RevokedAt  string       `json:"revoked_at,omitempty"`
+	Days       []dailyUsage `json:"days,omitempty"`
+}
+
+type dailyUsage struct {
+	Day      string `json:"day"`
+	Requests int    `json:"requests"`
+}
+
+// getUsage reports the calling key's quota and the last 30 days of usage
+func getUsage(w http.ResponseWriter, r *http.Request) {
+	k := keyFromContext(r.Context())
+	if k == nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="veydhara"`)
+		respondJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "API key is required"})
+		return
+	}
+	u, err := loadKeyUsage(k.ID, 30)
+	if err != nil {
+		logError("Failed to load key usage", err)
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	respondJSON(w, http.StatusOK, u)
+}
+
+// loadKeyUsage reads the usage report of key id with up to days daily counts, newest first
+func loadKeyUsage(id int64, days int) (*keyUsage, error) {
+	var u keyUsage
+	var scopes string
+	var lastUsed, revoked sql.NullString
+	err := db.QueryRow(`
+		SELECT id, name, prefix, scopes, tier, daily_quota, created_at, last_used_at, revoked_at,
+			COALESCE((SELECT SUM(requests) FROM api_key_usage WHERE key_id = api_keys.id), 0)
+		FROM api_keys WHERE id = ?`, id).
+		Scan(&u.ID, &u.Name, &u.Prefix, &scopes, &u.Tier, &u.DailyQuota, &u.CreatedAt, &lastUsed, &revoked, &u.Total)
+	if err != nil {
+		return nil, err
+	}
+	u.Scopes = strings.Split(scopes, ",")
+	u.LastUsedAt, u.RevokedAt = lastUsed.String, revoked.String
+
+	rows, err := db.Query(`SELECT day, requests FROM api_key_usage WHERE key_id = ? ORDER BY day DESC LIMIT ?`, id, days)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	today := time.Now().UTC().Format("2006-01-02")
+	for rows.Next() {
+		var d dailyUsage
+		if err := rows.Scan(&d.Day, &d.Requests); err != nil {
+			return nil, err
+		}
+		if d.Day == today {
+			u.Today = d.Requests
+		}
+		u.Days = append(u.Days, d)
+	}
+	if u.DailyQuota > 0 {
+		remaining := max(u.DailyQuota-u.Today, 0)
+		u.Remaining = &remaining
+	}
+	return &u, rows.Err()
+}
+
+// --- keys command ---
+
+// runKeys manages API keys from the command line:
+//
+//	keys create -name NAME [-scopes search,admin] [-tier free] [-quota N]
+//	keys list [-all]
+//	keys revoke ID
+//	keys usage ID
+func runKeys(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: keys create|list|revoke|usage")
+	}
+	switch args[0] {
+	case "create":
+		return createKey(args[1:])
+	case "list":
+		return listKeys(args[1:])
+	case "revoke":
+		return revokeKey(args[1:])
+	case "usage":
+		if len(args) != 2 {
+			return errors.New("usage: keys usage ID")
+		}
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid key id %q", args[1])
+		}
+		u, err := loadKeyUsage(id, 30)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s (%s) today %d, total %d\n", u.Name, u.Prefix, u.Today, u.Total)
+		for _, d := range u.Days {
+			fmt.Printf("  %s  %d\n", d.Day, d.Requests)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown keys command %q", args[0])
+}
+
+func createKey(args []string) error {
+	fs := flag.NewFlagSet("keys create", flag.ExitOnError)
+	name := fs.String("name", "", "who the key is for")
+	scopes := fs.String("scopes", scopeSearch, "comma-separated scopes: search, admin")
+	tier := fs.String("tier", "free", "quota tier: free, partner, internal")
+	quota := fs.Int("quota", -1, "requests per UTC day, 0 for unlimited (default: the tier's)")
+	fs.Parse(args)
+
+	if *name == "" {
+		return errors.New("-name is required")
+	}
+	t, ok := KeyTiers[*tier]
+	if !ok {
+		return fmt.Errorf("unknown tier %q", *tier)
+	}
+	var scopeList []string
+	for _, s := range strings.Split(*scopes, ",") {
+		s = strings.TrimSpace(s)
+		if !slices.Contains(knownScopes, s) {
+			return fmt.Errorf("unknown scope %q", s)
+		}
+		if !slices.Contains(scopeList, s) {
+			scopeList = append(scopeList, s)
+		}
+	}
+	if *quota < 0 {
+		*quota = t.DailyQuota
+	}
+
+	secret, err := newKeySecret()
+	if err != nil {
+		return err
+	}
+	res, err := db.Exec(`INSERT INTO api_keys (name, prefix, hash, scopes, tier, daily_quota) VALUES (?, ?, ?, ?, ?, ?)`,
+		*name, secret[:10], hashKey(secret), strings.Join(scopeList, ","), *tier, *quota)
+	if err != nil {
+		return err
+	}
+	id, _ := res.LastInsertId()
+	fmt.Printf("Created key %d for %s (scopes %s, tier %s, quota %d/day)\n", id, *name, strings.Join(scopeList, ","), *tier, *quota)
+	fmt.Printf("\n    %s\n\nStore it now; it cannot be shown again.\n", secret)
+	return nil
+}
+
+func listKeys(args []string) error {
+	fs := flag.NewFlagSet("keys list", flag.ExitOnError)
+	all := fs.Bool("all", false, "include revoked keys")
+	fs.Parse(args)
+
+	query := `SELECT id FROM api_keys`
+	if !*all {
+		query += ` WHERE revoked_at IS NULL`
+	}
+	rows, err := db.Query(query + ` ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tPREFIX\tSCOPES\tTIER\tQUOTA\tTODAY\tTOTAL\tLAST USED\tREVOKED")
+	for _, id := range ids {
+		u, err := loadKeyUsage(id, 1)
+		if err != nil {
+			return err
+		}
+		quota := "unlimited"
+		if u.DailyQuota > 0 {
+			quota = strconv.Itoa(u.DailyQuota)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s…\t%s\t%s\t%s\t%d\t%d\t%s\t%s\n", u.ID, u.Name, u.Prefix, strings.Join(u.Scopes, ","),
+			u.Tier, quota, u.Today, u.Total, orDash(u.LastUsedAt), orDash(u.RevokedAt))
+	}
+	return tw.Flush()
+}
+
+func revokeKey(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: keys revoke ID")
+	}
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid key id %q", args[0])
+	}
+	res, err := db.Exec(`UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL`, time.Now().UTC().Format(time.RFC3339), id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("no active key with id %d", id)
+	}
+	fmt.Printf("Revoked key %d\n", id)
+	return nil
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useLegacyDB points db at a database in a temporary directory holding
+// only the original pages table, as the shipped database/search.db does
+func useLegacyDB(t *testing.T) {
+	t.Helper()
+	quietLogs(t)
+	old := db
+	var err error
+	db, err = sql.Open("sqlite3", filepath.Join(t.TempDir(), "search.db")+"?_busy_timeout=5000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		db = old
+	})
+	if _, err := db.Exec(`CREATE TABLE pages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		url TEXT,
+		title TEXT,
+		snippet TEXT,
+		category TEXT
+	)`); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// useTestDB is useLegacyDB migrated the way the server migrates at startup
+func useTestDB(t *testing.T) {
+	t.Helper()
+	useLegacyDB(t)
+	if err := migrateDB(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func insertKey(t *testing.T, name string, quota int) *apiKey {
+	t.Helper()
+	res, err := db.Exec(`INSERT INTO api_keys (name, prefix, hash, daily_quota) VALUES (?, ?, ?, ?)`,
+		name, "vk_test", hashKey(name), quota)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := res.LastInsertId()
+	return &apiKey{ID: id, Name: name, Scopes: []string{scopeSearch}, Tier: "free", DailyQuota: quota}
+}
+
+func usageOn(t *testing.T, k *apiKey, day string) int {
+	t.Helper()
+	var n int
+	err := db.QueryRow(`SELECT requests FROM api_key_usage WHERE key_id = ? AND day = ?`, k.ID, day).Scan(&n)
+	if err != nil && err != sql.ErrNoRows {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestCountUsageQuota(t *testing.T) {
+	useTestDB(t)
+	limited := insertKey(t, "limited", 3)
+	other := insertKey(t, "other", 3)
+	unlimited := insertKey(t, "unlimited", 0)
+	day1 := time.Date(2026, 3, 1, 23, 59, 0, 0, time.UTC)
+	day2 := day1.Add(2 * time.Minute)
+
+	steps := []struct {
+		k   *apiKey
+		now time.Time
+		ok  bool
+	}{
+		{limited, day1, true},
+		{limited, day1, true},
+		{limited, day1, true},
+		{limited, day1, false}, // quota used up
+		{limited, day1, false},
+		{other, day1, true},   // quotas are per key
+		{limited, day2, true}, // and per UTC day
+	}
+	for i, s := range steps {
+		ok, err := countUsage(s.k, s.now)
+		if err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if ok != s.ok {
+			t.Errorf("step %d: countUsage(%s) = %v, want %v", i, s.k.Name, ok, s.ok)
+		}
+	}
+	for range 10 {
+		if ok, err := countUsage(unlimited, day1); !ok || err != nil {
+			t.Fatalf("unlimited key refused: %v, %v", ok, err)
+		}
+	}
+
+	for _, tt := range []struct {
+		k    *apiKey
+		day  string
+		want int
+	}{
+		{limited, "2026-03-01", 3}, // refusals are not counted
+		{limited, "2026-03-02", 1},
+		{other, "2026-03-01", 1},
+		{unlimited, "2026-03-01", 10},
+	} {
+		if got := usageOn(t, tt.k, tt.day); got != tt.want {
+			t.Errorf("%s on %s: %d requests, want %d", tt.k.Name, tt.day, got, tt.want)
+		}
+	}
+
+	var lastUsed string
+	if err := db.QueryRow(`SELECT last_used_at FROM api_keys WHERE id = ?`, limited.ID).Scan(&lastUsed); err != nil {
+		t.Fatal(err)
+	}
+	if want := day2.Format(time.RFC3339); lastUsed != want {
+		t.Errorf("last_used_at = %s, want %s", lastUsed, want)
+	}
+}
+
+func TestMeteredQuotaExhausted(t *testing.T) {
+	useTestDB(t)
+	k := insertKey(t, "tiny", 1)
+	h := metered(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	get := func(k *apiKey) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/search", nil)
+		if k != nil {
+			r = r.WithContext(context.WithValue(r.Context(), apiKeyCtx{}, k))
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	if w := get(k); w.Code != http.StatusOK {
+		t.Fatalf("first request: status %d", w.Code)
+	}
+	w := get(k)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("over quota: status %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := get(nil); w.Code != http.StatusOK {
+		t.Errorf("anonymous request metered: status %d", w.Code)
+	}
+}
+
+func TestUntilUTCMidnight(t *testing.T) {
+	ist := time.FixedZone("IST", 5*3600+1800)
+	tests := []struct {
+		now  time.Time
+		want time.Duration
+	}{
+		{time.Date(2026, 3, 1, 23, 0, 0, 0, time.UTC), time.Hour},
+		{time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), 24 * time.Hour},
+		{time.Date(2026, 3, 2, 5, 0, 0, 0, ist), 30 * time.Minute}, // 23:30 UTC
+	}
+	for _, tt := range tests {
+		if got := untilUTCMidnight(tt.now); got != tt.want {
+			t.Errorf("untilUTCMidnight(%v) = %v, want %v", tt.now, got, tt.want)
+		}
+	}
+}
+
+func TestAuthenticated(t *testing.T) {
+	useTestDB(t)
+	useTestLimiter(t)
+	insertKey(t, "reader", 0) // the secret is the name, see insertKey
+	var seen *apiKey
+	h := func(scope string) http.Handler {
+		return authenticated(scope)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			seen = keyFromContext(r.Context())
+		}))
+	}
+	tests := []struct {
+		name, scope, header, value string
+		code                       int
+		body                       string
+	}{
+		{"anonymous search", scopeSearch, "", "", http.StatusOK, ""},
+		{"anonymous admin", scopeAdmin, "", "", http.StatusUnauthorized, "API key is required"},
+		{"key header", scopeSearch, "X-API-Key", "reader", http.StatusOK, ""},
+		{"bearer token", scopeSearch, "Authorization", "bearer reader", http.StatusOK, ""},
+		{"unknown key", scopeSearch, "X-API-Key", "nobody", http.StatusUnauthorized, `{"error":"Invalid or revoked API key"}`},
+		{"missing scope", scopeAdmin, "X-API-Key", "reader", http.StatusForbidden, `lacks the \"admin\" scope`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen = nil
+			r := httptest.NewRequest(http.MethodGet, "/search", nil)
+			r.RemoteAddr = "198.51.100.4:1000"
+			if tt.header != "" {
+				r.Header.Set(tt.header, tt.value)
+			}
+			w := httptest.NewRecorder()
+			h(tt.scope).ServeHTTP(w, r)
+			if w.Code != tt.code || !strings.Contains(w.Body.String(), tt.body) {
+				t.Errorf("status %d %s, want %d with %q", w.Code, w.Body, tt.code, tt.body)
+			}
+			if passed := tt.code == http.StatusOK && tt.value != ""; passed != (seen != nil && seen.Name == "reader") {
+				t.Errorf("handler saw key %+v", seen)
+			}
+		})
+	}
+	if len(limits.strikes["198.51.100.4"]) != 1 {
+		t.Errorf("unknown key gave %d strikes, want 1", len(limits.strikes["198.51.100.4"]))
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+
+	"sanitize"
+)
+
+// --- Cached page template ---
+
+const cacheStyle = `
+body { margin: 0; font: 16px/1.6 Georgia, "Noto Serif Devanagari", serif; color: #1d1d1d; background: #fbfaf7; }
+.banner { font: 14px/1.5 system-ui, sans-serif; background: #fff3d0; border-bottom: 3px solid #ff9933; padding: 0.8rem 1.2rem; }
+.banner a { color: #8a4b00; word-break: break-all; }
+main { max-width: 46rem; margin: 2rem auto; padding: 0 1.2rem; }
+main img { max-width: 100%; height: auto; }
+main pre { overflow-x: auto; background: #f0ede6; padding: 0.8rem; }
+main table { border-collapse: collapse; }
+main td, main th { border: 1px solid #ccc; padding: 0.3rem 0.5rem; }
+`
+
+// cacheCSP forbids scripts, frames, forms and plugins outright; the only
+// stylesheet allowed is the template's own, pinned by hash
+var cacheCSP = "default-src 'none'; style-src '" + cspHash(cacheStyle) + "'; img-src https: data:; " +
+	"base-uri 'none'; form-action 'none'; frame-ancestors 'self'"
+
+var cacheTemplate = template.Must(template.New("cache").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1.0">
+<meta name="referrer" content="no-referrer">
+<meta name="robots" content="noindex, nofollow">
+<title>{{.Title}} — VEDHARA cache</title>
+<style>` + cacheStyle + `</style>
+</head>
+<body>
+<div class="banner">
+This is VEDHARA's cached copy of <a href="{{.URL}}" rel="nofollow noopener noreferrer">{{.URL}}</a>, crawled {{.CrawledAt}}.
+Only the main content is shown; scripts, embeds and forms are removed. The live page may have changed since.
+</div>
+<main>
+<h1>{{.Title}}</h1>
+{{.Content}}
+</main>
+</body>
+</html>
+`))
+
+func cspHash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return "sha256-" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// --- /cache endpoint ---
+func getCachedPage(w http.ResponseWriter, r *http.Request) {
+	pageURL := strings.TrimSpace(r.URL.Query().Get("url"))
+	if pageURL == "" {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "URL parameter is required"})
+		return
+	}
+
+	logEvent("CacheView", pageURL)
+
+	// URLs that redirected during the crawl are stored as aliases of their target
+	var target string
+	if err := db.QueryRow("SELECT target FROM url_aliases WHERE alias = ?", pageURL).Scan(&target); err == nil {
+		pageURL = target
+	}
+
+	title, body, crawledAt, err := cachedPage(pageURL)
+	if err == sql.ErrNoRows {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{Error: "Page not found in cache"})
+		return
+	}
+	if err != nil {
+		logError("Failed to read cached page", err)
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if body == "" {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{Error: "No cached content stored for this page"})
+		return
+	}
+
+	crawled := "at an unknown date"
+	if t, err := time.Parse(time.RFC3339, crawledAt); err == nil {
+		crawled = "on " + t.UTC().Format("2 Jan 2006 15:04 MST")
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	h.Set("Content-Security-Policy", cacheCSP)
+	h.Set("X-Frame-Options", "SAMEORIGIN")
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Referrer-Policy", "no-referrer")
+	h.Set("Cache-Control", "private, max-age=300")
+	err = cacheTemplate.Execute(w, struct {
+		Title, URL, CrawledAt string
+		Content               template.HTML
+	}{title, pageURL, crawled, template.HTML(body)})
+	if err != nil {
+		logError("Failed to render cached page", err)
+	}
+}
+
+// cachedPage is the only reader of pages.content_html. The crawler stores
+// it sanitised, but rows from older crawls may not be, so the markup is
+// sanitised again here and never leaves this function raw. Pages without
+// markup fall back to their plain text content.
+func cachedPage(pageURL string) (title, body, crawledAt string, err error) {
+	var contentHTML, content string
+	err = db.QueryRow(`SELECT COALESCE(title, ''), COALESCE(content_html, ''), COALESCE(content, ''), COALESCE(crawled_at, '')
+		FROM pages WHERE url = ?`, pageURL).Scan(&title, &contentHTML, &content, &crawledAt)
+	if err != nil {
+		return "", "", "", err
+	}
+	body = sanitize.HTML(contentHTML, pageURL, rewriteCachedLinks)
+	if body == "" {
+		body = textToHTML(content)
+	}
+	return title, body, crawledAt, nil
+}
+
+// rewriteCachedLinks points links at /cache when the target page is stored,
+// and marks the rest as external
+func rewriteCachedLinks(links []*html.Node) {
+	if len(links) == 0 {
+		return
+	}
+	targets := map[string]bool{}
+	var args []interface{}
+	for _, a := range links {
+		if href := sanitize.Attr(a, "href"); !strings.HasPrefix(href, "#") && !targets[href] {
+			targets[href] = false
+			args = append(args, href)
+		}
+	}
+	for start := 0; start < len(args); start += 500 {
+		chunk := args[start:min(start+500, len(args))]
+		marks := strings.TrimSuffix(strings.Repeat("?, ", len(chunk)), ", ")
+		rows, err := db.Query(`SELECT url FROM pages WHERE url IN (`+marks+`)
+			UNION SELECT alias FROM url_aliases WHERE alias IN (`+marks+`)`, append(chunk, chunk...)...)
+		if err != nil {
+			logError("Cached link lookup failed", err)
+			break
+		}
+		for rows.Next() {
+			var u string
+			if rows.Scan(&u) == nil {
+				targets[u] = true
+			}
+		}
+		rows.Close()
+	}
+
+	for _, a := range links {
+		href := sanitize.Attr(a, "href")
+		if strings.HasPrefix(href, "#") {
+			continue
+		}
+		if targets[href] {
+			setAttr(a, "href", "/cache?url="+url.QueryEscape(href))
+			setAttr(a, "title", "Cached copy of "+href)
+			continue
+		}
+		setAttr(a, "rel", "nofollow noopener noreferrer")
+		setAttr(a, "target", "_blank")
+	}
+}
+
+func setAttr(n *html.Node, key, val string) {
+	for i := range n.Attr {
+		if n.Attr[i].Key == key {
+			n.Attr[i].Val = val
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: val})
+}
+
+// textToHTML renders plain stored content as escaped paragraphs, for pages
+// crawled before content_html existed
+func textToHTML(text string) string {
+	var sb strings.Builder
+	for _, para := range strings.Split(text, "\n") {
+		if para = strings.TrimSpace(para); para != "" {
+			sb.WriteString("<p>" + template.HTMLEscapeString(para) + "</p>\n")
+		}
+	}
+	return sb.String()
+}
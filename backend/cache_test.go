@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestGetCachedPage(t *testing.T) {
+	useLegacyDB(t)
+	// a row from before the crawler stored content, crawl times or domains
+	if _, err := db.Exec(`INSERT INTO pages (url, title, snippet, category) VALUES ('https://a.test/old', 'Old', 'old page', 'linux')`); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateDB(); err != nil {
+		t.Fatalf("migrateDB on a legacy database: %v", err)
+	}
+	for _, q := range []string{
+		`INSERT INTO pages (url, title, category, crawled_at, content, content_html) VALUES
+			('https://a.test/doc', 'Doc', 'linux', '2026-03-01T10:00:00Z', 'Doc text',
+			 '<p onclick="x()">Hello <a href="/old">old</a> <a href="https://b.test/">b</a></p><script>alert(1)</script>'),
+			('https://a.test/text', 'Text', 'linux', NULL, 'first line
+second <line>', '')`,
+		`INSERT INTO url_aliases (alias, target) VALUES ('http://a.test/doc', 'https://a.test/doc')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		url      string
+		code     int
+		contains []string
+		lacks    []string
+	}{
+		{"no url", "", http.StatusBadRequest, nil, nil},
+		{"not stored", "https://a.test/missing", http.StatusNotFound, nil, nil},
+		{"legacy row without content", "https://a.test/old", http.StatusNotFound, []string{"No cached content"}, nil},
+		{
+			"sanitised markup", "https://a.test/doc", http.StatusOK,
+			[]string{"<p>Hello", "crawled on 1 Mar 2026 10:00 UTC", `href="/cache?url=https%3A%2F%2Fa.test%2Fold"`, `href="https://b.test/" rel="nofollow noopener noreferrer"`},
+			[]string{"<script", "onclick"},
+		},
+		{"redirect alias", "http://a.test/doc", http.StatusOK, []string{"<p>Hello"}, nil},
+		{"plain text fallback", "https://a.test/text", http.StatusOK, []string{"<p>first line</p>", "<p>second &lt;line&gt;</p>", "crawled at an unknown date"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/cache?url="+url.QueryEscape(tt.url), nil)
+			w := httptest.NewRecorder()
+			getCachedPage(w, r)
+			body := w.Body.String()
+			if w.Code != tt.code {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.code, body)
+			}
+			for _, s := range tt.contains {
+				if !strings.Contains(body, s) {
+					t.Errorf("body lacks %q:\n%s", s, body)
+				}
+			}
+			for _, s := range tt.lacks {
+				if strings.Contains(body, s) {
+					t.Errorf("body contains %q:\n%s", s, body)
+				}
+			}
+			if w.Code == http.StatusOK && w.Header().Get("Content-Security-Policy") != cacheCSP {
+				t.Error("cached page served without its CSP")
+			}
+		})
+	}
+}
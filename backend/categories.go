@@ -0,0 +1,236 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"siteconfig"
+)
+
+// CategoriesPollInterval is how often categories.json is checked for changes
+var CategoriesPollInterval = 2 * time.Second
+
+// categorySet is one validated version of categories.json. It is never
+// modified after parseCategories returns it.
+type categorySet struct {
+	Names    []string                     // sorted, see sortCategoryNames
+	Sites    map[string][]siteconfig.Site // by category name
+	Meta     map[string]siteconfig.Meta   // by category name
+	LoadedAt time.Time
+}
+
+// parseCategories decodes and validates categories.json with the crawler's
+// rules (see siteconfig.Parse), so the server never serves, or the admin API
+// writes, a file the crawler would refuse to start with.
+func parseCategories(data []byte) (*categorySet, error) {
+	parsed, err := siteconfig.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	set := &categorySet{
+		Sites:    parsed.Sites,
+		Meta:     parsed.Meta,
+		LoadedAt: time.Now(),
+	}
+	for name := range parsed.Sites {
+		set.Names = append(set.Names, name)
+	}
+	sortCategoryNames(set.Names)
+	return set, nil
+}
+
+// sortCategoryNames orders names case-insensitively, byte order breaking ties,
+// so every load lists categories the same way
+func sortCategoryNames(names []string) {
+	sort.Slice(names, func(i, j int) bool {
+		a, b := strings.ToLower(names[i]), strings.ToLower(names[j])
+		if a != b {
+			return a < b
+		}
+		return names[i] < names[j]
+	})
+}
+
+// --- Categories store ---
+
+// categoryStore holds the current categories.json. Readers get an immutable
+// snapshot; a changed file is swapped in only once it parses, so a bad edit
+// leaves the last good version in service.
+type categoryStore struct {
+	path    string
+	current atomic.Pointer[categorySet]
+
+	mu      sync.Mutex // serialises reloads
+	modTime time.Time
+	size    int64
+	lastErr error
+}
+
+var categories *categoryStore
+
+func newCategoryStore(path string) *categoryStore {
+	return &categoryStore{path: path}
+}
+
+// get returns the current categories, or the load error if no version has
+// loaded yet
+func (s *categoryStore) get() (*categorySet, error) {
+	if set := s.current.Load(); set != nil {
+		return set, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lastErr == nil {
+		return nil, errors.New("categories not loaded")
+	}
+	return nil, s.lastErr
+}
+
+// reload reads the file when its mtime or size changed since the last
+// attempt (always when force is set) and swaps it in if valid. changed
+// reports whether the file was looked at anew, so callers log each bad
+// version once.
+func (s *categoryStore) reload(force bool) (changed bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fi, err := os.Stat(s.path)
+	if err != nil {
+		changed = force || s.size != -1
+		s.modTime, s.size, s.lastErr = time.Time{}, -1, err
+		return changed, err
+	}
+	if !force && fi.ModTime().Equal(s.modTime) && fi.Size() == s.size {
+		return false, s.lastErr
+	}
+	s.modTime, s.size = fi.ModTime(), fi.Size()
+	data, err := os.ReadFile(s.path)
+	if err == nil {
+		var set *categorySet
+		if set, err = parseCategories(data); err == nil {
+			s.current.Store(set)
+		}
+	}
+	s.lastErr = err
+	return true, err
+}
+
+// watch polls the file every interval for the life of the process
+func (s *categoryStore) watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		before := s.current.Load()
+		changed, err := s.reload(false)
+		if !changed {
+			continue
+		}
+		if err != nil {
+			if before != nil {
+				logWarn(fmt.Sprintf("categories.json not reloaded, keeping the version from %s: %v",
+					before.LoadedAt.Format(time.DateTime), err))
+			} else {
+				logWarn(fmt.Sprintf("categories.json not loaded: %v", err))
+			}
+			continue
+		}
+		logEvent("Categories", fmt.Sprintf("Reloaded categories.json: %d categories", len(s.current.Load().Names)))
+	}
+}
+
+// --- /categories response ---
+
+// CategoryStatsTTL is how long page counts and crawl times are reused
+var CategoryStatsTTL = time.Minute
+
+// categoryInfo is one entry of the /categories response
+type categoryInfo struct {
+	Name        string   `json:"name"`  // the value /search takes
+	Label       string   `json:"label"` // display name, the name when none is set
+	LabelHi     string   `json:"label_hi,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Pages       int64    `json:"pages"`
+	Domains     []string `json:"domains"`
+	LastCrawl   string   `json:"last_crawl,omitempty"` // newest successful fetch, RFC 3339
+}
+
+// categoryStats caches per-category counts, keyed by lower-cased name as
+// /search matches categories case-insensitively
+var categoryStats struct {
+	sync.Mutex
+	at        time.Time
+	pages     map[string]int64
+	lastCrawl map[string]string
+}
+
+func loadCategoryStats() (pages map[string]int64, lastCrawl map[string]string, err error) {
+	categoryStats.Lock()
+	defer categoryStats.Unlock()
+	if time.Since(categoryStats.at) < CategoryStatsTTL {
+		return categoryStats.pages, categoryStats.lastCrawl, nil
+	}
+	pages, lastCrawl = map[string]int64{}, map[string]string{}
+	rows, err := db.Query(`SELECT LOWER(category), COUNT(*) FROM pages GROUP BY LOWER(category)`)
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var cat sql.NullString
+		var n int64
+		if err := rows.Scan(&cat, &n); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		pages[cat.String] = n
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	// fetch_log only exists once the crawler has run
+	if rows, err := db.Query(`SELECT LOWER(category), MAX(fetched_at) FROM fetch_log
+		WHERE error_class IN ('ok', 'truncated') GROUP BY LOWER(category)`); err == nil {
+		for rows.Next() {
+			var cat, at sql.NullString
+			if rows.Scan(&cat, &at) == nil {
+				lastCrawl[cat.String] = at.String
+			}
+		}
+		rows.Close()
+	}
+	categoryStats.at, categoryStats.pages, categoryStats.lastCrawl = time.Now(), pages, lastCrawl
+	return pages, lastCrawl, nil
+}
+
+// describeCategories builds the /categories response in the order of set.Names
+func describeCategories(set *categorySet) ([]categoryInfo, error) {
+	pages, lastCrawl, err := loadCategoryStats()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]categoryInfo, 0, len(set.Names))
+	for _, name := range set.Names {
+		meta := set.Meta[name]
+		info := categoryInfo{
+			Name:        name,
+			Label:       meta.Label,
+			LabelHi:     meta.LabelHi,
+			Description: meta.Description,
+			Pages:       pages[strings.ToLower(name)],
+			Domains:     make([]string, 0, len(set.Sites[name])),
+			LastCrawl:   lastCrawl[strings.ToLower(name)],
+		}
+		if info.Label == "" {
+			info.Label = name
+		}
+		for _, s := range set.Sites[name] {
+			info.Domains = append(info.Domains, s.Domain)
+		}
+		out = append(out, info)
+	}
+	return out, nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// writeCategories replaces the store's file, stepping its mtime forward so
+// a same-size edit is still seen as a change
+func writeCategories(t *testing.T, s *categoryStore, data string) {
+	t.Helper()
+	if err := os.WriteFile(s.path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	next := time.Now().Add(time.Second)
+	if !s.modTime.IsZero() && !next.After(s.modTime) {
+		next = s.modTime.Add(time.Second)
+	}
+	if err := os.Chtimes(s.path, next, next); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func storeNames(t *testing.T, s *categoryStore) []string {
+	t.Helper()
+	set, err := s.get()
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	return set.Names
+}
+
+func TestCategoryStoreReload(t *testing.T) {
+	s := newCategoryStore(filepath.Join(t.TempDir(), "categories.json"))
+
+	// nothing served before the first good load, and the error says why
+	if _, err := s.reload(false); err == nil {
+		t.Fatal("missing file loaded")
+	}
+	if _, err := s.get(); !os.IsNotExist(err) {
+		t.Errorf("get before any load: %v, want the not-exist error", err)
+	}
+	if changed, _ := s.reload(false); changed {
+		t.Error("a file still missing was reported as changed")
+	}
+
+	steps := []struct {
+		name    string
+		data    string // "" removes the file
+		changed bool
+		wantErr bool
+		names   []string // served afterwards
+	}{
+		{"created", `{"linux": ["kali.org"], "News": ["bbc.com"]}`, true, false, []string{"linux", "News"}},
+		{"untouched", "-", false, false, []string{"linux", "News"}},
+		{"edited", `{"linux": ["kali.org"], "news": ["bbc.com"]}`, true, false, []string{"linux", "news"}},
+		{"broken edit keeps the last good version", `{"linux": [`, true, true, []string{"linux", "news"}},
+		{"the same bad version is reported once", "-", false, true, []string{"linux", "news"}},
+		{"invalid domains are refused like the crawler does", `{"linux": ["kali org"]}`, true, true, []string{"linux", "news"}},
+		{"removed", "", true, true, []string{"linux", "news"}},
+		{"still removed", "-", false, true, []string{"linux", "news"}},
+		{"fixed", `{"shopping": ["amazon.in"]}`, true, false, []string{"shopping"}},
+	}
+	for _, st := range steps {
+		switch st.data {
+		case "-":
+		case "":
+			if err := os.Remove(s.path); err != nil {
+				t.Fatal(err)
+			}
+		default:
+			writeCategories(t, s, st.data)
+		}
+		changed, err := s.reload(false)
+		if changed != st.changed || (err != nil) != st.wantErr {
+			t.Errorf("%s: reload = %v, %v; want changed=%v, error=%v", st.name, changed, err, st.changed, st.wantErr)
+		}
+		if got := storeNames(t, s); !reflect.DeepEqual(got, st.names) {
+			t.Errorf("%s: serving %q, want %q", st.name, got, st.names)
+		}
+	}
+
+	// force rereads an unchanged file
+	before, _ := s.get()
+	if changed, err := s.reload(true); !changed || err != nil {
+		t.Errorf("forced reload = %v, %v", changed, err)
+	}
+	if after, _ := s.get(); after == before {
+		t.Error("forced reload kept the old snapshot")
+	}
+}
+
+func TestCategorySetsAreSnapshots(t *testing.T) {
+	s := newCategoryStore(filepath.Join(t.TempDir(), "categories.json"))
+	writeCategories(t, s, `{"linux": ["kali.org"]}`)
+	if _, err := s.reload(false); err != nil {
+		t.Fatal(err)
+	}
+	held, _ := s.get()
+	writeCategories(t, s, `{"news": ["bbc.com"], "linux": ["archlinux.org"]}`)
+	if _, err := s.reload(false); err != nil {
+		t.Fatal(err)
+	}
+	// a reader holding the old set keeps a consistent view
+	if !reflect.DeepEqual(held.Names, []string{"linux"}) || held.Sites["linux"][0].Domain != "kali.org" {
+		t.Errorf("held snapshot changed: %v %v", held.Names, held.Sites)
+	}
+	if got := storeNames(t, s); !reflect.DeepEqual(got, []string{"linux", "news"}) {
+		t.Errorf("serving %q", got)
+	}
+}
+
+// freshCategoryStats drops cached /categories counts for the test
+func freshCategoryStats(t *testing.T) {
+	t.Helper()
+	categoryStats.Lock()
+	categoryStats.at = time.Time{}
+	categoryStats.Unlock()
+	t.Cleanup(func() {
+		categoryStats.Lock()
+		categoryStats.at = time.Time{}
+		categoryStats.Unlock()
+	})
+}
+
+func TestGetCategories(t *testing.T) {
+	useTestDB(t)
+	freshCategoryStats(t)
+	useTestCategories(t, `{
+		"shopping": ["amazon.in", "flipkart.com"],
+		"technology-news": {"label": "Technology News", "label_hi": "प्रौद्योगिकी समाचार", "description": "Tech press", "sites": ["wired.com"]},
+		"Linux": ["kali.org"],
+		"empty": []
+	}`)
+	for _, p := range []struct{ url, category string }{
+		{"https://kali.org/", "Linux"}, {"https://kali.org/docs/", "linux"},
+		{"https://amazon.in/", "shopping"}, {"https://old.test/", "retired"},
+	} {
+		if _, err := db.Exec(`INSERT INTO pages (url, title, snippet, category) VALUES (?, 'x', '', ?)`, p.url, p.category); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec(`CREATE TABLE fetch_log (url TEXT, category TEXT, error_class TEXT, fetched_at TEXT);
+		INSERT INTO fetch_log VALUES
+			('https://kali.org/', 'linux', 'ok', '2026-10-01T10:00:00Z'),
+			('https://kali.org/x', 'Linux', 'truncated', '2026-10-02T10:00:00Z'),
+			('https://kali.org/y', 'linux', 'http_5xx', '2026-10-03T10:00:00Z')`); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func() []categoryInfo {
+		t.Helper()
+		w := httptest.NewRecorder()
+		getCategories(w, httptest.NewRequest(http.MethodGet, "/categories", nil))
+		var list []categoryInfo
+		if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", w.Code, w.Body)
+		}
+		return list
+	}
+	want := []categoryInfo{
+		{Name: "empty", Label: "empty", Domains: []string{}},
+		{Name: "Linux", Label: "Linux", Pages: 2, Domains: []string{"kali.org"}, LastCrawl: "2026-10-02T10:00:00Z"},
+		{Name: "shopping", Label: "shopping", Pages: 1, Domains: []string{"amazon.in", "flipkart.com"}},
+		{Name: "technology-news", Label: "Technology News", LabelHi: "प्रौद्योगिकी समाचार", Description: "Tech press", Domains: []string{"wired.com"}},
+	}
+	if got := get(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got  %+v\nwant %+v", got, want)
+	}
+
+	// counts are cached for CategoryStatsTTL; the category list is not
+	if _, err := db.Exec(`INSERT INTO pages (url, title, snippet, category) VALUES ('https://flipkart.com/', 'x', '', 'shopping')`); err != nil {
+		t.Fatal(err)
+	}
+	writeCategories(t, categories, `{"shopping": ["amazon.in"]}`)
+	if _, err := categories.reload(false); err != nil {
+		t.Fatal(err)
+	}
+	if got := get(); len(got) != 1 || got[0].Pages != 1 {
+		t.Errorf("after reload: %+v, want shopping alone with the cached count", got)
+	}
+	freshCategoryStats(t)
+	if got := get(); got[0].Pages != 2 {
+		t.Errorf("after the cache expired: %d shopping pages, want 2", got[0].Pages)
+	}
+}
+
+func TestGetCategoriesUnavailable(t *testing.T) {
+	quietLogs(t)
+	old := categories
+	categories = newCategoryStore(filepath.Join(t.TempDir(), "missing.json"))
+	t.Cleanup(func() { categories = old })
+	categories.reload(false)
+	w := httptest.NewRecorder()
+	getCategories(w, httptest.NewRequest(http.MethodGet, "/categories", nil))
+	if w.Code != http.StatusInternalServerError || !json.Valid(w.Body.Bytes()) {
+		t.Errorf("status %d: %s", w.Code, w.Body)
+	}
+}
@@ -3,12 +3,22 @@ module searchengine
 go 1.25.1
 
 require (
+	frontend v0.0.0
 	github.com/fatih/color v1.18.0
 	github.com/mattn/go-sqlite3 v1.14.32
+	golang.org/x/net v0.39.0
+	sanitize v0.0.0
+	siteconfig v0.0.0
 )
 
 require (
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
-	golang.org/x/sys v0.25.0 // indirect
+	golang.org/x/sys v0.32.0 // indirect
 )
+
+replace frontend => ../frontend
+
+replace siteconfig => ../siteconfig
+
+replace sanitize => ../sanitize
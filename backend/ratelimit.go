@@ -0,0 +1,291 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimit is a token bucket: Burst requests at once, refilled at PerSecond
+type rateLimit struct {
+	PerSecond float64
+	Burst     float64
+}
+
+var (
+	// RateLimits are per client IP and endpoint; endpoints not listed use DefaultRateLimit
+	RateLimits = map[string]rateLimit{
+		"/search": {PerSecond: 1, Burst: 10},
+		"/page":   {PerSecond: 2, Burst: 20},
+		"/cache":  {PerSecond: 1, Burst: 10},
+	}
+	DefaultRateLimit = rateLimit{PerSecond: 5, Burst: 50}
+
+	// KeyRateLimits apply per API key instead of the IP limits, across all IPs
+	// using the key, and are scaled by the key's tier
+	KeyRateLimits = map[string]rateLimit{
+		"/search": {PerSecond: 10, Burst: 100},
+	}
+	DefaultKeyRateLimit = rateLimit{PerSecond: 20, Burst: 200}
+
+	// a client rejected BanThreshold times within BanWindow is refused outright for BanDuration
+	BanThreshold = 100
+	BanWindow    = time.Minute
+	BanDuration  = 15 * time.Minute
+
+	trustedProxies []*net.IPNet // set from -trusted-proxies
+)
+
+// --- Client identification ---
+
+// setTrustedProxies parses a comma-separated list of IPs and CIDRs whose
+// X-Forwarded-For headers are believed
+func setTrustedProxies(list string) error {
+	trustedProxies = nil
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			if ip := net.ParseIP(s); ip != nil && ip.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy %q", s)
+		}
+		trustedProxies = append(trustedProxies, n)
+	}
+	return nil
+}
+
+func isTrustedProxy(ip net.IP) bool {
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP is the address the request came from. X-Forwarded-For is only
+// read when the peer is a trusted proxy, and then from the right: the first
+// hop that is not itself a trusted proxy is the client, since anything left
+// of it can be forged.
+func clientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isTrustedProxy(ip) {
+		return ip
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break // garbage; stop at the last address we could verify
+		}
+		ip = hop
+		if !isTrustedProxy(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// clientKey groups IPv6 clients by /64, which a single host usually owns whole
+func clientKey(ip net.IP) string {
+	if ip == nil {
+		return "unknown"
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.String()
+	}
+	return ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+}
+
+// --- Token buckets ---
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// limiter holds one bucket per client and endpoint, plus the ban list
+type limiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	strikes map[string][]time.Time // recent rejections per client
+	banned  map[string]time.Time   // client → end of ban
+}
+
+// limits is set up by main, which also starts its sweeper
+var limits *limiter
+
+func newLimiter() *limiter {
+	return &limiter{
+		buckets: map[string]*bucket{},
+		strikes: map[string][]time.Time{},
+		banned:  map[string]time.Time{},
+	}
+}
+
+// take spends a token from the bucket for key. When none is left it reports
+// how long until one is.
+func (l *limiter) take(key string, rl rateLimit, now time.Time) (ok bool, remaining int, wait time.Duration) {
+	b := l.buckets[key]
+	if b == nil {
+		b = &bucket{tokens: rl.Burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(rl.Burst, b.tokens+now.Sub(b.last).Seconds()*rl.PerSecond)
+	b.last = now
+	if b.tokens < 1 {
+		return false, 0, time.Duration((1 - b.tokens) / rl.PerSecond * float64(time.Second))
+	}
+	b.tokens--
+	return true, int(b.tokens), 0
+}
+
+// strike records a rejection and bans the client once it has too many
+func (l *limiter) strike(client string, now time.Time) {
+	recent := l.strikes[client][:0]
+	for _, t := range l.strikes[client] {
+		if now.Sub(t) < BanWindow {
+			recent = append(recent, t)
+		}
+	}
+	recent = append(recent, now)
+	l.strikes[client] = recent
+	if len(recent) >= BanThreshold {
+		l.banned[client] = now.Add(BanDuration)
+		delete(l.strikes, client)
+		logWarn(fmt.Sprintf("Banned %s for %s after %d rejected requests", client, BanDuration, BanThreshold))
+	}
+}
+
+// bannedFor returns how long client stays banned, 0 when it is not
+func (l *limiter) bannedFor(client string, now time.Time) time.Duration {
+	until, ok := l.banned[client]
+	if !ok {
+		return 0
+	}
+	if now.After(until) {
+		delete(l.banned, client)
+		return 0
+	}
+	return until.Sub(now)
+}
+
+// sweep drops buckets that have refilled and expired strikes and bans, so
+// memory stays proportional to active clients
+func (l *limiter) sweep() {
+	for range time.Tick(time.Minute) {
+		now := time.Now()
+		l.mu.Lock()
+		for k, b := range l.buckets {
+			if now.Sub(b.last) > 10*time.Minute {
+				delete(l.buckets, k)
+			}
+		}
+		for c, ts := range l.strikes {
+			if len(ts) == 0 || now.Sub(ts[len(ts)-1]) > BanWindow {
+				delete(l.strikes, c)
+			}
+		}
+		for c, until := range l.banned {
+			if now.After(until) {
+				delete(l.banned, c)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// --- Middleware ---
+
+// notBanned refuses banned clients. It runs before authenticated so a banned
+// client cannot keep guessing API keys, each guess costing a lookup.
+func notBanned(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limits.mu.Lock()
+		d := limits.bannedFor(clientKey(clientIP(r)), time.Now())
+		limits.mu.Unlock()
+		if d > 0 {
+			tooManyRequests(w, d, "Too many requests; client temporarily blocked")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// strikeClient records a rejection of r's client that no bucket saw, such as
+// an invalid API key, towards its ban
+func strikeClient(r *http.Request) {
+	limits.mu.Lock()
+	limits.strike(clientKey(clientIP(r)), time.Now())
+	limits.mu.Unlock()
+}
+
+// rateLimited enforces the limits for endpoint: the API key's bucket for
+// requests authenticated by a key (see authenticated), the client IP's bucket
+// for the rest. Rejections get 429 with Retry-After and count towards a ban,
+// which notBanned enforces.
+func rateLimited(endpoint string) middleware {
+	ipLimit, ok := RateLimits[endpoint]
+	if !ok {
+		ipLimit = DefaultRateLimit
+	}
+	keyLimit, ok := KeyRateLimits[endpoint]
+	if !ok {
+		keyLimit = DefaultKeyRateLimit
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client := clientKey(clientIP(r))
+			now := time.Now()
+
+			limits.mu.Lock()
+			var allowed bool
+			var remaining int
+			var wait time.Duration
+			if k := keyFromContext(r.Context()); k != nil {
+				rl := keyLimit
+				if t, ok := KeyTiers[k.Tier]; ok && t.RateScale > 0 {
+					rl = rateLimit{PerSecond: rl.PerSecond * t.RateScale, Burst: rl.Burst * t.RateScale}
+				}
+				allowed, remaining, wait = limits.take(fmt.Sprintf("key|%d|%s", k.ID, endpoint), rl, now)
+			} else {
+				allowed, remaining, wait = limits.take("ip|"+client+"|"+endpoint, ipLimit, now)
+			}
+			if !allowed {
+				limits.strike(client, now)
+			}
+			limits.mu.Unlock()
+
+			if !allowed {
+				logWarn(fmt.Sprintf("Rate limited %s on %s", client, endpoint))
+				tooManyRequests(w, wait, "Too many requests")
+				return
+			}
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func tooManyRequests(w http.ResponseWriter, wait time.Duration, msg string) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(max(wait.Seconds(), 1)))))
+	respondJSON(w, http.StatusTooManyRequests, ErrorResponse{Error: msg})
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// quietLogs discards the server log for the test
+func quietLogs(t *testing.T) {
+	t.Helper()
+	old := logger
+	logger = log.New(io.Discard, "", 0)
+	t.Cleanup(func() { logger = old })
+}
+
+// useTestLimiter swaps in an empty limiter without a sweeper
+func useTestLimiter(t *testing.T) *limiter {
+	t.Helper()
+	old := limits
+	limits = newLimiter()
+	t.Cleanup(func() { limits = old })
+	return limits
+}
+
+func setBanPolicy(t *testing.T, threshold int, window, duration time.Duration) {
+	t.Helper()
+	oldT, oldW, oldD := BanThreshold, BanWindow, BanDuration
+	BanThreshold, BanWindow, BanDuration = threshold, window, duration
+	t.Cleanup(func() { BanThreshold, BanWindow, BanDuration = oldT, oldW, oldD })
+}
+
+func TestLimiterTake(t *testing.T) {
+	l := useTestLimiter(t)
+	rl := rateLimit{PerSecond: 2, Burst: 3}
+	t0 := time.Unix(1_700_000_000, 0)
+
+	steps := []struct {
+		after     time.Duration // since t0
+		key       string
+		ok        bool
+		remaining int
+		wait      time.Duration
+	}{
+		{0, "a", true, 2, 0},
+		{0, "a", true, 1, 0},
+		{0, "a", true, 0, 0},
+		{0, "a", false, 0, 500 * time.Millisecond},
+		{0, "b", true, 2, 0}, // buckets are per key
+		{250 * time.Millisecond, "a", false, 0, 250 * time.Millisecond},
+		{500 * time.Millisecond, "a", true, 0, 0},
+		{time.Hour, "a", true, 2, 0}, // refills up to Burst only
+	}
+	for i, s := range steps {
+		ok, remaining, wait := l.take(s.key, rl, t0.Add(s.after))
+		if ok != s.ok || remaining != s.remaining || (wait-s.wait).Abs() > time.Millisecond {
+			t.Errorf("step %d: take = %v, %d, %v; want %v, %d, %v", i, ok, remaining, wait, s.ok, s.remaining, s.wait)
+		}
+	}
+}
+
+func TestLimiterStrikesAndBans(t *testing.T) {
+	quietLogs(t)
+	l := useTestLimiter(t)
+	setBanPolicy(t, 3, time.Minute, 15*time.Minute)
+	t0 := time.Unix(1_700_000_000, 0)
+
+	// strikes spread wider than BanWindow never add up to a ban
+	for i := range 5 {
+		l.strike("1.2.3.4", t0.Add(time.Duration(i)*40*time.Second))
+	}
+	if d := l.bannedFor("1.2.3.4", t0.Add(3*time.Minute)); d != 0 {
+		t.Fatalf("banned for %v after spread-out strikes", d)
+	}
+
+	now := t0.Add(10 * time.Minute)
+	l.strike("5.6.7.8", now)
+	l.strike("5.6.7.8", now.Add(time.Second))
+	if d := l.bannedFor("5.6.7.8", now.Add(time.Second)); d != 0 {
+		t.Fatalf("banned for %v below the threshold", d)
+	}
+	l.strike("5.6.7.8", now.Add(2*time.Second))
+	if d := l.bannedFor("5.6.7.8", now.Add(2*time.Second)); d != 15*time.Minute {
+		t.Errorf("banned for %v, want 15m", d)
+	}
+	if d := l.bannedFor("9.9.9.9", now); d != 0 {
+		t.Errorf("another client banned for %v", d)
+	}
+	if d := l.bannedFor("5.6.7.8", now.Add(20*time.Minute)); d != 0 {
+		t.Errorf("ban still on after it expired: %v", d)
+	}
+	if _, ok := l.banned["5.6.7.8"]; ok {
+		t.Error("expired ban not removed")
+	}
+}
+
+func TestRateLimitedMiddleware(t *testing.T) {
+	quietLogs(t)
+	useTestLimiter(t)
+	setBanPolicy(t, 3, time.Minute, 15*time.Minute)
+	old := RateLimits
+	RateLimits = map[string]rateLimit{"/t": {PerSecond: 0.001, Burst: 2}}
+	t.Cleanup(func() { RateLimits = old })
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := chain(ok, notBanned, rateLimited("/t"))
+	get := func(remote string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/t", nil)
+		r.RemoteAddr = remote
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	want := []int{200, 200, 429, 429, 429, 429}
+	for i, code := range want {
+		w := get("10.0.0.1:1234")
+		if w.Code != code {
+			t.Fatalf("request %d: status %d, want %d", i, w.Code, code)
+		}
+		if code == 429 && w.Header().Get("Retry-After") == "" {
+			t.Errorf("request %d: 429 without Retry-After", i)
+		}
+	}
+	// the third rejection banned the client: its answer says so
+	if d := limits.bannedFor("10.0.0.1", time.Now()); d <= 0 {
+		t.Error("client not banned after three rejections")
+	}
+	if w := get("10.0.0.2:1234"); w.Code != 200 {
+		t.Errorf("other client got %d", w.Code)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	if err := setTrustedProxies("127.0.0.1, 10.0.0.0/8"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { setTrustedProxies("") })
+	tests := []struct {
+		remote, xff, want string
+	}{
+		{"203.0.113.9:5000", "", "203.0.113.9"},
+		// an untrusted peer's header is ignored
+		{"203.0.113.9:5000", "1.1.1.1", "203.0.113.9"},
+		// read from the right, skipping trusted hops; forged entries to the left are ignored
+		{"127.0.0.1:5000", "6.6.6.6, 198.51.100.7, 10.1.2.3", "198.51.100.7"},
+		{"127.0.0.1:5000", "garbage, 198.51.100.7", "198.51.100.7"},
+		{"127.0.0.1:5000", "198.51.100.7, garbage", "127.0.0.1"},
+		{"127.0.0.1:5000", "", "127.0.0.1"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tt.remote
+		if tt.xff != "" {
+			r.Header.Set("X-Forwarded-For", tt.xff)
+		}
+		if got := clientIP(r); !got.Equal(net.ParseIP(tt.want)) {
+			t.Errorf("clientIP(%s, XFF %q) = %v, want %s", tt.remote, tt.xff, got, tt.want)
+		}
+	}
+}
+
+func TestClientKey(t *testing.T) {
+	tests := []struct{ ip, want string }{
+		{"203.0.113.9", "203.0.113.9"},
+		{"::ffff:203.0.113.9", "203.0.113.9"},
+		{"2001:db8:1:2:aaaa::1", "2001:db8:1:2::/64"},
+		{"2001:db8:1:2:bbbb::9", "2001:db8:1:2::/64"},
+	}
+	for _, tt := range tests {
+		if got := clientKey(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("clientKey(%s) = %s, want %s", tt.ip, got, tt.want)
+		}
+	}
+	if got := clientKey(nil); got != "unknown" {
+		t.Errorf("clientKey(nil) = %s", got)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+
+	"siteconfig"
+)
+
+// pageColumns are the pages columns written by the crawler that the server
+// reads. They are added here too so the server works against a database the
+// current crawler has not touched yet.
+var pageColumns = []struct{ Name, Decl string }{
+	{"crawled_at", "TEXT"},
+	{"pagerank", "REAL DEFAULT 0"},
+	{"anchor_text", "TEXT DEFAULT ''"},
+	{"simhash", "INTEGER"},
+	{"dup_group", "INTEGER"},
+	{"schema_type", "TEXT DEFAULT ''"},
+	{"image", "TEXT DEFAULT ''"},
+	{"author", "TEXT DEFAULT ''"},
+	{"published_at", "TEXT"},
+	{"price", "TEXT DEFAULT ''"},
+	{"rating", "REAL"},
+	{"modified_at", "TEXT"},
+	{"content", "TEXT DEFAULT ''"},
+	{"content_html", "TEXT DEFAULT ''"},
+	{"domain", "TEXT DEFAULT ''"},
+	{"lang", "TEXT DEFAULT ''"},
+}
+
+// sectionsSchema mirrors the crawler's sections table (see crawler/sections.go)
+// so section lookups work before the crawler has created it
+const sectionsSchema = `
+	CREATE TABLE IF NOT EXISTS sections (
+		page_url TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		level INTEGER NOT NULL,
+		heading TEXT NOT NULL,
+		anchor TEXT NOT NULL DEFAULT '',
+		text TEXT NOT NULL DEFAULT '',
+		PRIMARY KEY (page_url, position)
+	) WITHOUT ROWID;`
+
+// aliasSchema and linksSchema mirror crawler/redirects.go and
+// crawler/linkgraph.go; /cache and the admin handlers read and prune them
+const aliasSchema = `
+	CREATE TABLE IF NOT EXISTS url_aliases (
+		alias TEXT PRIMARY KEY,
+		target TEXT NOT NULL,
+		recorded_at TEXT
+	);
+	CREATE INDEX IF NOT EXISTS idx_url_aliases_target ON url_aliases(target);`
+
+const linksSchema = `
+	CREATE TABLE IF NOT EXISTS links (
+		source TEXT NOT NULL,
+		target TEXT NOT NULL,
+		anchor TEXT NOT NULL DEFAULT '',
+		discovered_at TEXT,
+		PRIMARY KEY (source, target, anchor)
+	) WITHOUT ROWID;
+	CREATE INDEX IF NOT EXISTS idx_links_target ON links(target);`
+
+// --- Schema migrations ---
+func migrateDB() error {
+	if _, err := db.Exec(sectionsSchema); err != nil {
+		return fmt.Errorf("sections: %w", err)
+	}
+	if _, err := db.Exec(aliasSchema); err != nil {
+		return fmt.Errorf("url_aliases: %w", err)
+	}
+	if _, err := db.Exec(linksSchema); err != nil {
+		return fmt.Errorf("links: %w", err)
+	}
+	if _, err := db.Exec(apiKeysSchema); err != nil {
+		return fmt.Errorf("api_keys: %w", err)
+	}
+	if _, err := db.Exec(auditLogSchema); err != nil {
+		return fmt.Errorf("audit_log: %w", err)
+	}
+	if _, err := db.Exec(crawlQueueSchema); err != nil {
+		return fmt.Errorf("crawl_queue: %w", err)
+	}
+	for _, c := range pageColumns {
+		if err := ensureColumn("pages", c.Name, c.Decl); err != nil {
+			return fmt.Errorf("pages.%s: %w", c.Name, err)
+		}
+	}
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_pages_domain ON pages(domain)`); err != nil {
+		return fmt.Errorf("pages.domain index: %w", err)
+	}
+	if err := backfillDomains(); err != nil {
+		return fmt.Errorf("pages.domain: %w", err)
+	}
+	return nil
+}
+
+// backfillDomains fills pages.domain for rows stored before the column
+// existed, as the crawler does (crawler/facets.go). The per-site cap,
+// site: operators and domain facets all group by it.
+func backfillDomains() error {
+	rows, err := db.Query(`SELECT id, url FROM pages WHERE domain IS NULL OR domain = ''`)
+	if err != nil {
+		return err
+	}
+	type row struct {
+		id  int64
+		url string
+	}
+	var todo []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.url); err != nil {
+			rows.Close()
+			return err
+		}
+		todo = append(todo, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(todo) == 0 {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`UPDATE pages SET domain = ? WHERE id = ?`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, r := range todo {
+		if _, err := stmt.Exec(siteconfig.PageDomain(r.url), r.id); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	logEvent("Migration", fmt.Sprintf("filled pages.domain for %d pages", len(todo)))
+	return nil
+}
+
+// ensureColumn adds column to table unless it already exists
+func ensureColumn(table, column, decl string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, typ string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, decl))
+	return err
+}
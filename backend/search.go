@@ -0,0 +1,547 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"siteconfig"
+)
+
+// --- Ranking configuration ---
+var (
+	// tweak these as needed
+	SearchResultLimit    = 20  // results returned per query
+	SearchCandidateLimit = 200 // top-scored rows fetched before near-duplicates are collapsed
+	TitleWeight          = 3.0 // text relevance when the title matches
+	SnippetWeight        = 1.0 // text relevance when the snippet matches
+	AnchorWeight         = 1.5 // text relevance when inbound link text matches
+	ContentWeight        = 0.5 // text relevance when only the main content matches
+	PageRankWeight       = 2.0 // weight of the static link score (0..1, see `crawler pagerank`)
+	MaxResultsPerSite    = 3   // results shown per domain unless the search is limited to one site; 0 for no cap
+
+	// recency boost: a page dated today gets RecencyWeight, one RecencyHalfLife
+	// old gets half of it. On for RecencyCategories unless the request says recency=0.
+	RecencyWeight     = 1.5
+	RecencyHalfLife   = 14 * 24 * time.Hour
+	RecencyCategories = []string{"technology-news"}
+)
+
+// pageDateExpr is the date a page is filtered and boosted by
+const pageDateExpr = "COALESCE(published_at, modified_at)"
+
+// FacetLimit caps the values listed for the domain facet; selected values are always listed
+var FacetLimit = 20
+
+// searchParams is a parsed /search request
+type searchParams struct {
+	Query      string    // search text, without site: operators
+	Sites      []string  // site: operators; a site also matches its subdomains
+	Categories []string  // any of these; empty for all
+	Domains    []string  // any of these; empty for all
+	Langs      []string  // any of these, "und" for pages of unknown language; empty for all
+	Since      time.Time // zero: no lower bound
+	Until      time.Time // zero: no upper bound (exclusive)
+	Recency    bool      // apply the recency boost
+}
+
+// parseSearchParams reads the filters from the request query. category,
+// domain and lang may be repeated or comma-separated to select several
+// values. since/until take a date (2006-01-02), an RFC 3339 time or an age
+// such as "7d" or "12h"; a bare until date includes that whole day.
+// "site:example.com" words in the query restrict it to that site.
+func parseSearchParams(query string, q url.Values) (searchParams, error) {
+	text, sites := splitSiteOperators(query)
+	p := searchParams{
+		Query:      text,
+		Sites:      sites,
+		Categories: multiParam(q, "category"),
+		Domains:    multiParam(q, "domain"),
+		Langs:      multiParam(q, "lang"),
+	}
+	if slices.ContainsFunc(p.Categories, func(c string) bool { return strings.EqualFold(c, "all") }) {
+		p.Categories = nil // "All" is the unfiltered dropdown entry
+	}
+	for i := range p.Domains {
+		p.Domains[i] = strings.TrimPrefix(strings.ToLower(p.Domains[i]), "www.")
+	}
+	for i := range p.Langs {
+		p.Langs[i] = strings.ToLower(p.Langs[i])
+	}
+	var err error
+	if p.Since, err = parseDateParam(q.Get("since"), false); err != nil {
+		return p, fmt.Errorf("invalid since parameter: %v", err)
+	}
+	if p.Until, err = parseDateParam(q.Get("until"), true); err != nil {
+		return p, fmt.Errorf("invalid until parameter: %v", err)
+	}
+	if !p.Since.IsZero() && !p.Until.IsZero() && !p.Since.Before(p.Until) {
+		return p, errors.New("since must be before until")
+	}
+	switch recency := q.Get("recency"); strings.ToLower(recency) {
+	case "":
+		// on when every selected category asks for it
+		p.Recency = len(p.Categories) > 0
+		for _, c := range p.Categories {
+			p.Recency = p.Recency && slices.ContainsFunc(RecencyCategories, func(rc string) bool { return strings.EqualFold(rc, c) })
+		}
+	case "1", "true", "on":
+		p.Recency = true
+	case "0", "false", "off":
+	default:
+		return p, fmt.Errorf("invalid recency parameter %q", recency)
+	}
+	return p, nil
+}
+
+// splitSiteOperators takes the site: words out of query, returning the
+// remaining text and the sites in the form pages.domain stores them
+func splitSiteOperators(query string) (text string, sites []string) {
+	var words []string
+	for _, w := range strings.Fields(query) {
+		if len(w) > len("site:") && strings.EqualFold(w[:len("site:")], "site:") {
+			site := strings.TrimPrefix(strings.ToLower(strings.TrimSuffix(w[len("site:"):], "/")), "www.")
+			if site != "" && !slices.Contains(sites, site) {
+				sites = append(sites, site)
+			}
+			continue
+		}
+		words = append(words, w)
+	}
+	return strings.Join(words, " "), sites
+}
+
+// multiParam collects the distinct non-empty values of a repeatable,
+// comma-separated parameter
+func multiParam(q url.Values, key string) []string {
+	var out []string
+	for _, v := range q[key] {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part != "" && !slices.Contains(out, part) {
+				out = append(out, part)
+			}
+		}
+	}
+	return out
+}
+
+func parseDateParam(s string, endOfDay bool) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.UTC(), nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		if endOfDay {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	if strings.HasSuffix(s, "d") {
+		if n, err := strconv.Atoi(strings.TrimSuffix(s, "d")); err == nil && n >= 0 {
+			return time.Now().UTC().AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return time.Now().UTC().Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("%q is not a date, RFC 3339 time or age like 7d", s)
+}
+
+// langExpr maps pages without a detected language to "und" (undetermined)
+const langExpr = "COALESCE(NULLIF(lang, ''), 'und')"
+
+// matchConds are the conditions a page has to meet before facet filters:
+// the text match, site: operators and the date range
+func matchConds(p searchParams) ([]string, []interface{}) {
+	like := "%" + p.Query + "%"
+	conds := []string{"(title LIKE ? OR snippet LIKE ? OR anchor_text LIKE ? OR content LIKE ?)"}
+	args := []interface{}{like, like, like, like}
+	if len(p.Sites) > 0 {
+		var alts []string
+		for _, site := range p.Sites {
+			alts = append(alts, "domain = ? OR SUBSTR(domain, -LENGTH(?)) = ?")
+			args = append(args, site, "."+site, "."+site)
+		}
+		conds = append(conds, "("+strings.Join(alts, " OR ")+")")
+	}
+	if !p.Since.IsZero() {
+		conds = append(conds, pageDateExpr+" >= ?")
+		args = append(args, p.Since.Format(time.RFC3339))
+	}
+	if !p.Until.IsZero() {
+		conds = append(conds, pageDateExpr+" < ?")
+		args = append(args, p.Until.Format(time.RFC3339))
+	}
+	return conds, args
+}
+
+// inFilter restricts expr to values; "1" (no restriction) when values is empty
+func inFilter(expr string, values []string) (string, []interface{}) {
+	if len(values) == 0 {
+		return "1", nil
+	}
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return expr + " IN (" + strings.TrimSuffix(strings.Repeat("?, ", len(values)), ", ") + ")", args
+}
+
+// lowerAll returns values in lower case, for case-insensitive category filters
+func lowerAll(values []string) []string {
+	out := make([]string, len(values))
+	for i, v := range values {
+		out[i] = strings.ToLower(v)
+	}
+	return out
+}
+
+// rankPages returns the best matches for p.Query, restricted by the facet
+// filters and date range. A page matches on its title, snippet, inbound
+// anchor text or main content, and is ordered by weighted text relevance plus the blended
+// static score and, when enabled, the recency boost.
+// Near-duplicates (same dup_group, see `crawler dedup`) collapse into their
+// best-scored member, which reports how many it stands for. Results are then
+// capped per site and interleaved, see diversify.
+func rankPages(p searchParams) ([]Page, error) {
+	like := "%" + p.Query + "%"
+	conds, condArgs := matchConds(p)
+	for _, f := range []struct {
+		expr   string
+		values []string
+	}{{"LOWER(category)", lowerAll(p.Categories)}, {"domain", p.Domains}, {langExpr, p.Langs}} {
+		cond, args := inFilter(f.expr, f.values)
+		conds = append(conds, cond)
+		condArgs = append(condArgs, args...)
+	}
+
+	recencyWeight := 0.0
+	if p.Recency {
+		recencyWeight = RecencyWeight
+	}
+	halfLife := RecencyHalfLife.Hours() / 24
+
+	args := []interface{}{TitleWeight, like, SnippetWeight, like, AnchorWeight, like, ContentWeight, like, PageRankWeight,
+		recencyWeight, halfLife, halfLife}
+	args = append(args, condArgs...)
+	args = append(args, SearchCandidateLimit)
+
+	rows, err := db.Query(`
+		SELECT url, title, snippet, category, COALESCE(domain, ''), COALESCE(lang, ''), COALESCE(dup_group, id) AS grp,
+			COALESCE(schema_type, ''), COALESCE(image, ''), COALESCE(author, ''),
+			COALESCE(published_at, ''), COALESCE(modified_at, ''), COALESCE(price, ''), COALESCE(rating, 0),
+			? * COALESCE(title LIKE ?, 0) + ? * COALESCE(snippet LIKE ?, 0) + ? * COALESCE(anchor_text LIKE ?, 0) +
+			? * COALESCE(content LIKE ?, 0) + ? * COALESCE(pagerank, 0) +
+			? * COALESCE(? / (? + MAX(0, julianday('now') - julianday(`+pageDateExpr+`))), 0) AS score
+		FROM pages
+		WHERE `+strings.Join(conds, " AND ")+`
+		ORDER BY score DESC
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []Page{}
+	seen := map[int64]int{} // dup_group -> index in results
+	for rows.Next() {
+		var page Page
+		var group int64
+		if err := rows.Scan(&page.URL, &page.Title, &page.Snippet, &page.Category, &page.Domain, &page.Lang, &group,
+			&page.SchemaType, &page.Image, &page.Author, &page.PublishedAt, &page.ModifiedAt,
+			&page.Price, &page.Rating, &page.Score); err != nil {
+			continue
+		}
+		if page.Domain == "" { // written by an older crawler since the startup backfill
+			page.Domain = siteconfig.PageDomain(page.URL)
+		}
+		if i, ok := seen[group]; ok {
+			results[i].SimilarCount++
+			continue
+		}
+		seen[group] = len(results)
+		results = append(results, page)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	perSite := MaxResultsPerSite
+	if len(p.Sites) == 1 || len(p.Domains) == 1 {
+		perSite = 0 // the user asked for this site's results
+	}
+	results = diversify(results, perSite, SearchResultLimit)
+
+	if p.Query != "" { // a bare site: search has no text to find sections by
+		if err := attachSections(results, p.Query); err != nil {
+			logError("Section lookup failed", err)
+		}
+	}
+	return results, nil
+}
+
+// diversify picks up to limit results from pages (ordered by score),
+// skipping a domain's results beyond perSite (0: no cap), then reorders the
+// picks so that no two neighbours share a domain while another domain is
+// left. Otherwise score order holds. A domain's last shown result is marked
+// MoreFromSite when the cap left some of its matches out.
+func diversify(pages []Page, perSite, limit int) []Page {
+	picked := make([]Page, 0, min(limit, len(pages)))
+	shown := map[string]int{}
+	last := map[string]int{} // domain -> its last result in picked
+	for _, page := range pages {
+		capped := perSite > 0 && page.Domain != "" // only URLs without a host have none
+		if capped && shown[page.Domain] == perSite {
+			if i, ok := last[page.Domain]; ok {
+				picked[i].MoreFromSite = true
+			}
+			continue
+		}
+		if len(picked) == limit {
+			continue // still looking for capped domains' leftovers
+		}
+		shown[page.Domain]++
+		last[page.Domain] = len(picked)
+		picked = append(picked, page)
+	}
+
+	out := make([]Page, 0, len(picked))
+	for len(picked) > 0 {
+		next := 0
+		if n := len(out); n > 0 && out[n-1].Domain != "" {
+			for i := range picked {
+				if picked[i].Domain != out[n-1].Domain {
+					next = i
+					break
+				}
+			}
+		}
+		out = append(out, picked[next])
+		picked = slices.Delete(picked, next, next+1)
+	}
+	return out
+}
+
+// --- Facets ---
+
+// FacetValue is one value of a facet with the number of results it has
+type FacetValue struct {
+	Value    string `json:"value"`
+	Count    int    `json:"count"`
+	Selected bool   `json:"selected,omitempty"`
+}
+
+// Facets are the per-value result counts for category, domain and lang
+type Facets struct {
+	Category []FacetValue `json:"category"`
+	Domain   []FacetValue `json:"domain"`
+	Lang     []FacetValue `json:"lang"`
+}
+
+// facetCounts counts the full match set of p by category, domain and
+// language, plus the total. Each facet is counted with the other facets'
+// filters applied but not its own, so selecting one value still shows what
+// the alternatives would give. Matches are found once and materialised; the
+// four groupings then run over that. Near-duplicates count once, as in the
+// results.
+func facetCounts(p searchParams) (Facets, int, error) {
+	conds, args := matchConds(p)
+	catCond, catArgs := inFilter("LOWER(c)", lowerAll(p.Categories))
+	domCond, domArgs := inFilter("d", p.Domains)
+	langCond, langArgs := inFilter("l", p.Langs)
+
+	query := `
+		WITH m AS MATERIALIZED (
+			SELECT category AS c, COALESCE(domain, '') AS d, ` + langExpr + ` AS l, COALESCE(dup_group, id) AS g
+			FROM pages
+			WHERE ` + strings.Join(conds, " AND ") + `
+		)
+		SELECT 'category', MIN(c), COUNT(DISTINCT g) FROM m WHERE ` + domCond + ` AND ` + langCond + ` GROUP BY LOWER(c)
+		UNION ALL
+		SELECT 'domain', d, COUNT(DISTINCT g) FROM m WHERE ` + catCond + ` AND ` + langCond + ` AND d != '' GROUP BY d
+		UNION ALL
+		SELECT 'lang', l, COUNT(DISTINCT g) FROM m WHERE ` + catCond + ` AND ` + domCond + ` GROUP BY l
+		UNION ALL
+		SELECT 'total', '', COUNT(DISTINCT g) FROM m WHERE ` + catCond + ` AND ` + domCond + ` AND ` + langCond
+	args = append(args, domArgs...)
+	args = append(args, langArgs...)
+	args = append(args, catArgs...)
+	args = append(args, langArgs...)
+	args = append(args, catArgs...)
+	args = append(args, domArgs...)
+	args = append(args, catArgs...)
+	args = append(args, domArgs...)
+	args = append(args, langArgs...)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return Facets{}, 0, err
+	}
+	defer rows.Close()
+	var f Facets
+	total := 0
+	for rows.Next() {
+		var facet string
+		var value sql.NullString
+		var count int
+		if err := rows.Scan(&facet, &value, &count); err != nil {
+			return Facets{}, 0, err
+		}
+		v := FacetValue{Value: value.String, Count: count}
+		switch facet {
+		case "category":
+			v.Selected = slices.ContainsFunc(p.Categories, func(c string) bool { return strings.EqualFold(c, v.Value) })
+			f.Category = append(f.Category, v)
+		case "domain":
+			v.Selected = slices.Contains(p.Domains, v.Value)
+			f.Domain = append(f.Domain, v)
+		case "lang":
+			v.Selected = slices.Contains(p.Langs, v.Value)
+			f.Lang = append(f.Lang, v)
+		case "total":
+			total = count
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return Facets{}, 0, err
+	}
+	f.Category = finishFacet(f.Category, p.Categories, 0)
+	f.Domain = finishFacet(f.Domain, p.Domains, FacetLimit)
+	f.Lang = finishFacet(f.Lang, p.Langs, 0)
+	return f, total, nil
+}
+
+// finishFacet adds selected values that have no matches, orders values by
+// count then name, and keeps the top limit (0: all) plus any selected ones
+func finishFacet(values []FacetValue, selected []string, limit int) []FacetValue {
+	for _, s := range selected {
+		if !slices.ContainsFunc(values, func(v FacetValue) bool { return strings.EqualFold(v.Value, s) }) {
+			values = append(values, FacetValue{Value: s, Selected: true})
+		}
+	}
+	sort.SliceStable(values, func(i, j int) bool {
+		if values[i].Count != values[j].Count {
+			return values[i].Count > values[j].Count
+		}
+		return values[i].Value < values[j].Value
+	})
+	if limit > 0 && len(values) > limit {
+		kept := values[:limit:limit]
+		for _, v := range values[limit:] {
+			if v.Selected {
+				kept = append(kept, v)
+			}
+		}
+		values = kept
+	}
+	if values == nil {
+		values = []FacetValue{}
+	}
+	return values
+}
+
+// --- Section-level matches ---
+
+// SectionExcerptRadius is the number of characters shown either side of the match
+var SectionExcerptRadius = 100
+
+// SectionMatch is the part of a result page that matched the query
+type SectionMatch struct {
+	Heading string `json:"heading"`
+	Level   int    `json:"level"`
+	URL     string `json:"url"` // deep link: page URL with #anchor when the heading has an id
+	Excerpt string `json:"excerpt,omitempty"`
+}
+
+// attachSections points each result at the section that matches query,
+// preferring a match in the heading, then headings that can be linked to,
+// then the earliest section
+func attachSections(results []Page, query string) error {
+	if len(results) == 0 {
+		return nil
+	}
+	like := "%" + query + "%"
+	byURL := map[string]*Page{}
+	placeholders := make([]string, 0, len(results))
+	args := []interface{}{like}
+	for i := range results {
+		byURL[results[i].URL] = &results[i]
+		placeholders = append(placeholders, "?")
+		args = append(args, results[i].URL)
+	}
+	args = append(args, like, like)
+
+	rows, err := db.Query(`
+		SELECT page_url, level, heading, anchor, text, heading LIKE ? AS in_heading
+		FROM sections
+		WHERE page_url IN (`+strings.Join(placeholders, ", ")+`) AND (heading LIKE ? OR text LIKE ?)
+		ORDER BY page_url, in_heading DESC, anchor != '' DESC, position
+	`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var pageURL, heading, anchor, text string
+		var level int
+		var inHeading bool
+		if err := rows.Scan(&pageURL, &level, &heading, &anchor, &text, &inHeading); err != nil {
+			return err
+		}
+		page := byURL[pageURL]
+		if page == nil || page.Section != nil {
+			continue
+		}
+		match := &SectionMatch{Heading: heading, Level: level, URL: pageURL}
+		if anchor != "" {
+			if u, err := url.Parse(pageURL); err == nil {
+				u.Fragment = anchor
+				match.URL = u.String()
+			}
+		}
+		if !inHeading {
+			match.Excerpt = excerpt(text, query, SectionExcerptRadius)
+		}
+		page.Section = match
+	}
+	return rows.Err()
+}
+
+// excerpt cuts text to radius characters either side of the first
+// case-insensitive occurrence of query
+func excerpt(text, query string, radius int) string {
+	runes := []rune(text)
+	lower := []rune(strings.ToLower(text))
+	needle := []rune(strings.ToLower(query))
+	at := -1
+	if len(lower) == len(runes) {
+		for i := 0; i+len(needle) <= len(lower); i++ {
+			if string(lower[i:i+len(needle)]) == string(needle) {
+				at = i
+				break
+			}
+		}
+	}
+	if at < 0 {
+		at = 0
+	}
+	start, end := max(0, at-radius), min(len(runes), at+len(needle)+radius)
+	out := strings.TrimSpace(string(runes[start:end]))
+	if start > 0 {
+		out = "…" + out
+	}
+	if end < len(runes) {
+		out += "…"
+	}
+	return out
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestParseSearchParams(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		q     string // raw query string
+		want  searchParams
+	}{
+		{"no filters", "kali", "", searchParams{Query: "kali"}},
+		{
+			"repeated and comma-separated values",
+			"kali", "category=linux,security&category=security&domain=WWW.Kali.org, archlinux.org&lang=EN&lang=und",
+			searchParams{
+				Query:      "kali",
+				Categories: []string{"linux", "security"},
+				Domains:    []string{"kali.org", "archlinux.org"},
+				Langs:      []string{"en", "und"},
+			},
+		},
+		{"All is no category filter", "kali", "category=All", searchParams{Query: "kali"}},
+		{"empty values", "kali", "category=&domain=,,&lang= ", searchParams{Query: "kali"}},
+		{
+			"date range; a bare until date includes that day",
+			"kali", "since=2024-01-01&until=2024-01-31",
+			searchParams{Query: "kali", Since: date("2024-01-01"), Until: date("2024-02-01")},
+		},
+		{
+			"RFC 3339 times",
+			"kali", "since=2024-01-01T05:30:00%2B05:30",
+			searchParams{Query: "kali", Since: date("2024-01-01")},
+		},
+		{"recency on by category", "kali", "category=technology-news", searchParams{Query: "kali", Categories: []string{"technology-news"}, Recency: true}},
+		{"recency needs every category to ask", "kali", "category=technology-news,linux", searchParams{Query: "kali", Categories: []string{"technology-news", "linux"}}},
+		{"recency forced on", "kali", "recency=on", searchParams{Query: "kali", Recency: true}},
+		{"recency forced off", "kali", "category=technology-news&recency=0", searchParams{Query: "kali", Categories: []string{"technology-news"}}},
+		{
+			"site: operators leave the query",
+			"kali SITE:WWW.Kali.org/ install site:archlinux.org site:kali.org", "",
+			searchParams{Query: "kali install", Sites: []string{"kali.org", "archlinux.org"}},
+		},
+		{"site: alone", "site:kali.org", "", searchParams{Sites: []string{"kali.org"}}},
+		{"bare site: is a word", "site: kali", "", searchParams{Query: "site: kali"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := url.ParseQuery(tt.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := parseSearchParams(tt.query, q)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got  %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSearchParamsAges(t *testing.T) {
+	for _, tt := range []struct {
+		since string
+		age   time.Duration
+	}{
+		{"7d", 7 * 24 * time.Hour},
+		{"12h", 12 * time.Hour},
+		{"0d", 0},
+	} {
+		p, err := parseSearchParams("kali", url.Values{"since": {tt.since}})
+		if err != nil {
+			t.Fatalf("since=%s: %v", tt.since, err)
+		}
+		if d := time.Since(p.Since) - tt.age; d < 0 || d > time.Minute {
+			t.Errorf("since=%s: %v ago, want %v", tt.since, time.Since(p.Since), tt.age)
+		}
+	}
+}
+
+func TestParseSearchParamsErrors(t *testing.T) {
+	tests := []struct {
+		q, wantErr string
+	}{
+		{"since=yesterday", "invalid since"},
+		{"until=2024-13-01", "invalid until"},
+		{"since=-7d", "invalid since"},
+		{"since=2024-02-01&until=2024-01-01", "since must be before until"},
+		{"since=2024-01-01&until=2023-12-31", "since must be before until"},
+		{"recency=maybe", "invalid recency"},
+	}
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.q)
+		if _, err := parseSearchParams("kali", q); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: err = %v, want one containing %q", tt.q, err, tt.wantErr)
+		}
+	}
+}
+
+// pagesFrom builds results from names like "a1": the letter is the domain,
+// "_" for a page without one
+func pagesFrom(names ...string) []Page {
+	pages := make([]Page, len(names))
+	for i, n := range names {
+		pages[i] = Page{URL: n}
+		if n[0] != '_' {
+			pages[i].Domain = n[:1] + ".test"
+		}
+	}
+	return pages
+}
+
+// names lists results by name, marking those with MoreFromSite with "+"
+func names(pages []Page) []string {
+	out := make([]string, len(pages))
+	for i, p := range pages {
+		out[i] = p.URL
+		if p.MoreFromSite {
+			out[i] += "+"
+		}
+	}
+	return out
+}
+
+func TestDiversify(t *testing.T) {
+	tests := []struct {
+		name           string
+		in             []string
+		perSite, limit int
+		want           []string
+	}{
+		{"interleaved", []string{"a1", "a2", "b1", "b2"}, 0, 10, []string{"a1", "b1", "a2", "b2"}},
+		{"no other domain left", []string{"a1", "a2", "a3"}, 0, 10, []string{"a1", "a2", "a3"}},
+		{"capped per site", []string{"a1", "a2", "a3", "a4", "b1"}, 3, 10, []string{"a1", "b1", "a2", "a3+"}},
+		{"capped past the limit", []string{"a1", "a2", "b1", "a3", "b2"}, 2, 3, []string{"a1", "b1", "a2+"}},
+		{"limit alone is no reason for more", []string{"a1", "b1", "b2"}, 2, 2, []string{"a1", "b1"}},
+		{"pages without a domain are never capped", []string{"_1", "_2", "_3", "a1"}, 1, 10, []string{"_1", "_2", "_3", "a1"}},
+		{"empty", nil, 3, 10, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := names(diversify(pagesFrom(tt.in...), tt.perSite, tt.limit))
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("diversify(%q, %d, %d) = %q, want %q", tt.in, tt.perSite, tt.limit, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSiteOperatorMatches(t *testing.T) {
+	useTestDB(t)
+	for _, d := range []string{"kali.org", "docs.kali.org", "evilkali.org", "kali.org.evil.com", "archlinux.org"} {
+		if _, err := db.Exec(`INSERT INTO pages (url, title, snippet, category, domain) VALUES (?, 'kali', '', 'linux', ?)`,
+			"https://"+d+"/", d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"kali site:kali.org", []string{"docs.kali.org", "kali.org"}},
+		{"kali site:www.kali.org", []string{"docs.kali.org", "kali.org"}},
+		{"kali site:docs.kali.org", []string{"docs.kali.org"}},
+		{"kali site:kali.org site:archlinux.org", []string{"archlinux.org", "docs.kali.org", "kali.org"}},
+		{"kali site:org", []string{"archlinux.org", "docs.kali.org", "evilkali.org", "kali.org"}},
+	}
+	for _, tt := range tests {
+		p, err := parseSearchParams(tt.query, url.Values{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		conds, args := matchConds(p)
+		rows, err := db.Query(`SELECT domain FROM pages WHERE `+strings.Join(conds, " AND ")+` ORDER BY domain`, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for rows.Next() {
+			var d string
+			if err := rows.Scan(&d); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, d)
+		}
+		rows.Close()
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%q matched %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestLegacyPagesGetDomains(t *testing.T) {
+	useLegacyDB(t)
+	for _, u := range []string{
+		"https://kali.org/", "https://www.kali.org/docs/", "https://www.kali.org/blog/",
+		"https://kali.org/tools/", "https://kali.org/about/", "https://archlinux.org/", "https://wiki.archlinux.org/",
+	} {
+		if _, err := db.Exec(`INSERT INTO pages (url, title, snippet, category) VALUES (?, 'Linux', '', 'linux')`, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := migrateDB(); err != nil {
+		t.Fatal(err)
+	}
+	var missing int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM pages WHERE domain = ''`).Scan(&missing); err != nil || missing != 0 {
+		t.Fatalf("%d pages without a domain after migrating (%v)", missing, err)
+	}
+
+	search := func(query string) []Page {
+		t.Helper()
+		p, err := parseSearchParams(query, url.Values{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		results, err := rankPages(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return results
+	}
+	results := search("linux")
+	perDomain := map[string]int{}
+	for i, r := range results {
+		perDomain[r.Domain]++
+		if i > 0 && r.Domain == results[i-1].Domain {
+			t.Errorf("results %d and %d share %s", i-1, i, r.Domain)
+		}
+	}
+	if perDomain["kali.org"] != MaxResultsPerSite {
+		t.Errorf("kali.org and www.kali.org gave %d results, want the cap of %d", perDomain["kali.org"], MaxResultsPerSite)
+	}
+	if got := len(search("linux site:kali.org")); got != 5 {
+		t.Errorf("site:kali.org found %d pages, want 5", got)
+	}
+}
+
+func TestSearchResponseShapes(t *testing.T) {
+	useLegacyDB(t)
+	for _, u := range []string{"https://kali.org/", "https://www.kali.org/docs/", "https://archlinux.org/"} {
+		if _, err := db.Exec(`INSERT INTO pages (url, title, snippet, category) VALUES (?, 'Linux', '', 'linux')`, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := migrateDB(); err != nil {
+		t.Fatal(err)
+	}
+	get := func(h http.HandlerFunc, target string) []byte {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", target, w.Code, w.Body)
+		}
+		return w.Body.Bytes()
+	}
+
+	// /search keeps its original bare array
+	for target, want := range map[string]int{"/search?query=linux": 3, "/search?query=": 0} {
+		var results []Page
+		if err := json.Unmarshal(get(search, target), &results); err != nil {
+			t.Errorf("%s is not an array of results: %v", target, err)
+		} else if len(results) != want {
+			t.Errorf("%s found %d results, want %d", target, len(results), want)
+		}
+	}
+
+	var resp SearchResponse
+	if err := json.Unmarshal(get(searchV2, "/v2/search?query=linux"), &resp); err != nil {
+		t.Fatalf("/v2/search: %v", err)
+	}
+	if len(resp.Results) != 3 || resp.Total != 3 {
+		t.Errorf("/v2/search: %d results, total %d; want 3 and 3", len(resp.Results), resp.Total)
+	}
+	want := []FacetValue{{Value: "kali.org", Count: 2}, {Value: "archlinux.org", Count: 1}}
+	if !reflect.DeepEqual(resp.Facets.Domain, want) {
+		t.Errorf("domain facet = %+v, want %+v", resp.Facets.Domain, want)
+	}
+	if err := json.Unmarshal(get(searchV2, "/v2/search?query="), &resp); err != nil || resp.Results == nil {
+		t.Errorf("/v2/search without a query: %+v, %v", resp, err)
+	}
+}
+
+func TestAttachSections(t *testing.T) {
+	useTestDB(t)
+	for _, s := range []struct {
+		page                  string
+		pos, level            int
+		heading, anchor, text string
+	}{
+		{"https://a.test/guide", 0, 1, "Guide", "", "Install Kali from a USB stick."},
+		{"https://a.test/guide", 1, 2, "Other", "other", "Kali again, but this section has an anchor."},
+		{"https://a.test/guide", 2, 2, "Kali on ARM", "arm", "Boards."},
+		{"https://b.test/faq", 0, 2, "Setup", "setup", "Nothing to see."},
+		{"https://b.test/faq", 1, 3, "Drivers", "", "Wi-Fi drivers for KALI laptops."},
+		{"https://c.test/", 0, 1, "Elsewhere", "kali", "Not a result."},
+	} {
+		if _, err := db.Exec(`INSERT INTO sections (page_url, position, level, heading, anchor, text) VALUES (?, ?, ?, ?, ?, ?)`,
+			s.page, s.pos, s.level, s.heading, s.anchor, s.text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	results := []Page{{URL: "https://a.test/guide"}, {URL: "https://b.test/faq"}, {URL: "https://d.test/"}}
+	if err := attachSections(results, "kali"); err != nil {
+		t.Fatal(err)
+	}
+	want := []*SectionMatch{
+		// a match in a heading beats linkable and earlier sections
+		{Heading: "Kali on ARM", Level: 2, URL: "https://a.test/guide#arm"},
+		{Heading: "Drivers", Level: 3, URL: "https://b.test/faq", Excerpt: "Wi-Fi drivers for KALI laptops."},
+		nil,
+	}
+	for i, r := range results {
+		if !reflect.DeepEqual(r.Section, want[i]) {
+			t.Errorf("%s: section %+v, want %+v", r.URL, r.Section, want[i])
+		}
+	}
+	if err := attachSections(nil, "kali"); err != nil {
+		t.Errorf("no results: %v", err)
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	tests := []struct {
+		text, query string
+		radius      int
+		want        string
+	}{
+		{"short text with kali inside", "kali", 100, "short text with kali inside"},
+		{"0123456789 Kali 0123456789", "kali", 5, "…6789 Kali 0123…"},
+		{"कालीनक्स पर kali चलाएँ", "KALI", 4, "…पर kali चला…"},
+		{"no match here at all", "kali", 5, "no match…"},
+	}
+	for _, tt := range tests {
+		if got := excerpt(tt.text, tt.query, tt.radius); got != tt.want {
+			t.Errorf("excerpt(%q, %q, %d) = %q, want %q", tt.text, tt.query, tt.radius, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"frontend"
+)
+
+// staticFile is one embedded frontend file, ready to serve
+type staticFile struct {
+	name        string
+	body        []byte
+	hash        string // first 12 hex digits of the SHA-256 of body
+	contentType string
+}
+
+// assetRef matches local href="…", src="…" and url(…) references that
+// prepareStatic versions with ?v=<hash>
+var assetRef = regexp.MustCompile(`((?:href|src)=["']|url\(["']?)([^"')?#:]+)((?:\?[^"')#]*)?)(["')])`)
+
+var (
+	staticFS    fs.FS = frontend.Files // the web UI, embedded at build time
+	staticFiles       = map[string]*staticFile{}
+	startedAt         = time.Now()
+)
+
+// --- Static file preparation ---
+
+// prepareStatic loads the embedded frontend and rewrites references between
+// its files to carry content hashes, so a changed asset gets a new URL and
+// unchanged ones can be cached forever. Files are hashed after their own
+// references are rewritten: assets first, then CSS and JS, then HTML.
+func prepareStatic(files fs.FS) error {
+	var names []string
+	err := fs.WalkDir(files, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && !strings.HasSuffix(p, ".go") {
+			names = append(names, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	stage := func(name string) int {
+		switch path.Ext(name) {
+		case ".html":
+			return 2
+		case ".css", ".js":
+			return 1
+		}
+		return 0
+	}
+	sort.SliceStable(names, func(i, j int) bool { return stage(names[i]) < stage(names[j]) })
+
+	for _, name := range names {
+		body, err := fs.ReadFile(files, name)
+		if err != nil {
+			return err
+		}
+		if stage(name) > 0 {
+			body = versionRefs(name, body)
+		}
+		sum := sha256.Sum256(body)
+		ctype := mime.TypeByExtension(path.Ext(name))
+		if ctype == "" {
+			ctype = http.DetectContentType(body)
+		}
+		staticFiles[name] = &staticFile{name: name, body: body, hash: hex.EncodeToString(sum[:6]), contentType: ctype}
+	}
+	if staticFiles["index.html"] == nil {
+		return fmt.Errorf("frontend has no index.html")
+	}
+	return nil
+}
+
+// versionRefs appends ?v=<hash> to references in body that name an already
+// prepared file, resolved relative to the referring file
+func versionRefs(name string, body []byte) []byte {
+	dir := path.Dir(name)
+	return assetRef.ReplaceAllFunc(body, func(m []byte) []byte {
+		parts := assetRef.FindSubmatch(m)
+		ref := string(parts[2])
+		target := path.Clean(path.Join(dir, ref))
+		if strings.HasPrefix(ref, "/") {
+			target = strings.TrimPrefix(path.Clean(ref), "/")
+		}
+		f := staticFiles[target]
+		if f == nil {
+			return m
+		}
+		return bytes.Join([][]byte{parts[1], parts[2], []byte("?v=" + f.hash), parts[4]}, nil)
+	})
+}
+
+// --- Static file server ---
+
+// serveStatic serves the embedded frontend. Paths are cleaned and looked up
+// in the prepared set, so nothing outside it can be reached. Requests for a
+// file's current hash are cached as immutable; HTML is always revalidated.
+// Unknown paths without an extension get index.html for client-side routes.
+func serveStatic(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" {
+		name = "index.html"
+	}
+	f := staticFiles[name]
+	if f == nil {
+		if path.Ext(name) != "" {
+			http.NotFound(w, r)
+			return
+		}
+		f = staticFiles["index.html"]
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", f.contentType)
+	h.Set("ETag", `"`+f.hash+`"`)
+	switch {
+	case strings.HasSuffix(f.name, ".html"):
+		h.Set("Cache-Control", "no-cache")
+	case r.URL.Query().Get("v") == f.hash:
+		h.Set("Cache-Control", "public, max-age=31536000, immutable")
+	default:
+		h.Set("Cache-Control", "public, max-age=300")
+	}
+	http.ServeContent(w, r, f.name, startedAt, bytes.NewReader(f.body))
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	CertCheckInterval = 10 * time.Second     // how often cert/key files are checked for changes
+	SelfSignedValid   = 365 * 24 * time.Hour // lifetime of a generated development cert
+)
+
+// --- TLS configuration ---
+
+// newTLSConfig allows TLS 1.2 and 1.3 only. The 1.2 suites are limited to
+// ECDHE with AEAD ciphers; 1.3 suites are not configurable and already are.
+func newTLSConfig(getCert func(*tls.ClientHelloInfo) (*tls.Certificate, error)) *tls.Config {
+	return &tls.Config{
+		MinVersion:       tls.VersionTLS12,
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384},
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+		GetCertificate: getCert,
+	}
+}
+
+// --- Certificate hot reload ---
+
+// certReloader serves a cert/key pair from disk and picks up replacements
+// (e.g. a renewal) without a restart. A pair that fails to load is logged
+// and the previous one stays in use.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	cr := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := cr.load(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// load reads the pair and remembers the newest of the two mtimes
+func (cr *certReloader) load() error {
+	mod, err := cr.latestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+	cr.cert, cr.modTime, cr.checked = &cert, mod, time.Now()
+	return nil
+}
+
+func (cr *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{cr.certFile, cr.keyFile} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// GetCertificate is used as tls.Config.GetCertificate. The files are
+// stat'ed at most once per CertCheckInterval.
+func (cr *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	if time.Since(cr.checked) < CertCheckInterval {
+		return cr.cert, nil
+	}
+	cr.checked = time.Now()
+	mod, err := cr.latestModTime()
+	if err != nil {
+		logWarn(fmt.Sprintf("Cannot check TLS certificate, keeping the current one: %v", err))
+		return cr.cert, nil
+	}
+	if mod.Equal(cr.modTime) {
+		return cr.cert, nil
+	}
+	// a renewal writes two files; a half-written pair fails here and is
+	// retried on the next check
+	if err := cr.load(); err != nil {
+		logWarn(fmt.Sprintf("Cannot reload TLS certificate, keeping the current one: %v", err))
+		return cr.cert, nil
+	}
+	logEvent("TLS", "Reloaded certificate from "+cr.certFile)
+	return cr.cert, nil
+}
+
+// --- Self-signed development certificate ---
+
+// ensureSelfSignedCert returns the paths of a self-signed cert/key pair in
+// dir, generating one when it is missing or about to expire. The pair is
+// kept on disk so a browser exception survives restarts.
+func ensureSelfSignedCert(dir string) (certFile, keyFile string, err error) {
+	certFile = filepath.Join(dir, "dev-cert.pem")
+	keyFile = filepath.Join(dir, "dev-key.pem")
+	if cert, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil {
+		if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err == nil && time.Until(leaf.NotAfter) > 7*24*time.Hour {
+			return certFile, keyFile, nil
+		}
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", "", err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return "", "", err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"Veydhara development"}, CommonName: "localhost"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(SelfSignedValid),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if host, err := os.Hostname(); err == nil && host != "" && host != "localhost" {
+		tmpl.DNSNames = append(tmpl.DNSNames, host)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return "", "", err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := writePEM(keyFile, "EC PRIVATE KEY", keyDER, 0600); err != nil {
+		return "", "", err
+	}
+	if err := writePEM(certFile, "CERTIFICATE", der, 0644); err != nil {
+		return "", "", err
+	}
+	logEvent("TLS", "Generated self-signed certificate "+certFile)
+	return certFile, keyFile, nil
+}
+
+func writePEM(name, blockType string, der []byte, perm os.FileMode) error {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+// --- HTTP → HTTPS redirect ---
+
+// redirectToHTTPS sends every plain HTTP request to the same host and path
+// on the HTTPS listener at httpsAddr
+func redirectToHTTPS(httpsAddr string) (http.Handler, error) {
+	_, port, err := net.SplitHostPort(httpsAddr)
+	if err != nil {
+		return nil, err
+	}
+	if port == "" {
+		return nil, errors.New("HTTPS address has no port")
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]")
+		if host == "" {
+			http.Error(w, "Host header is required", http.StatusBadRequest)
+			return
+		}
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]" // IPv6 literal
+		}
+		target := "https://" + host + r.URL.RequestURI()
+		status := http.StatusMovedPermanently
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			status = http.StatusPermanentRedirect // keep the method and body
+		}
+		http.Redirect(w, r, target, status)
+	}), nil
+}
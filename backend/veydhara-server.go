@@ -3,12 +3,14 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/fatih/color"
 	_ "github.com/mattn/go-sqlite3"
@@ -16,10 +18,33 @@ import (
 
 // Page represents a single search result
 type Page struct {
-	URL      string `json:"url"`
-	Title    string `json:"title"`
-	Snippet  string `json:"snippet"`
-	Category string `json:"category"`
+	URL          string  `json:"url"`
+	Title        string  `json:"title"`
+	Snippet      string  `json:"snippet"`
+	Category     string  `json:"category"`
+	Domain       string  `json:"domain,omitempty"`
+	Lang         string  `json:"lang,omitempty"`
+	SimilarCount int     `json:"similar_count,omitempty"`  // near-duplicates collapsed into this result
+	MoreFromSite bool    `json:"more_from_site,omitempty"` // the per-site cap left out further matches from Domain
+	Score        float64 `json:"-"`
+
+	// structured data for rich results; empty when the page declares none
+	SchemaType  string  `json:"schema_type,omitempty"`
+	Image       string  `json:"image,omitempty"`
+	Author      string  `json:"author,omitempty"`
+	PublishedAt string  `json:"published_at,omitempty"`
+	ModifiedAt  string  `json:"modified_at,omitempty"`
+	Price       string  `json:"price,omitempty"`
+	Rating      float64 `json:"rating,omitempty"`
+
+	Section *SectionMatch `json:"section,omitempty"` // best matching h1–h3 section
+}
+
+// SearchResponse is the /v2/search result page with facet counts over all matches
+type SearchResponse struct {
+	Results []Page `json:"results"`
+	Facets  Facets `json:"facets"`
+	Total   int    `json:"total"` // matches after filters, near-duplicates counted once
 }
 
 // ErrorResponse represents a JSON error message
@@ -37,7 +62,10 @@ var (
 	logger    *log.Logger
 )
 
-func init() {
+// setupPathsAndDB resolves paths against the working directory, opens the
+// log file and the database and migrates it. It runs from main rather than
+// init so test binaries never touch the real database.
+func setupPathsAndDB() {
 	var err error
 	baseDir, err = os.Getwd()
 	if err != nil {
@@ -58,100 +86,96 @@ func init() {
 	logger = log.New(logFile, "", log.Ldate|log.Ltime|log.Lshortfile)
 	debugMode = os.Getenv("DEBUG") == "true"
 
-	db, err = sql.Open("sqlite3", dbPath)
+	db, err = sql.Open("sqlite3", dbPath+"?_busy_timeout=5000")
 	if err != nil {
 		logger.Fatalf(" FAILED TO OPEN DATABASE :> %v", err)
 	}
 	db.SetMaxOpenConns(10)
 	db.SetMaxIdleConns(5)
+	if err := migrateDB(); err != nil {
+		logger.Fatalf(" FAILED TO MIGRATE DATABASE :> %v", err)
+	}
 
-	printBanner()
-	showAvailableCategories()
-	color.New(color.FgHiGreen).Println(" [^_^]> INITALIZATION SUCCESSFUL BOSS")
 }
 
 // --- /categories endpoint ---
 func getCategories(w http.ResponseWriter, r *http.Request) {
 	logEvent("Request", "/categories")
 
-	data, err := os.ReadFile(catPath)
+	set, err := categories.get()
 	if err != nil {
-		logError("Failed to read categories.json", err)
+		logError("Categories unavailable", err)
 		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
 		return
 	}
 
-	var categories map[string]interface{}
-	if err := json.Unmarshal(data, &categories); err != nil {
-		logError("Failed to parse categories.json", err)
+	list, err := describeCategories(set)
+	if err != nil {
+		logError("Failed to count category pages", err)
 		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
 		return
 	}
 
-	keys := make([]string, 0, len(categories))
-	for k := range categories {
-		keys = append(keys, k)
-	}
-
-	respondJSON(w, http.StatusOK, keys)
+	respondJSON(w, http.StatusOK, list)
 }
 
-// --- /search endpoint ---
+// --- /search and /v2/search endpoints ---
+
+// search answers with a bare array of results, the shape /search has always
+// had; facet counts and the total are only in /v2/search
 func search(w http.ResponseWriter, r *http.Request) {
-	query := strings.TrimSpace(r.URL.Query().Get("query"))
-	category := strings.TrimSpace(r.URL.Query().Get("category"))
+	serveSearch(w, r, false)
+}
+
+// searchV2 answers with a SearchResponse
+func searchV2(w http.ResponseWriter, r *http.Request) {
+	serveSearch(w, r, true)
+}
+
+func serveSearch(w http.ResponseWriter, r *http.Request, v2 bool) {
+	q := r.URL.Query()
+	query := strings.TrimSpace(q.Get("query"))
 
 	if query == "" {
-		respondJSON(w, http.StatusOK, []Page{})
+		if !v2 {
+			respondJSON(w, http.StatusOK, []Page{})
+			return
+		}
+		empty := []FacetValue{}
+		respondJSON(w, http.StatusOK, SearchResponse{Results: []Page{}, Facets: Facets{empty, empty, empty}})
 		return
 	}
 
-	if category == "" {
-		category = "All"
+	params, err := parseSearchParams(query, q)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
 	}
 
-	logEvent("Search", fmt.Sprintf("query='%s' category='%s'", query, category))
-
-	var rows *sql.Rows
-	var err error
-
-	if strings.ToLower(category) != "all" {
-		rows, err = db.Query(`
-			SELECT url, title, snippet, category 
-			FROM pages 
-			WHERE LOWER(category) = LOWER(?) AND (title LIKE ? OR snippet LIKE ?) 
-			LIMIT 20
-		`, category, "%"+query+"%", "%"+query+"%")
-	} else {
-		rows, err = db.Query(`
-			SELECT url, title, snippet, category 
-			FROM pages 
-			WHERE title LIKE ? OR snippet LIKE ? 
-			LIMIT 20
-		`, "%"+query+"%", "%"+query+"%")
-	}
+	filters := fmt.Sprintf("site=%v category=%v domain=%v lang=%v", params.Sites, params.Categories, params.Domains, params.Langs)
+	logEvent("Search", fmt.Sprintf("query='%s' %s", query, filters))
 
+	results, err := rankPages(params)
 	if err != nil {
 		logError("Search query failed", err)
 		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
 		return
 	}
-	defer rows.Close()
-
-	var results []Page
-	for rows.Next() {
-		var p Page
-		if err := rows.Scan(&p.URL, &p.Title, &p.Snippet, &p.Category); err != nil {
-			continue
-		}
-		results = append(results, p)
-	}
-
 	if len(results) == 0 {
-		logWarn(fmt.Sprintf("No results for query='%s' category='%s'", query, category))
+		logWarn(fmt.Sprintf("No results for query='%s' %s", query, filters))
+	}
+	if !v2 {
+		respondJSON(w, http.StatusOK, results)
+		return
 	}
 
-	respondJSON(w, http.StatusOK, results)
+	facets, total, err := facetCounts(params)
+	if err != nil {
+		logError("Facet query failed", err)
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	respondJSON(w, http.StatusOK, SearchResponse{Results: results, Facets: facets, Total: total})
 }
 
 // --- /page endpoint ---
@@ -164,6 +188,12 @@ func getPageContent(w http.ResponseWriter, r *http.Request) {
 
 	logEvent("PageView", url)
 
+	// URLs that redirected during the crawl are stored as aliases of their target
+	var target string
+	if err := db.QueryRow("SELECT target FROM url_aliases WHERE alias = ?", url).Scan(&target); err == nil {
+		url = target
+	}
+
 	row := db.QueryRow("SELECT content FROM pages WHERE url = ?", url)
 	var content string
 
@@ -181,25 +211,6 @@ func getPageContent(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, map[string]string{"content": content})
 }
 
-// --- Serve frontend ---
-func serveFrontend(w http.ResponseWriter, r *http.Request) {
-	path := r.URL.Path
-	rootDir := filepath.Join(baseDir, "../frontend")
-
-	if path == "/" || path == "" {
-		http.ServeFile(w, r, filepath.Join(rootDir, "index.html"))
-		return
-	}
-
-	filePath := filepath.Join(rootDir, path)
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		http.ServeFile(w, r, filepath.Join(rootDir, "index.html"))
-		return
-	}
-
-	http.ServeFile(w, r, filePath)
-}
-
 // --- Utility: Write JSON ---
 func respondJSON(w http.ResponseWriter, status int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
@@ -232,41 +243,148 @@ func printBanner() {
 }
 
 func showAvailableCategories() {
-	data, err := os.ReadFile(catPath)
+	set, err := categories.get()
 	if err != nil {
-		logWarn(" FAILED TO READ CATAGORIES :-( ")
-		return
-	}
-
-	var categories map[string]interface{}
-	if err := json.Unmarshal(data, &categories); err != nil {
-		logWarn(" FAILED TO PHASE CATAGORIES :-( ")
+		logWarn(fmt.Sprintf(" FAILED TO LOAD CATAGORIES :-( %v", err))
 		return
 	}
 
 	color.New(color.FgHiGreen).Println(" 📂 AVALIABLE CATAGORIES :> ")
-	for k := range categories {
+	for _, k := range set.Names {
 		color.New(color.FgWhite).Printf("   - %s\n", k)
 	}
 }
 
 // --- Main ---
 func main() {
+	setupPathsAndDB()
 	defer db.Close()
 
-	http.HandleFunc("/categories", getCategories)
-	http.HandleFunc("/search", search)
-	http.HandleFunc("/page", getPageContent)
-	http.HandleFunc("/", serveFrontend)
+	// sub-commands; no argument runs the server
+	if len(os.Args) > 1 && os.Args[1] == "keys" {
+		if err := runKeys(os.Args[2:]); err != nil {
+			logError("keys", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	categories = newCategoryStore(catPath)
+	categories.reload(true) // a failure is reported just below and retried by the watcher
+	go categories.watch(CategoriesPollInterval)
+	limits = newLimiter()
+	go limits.sweep()
+
+	printBanner()
+	showAvailableCategories()
+	color.New(color.FgHiGreen).Println(" [^_^]> INITALIZATION SUCCESSFUL BOSS")
+
+	addr := flag.String("addr", "0.0.0.0:5000", "address to listen on")
+	certFile := flag.String("tls-cert", "", "TLS certificate file (PEM); enables HTTPS together with -tls-key")
+	keyFile := flag.String("tls-key", "", "TLS private key file (PEM)")
+	selfSigned := flag.Bool("tls-self-signed", false, "serve HTTPS with a generated self-signed certificate (development only)")
+	redirectAddr := flag.String("http-redirect-addr", "", "also listen here on plain HTTP and redirect to HTTPS, e.g. 0.0.0.0:80")
+	proxies := flag.String("trusted-proxies", "", "comma-separated IPs/CIDRs of reverse proxies whose X-Forwarded-For is trusted")
+	flag.Parse()
+
+	if err := setTrustedProxies(*proxies); err != nil {
+		logger.Fatalf(" INVALID -trusted-proxies :> %v", err)
+	}
+
+	if err := prepareStatic(staticFS); err != nil {
+		logger.Fatalf(" FAILED TO LOAD FRONTEND :> %v", err)
+	}
+
+	mux := http.NewServeMux()
+	api := func(path string, h http.HandlerFunc) {
+		mux.Handle(path, chain(h, noStore, notBanned, authenticated(scopeSearch), rateLimited(path), metered))
+	}
+	api("/categories", getCategories)
+	api("/search", search)
+	// the versioned search shares the rate limits of /search
+	mux.Handle("/v2/search", chain(http.HandlerFunc(searchV2), noStore, notBanned, authenticated(scopeSearch), rateLimited("/search"), metered))
+	api("/page", getPageContent)
+	api("/cache", getCachedPage)
+	// checking usage does not count against the quota it reports
+	mux.Handle("/usage", chain(http.HandlerFunc(getUsage), noStore, notBanned, authenticated(scopeSearch), rateLimited("/usage")))
+	admin := func(path string, h methods) {
+		mux.Handle(path, chain(h, noStore, notBanned, authenticated(scopeAdmin), rateLimited("/admin"), metered))
+	}
+	admin("/admin/stats", methods{http.MethodGet: adminAction("stats", adminStats)})
+	admin("/admin/audit", methods{http.MethodGet: adminAction("audit", adminAudit)})
+	admin("/admin/delete", methods{http.MethodPost: adminAction("delete", adminDelete)})
+	admin("/admin/requeue", methods{http.MethodPost: adminAction("requeue", adminRequeue)})
+	admin("/admin/categories", methods{
+		http.MethodGet: adminAction("categories.get", adminGetCategories),
+		http.MethodPut: adminAction("categories.put", adminPutCategories),
+	})
+	admin("/admin/reindex", methods{http.MethodPost: adminAction("reindex", adminReindex)})
+	admin("/admin/vacuum", methods{http.MethodPost: adminAction("vacuum", adminVacuum)})
+	mux.HandleFunc("/", serveStatic)
+	handler := chain(mux, securityHeaders)
+
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
 
-	addr := "0.0.0.0:5000"
-	color.New(color.FgHiBlue, color.Bold).Printf("\n 🌐 SERVER IS ONLINE AT :> http://%s\n", addr)
+	useTLS := *selfSigned || *certFile != "" || *keyFile != ""
+	if useTLS {
+		if *selfSigned {
+			if *certFile != "" || *keyFile != "" {
+				logger.Fatalf(" -tls-self-signed CANNOT BE COMBINED WITH -tls-cert/-tls-key")
+			}
+			var err error
+			*certFile, *keyFile, err = ensureSelfSignedCert(filepath.Join(baseDir, "../certs"))
+			if err != nil {
+				logger.Fatalf(" FAILED TO CREATE SELF-SIGNED CERTIFICATE :> %v", err)
+			}
+		} else if *certFile == "" || *keyFile == "" {
+			logger.Fatalf(" -tls-cert AND -tls-key MUST BE GIVEN TOGETHER")
+		}
+		certs, err := newCertReloader(*certFile, *keyFile)
+		if err != nil {
+			logger.Fatalf(" FAILED TO LOAD TLS CERTIFICATE :> %v", err)
+		}
+		srv.TLSConfig = newTLSConfig(certs.GetCertificate)
+	} else if *redirectAddr != "" {
+		logger.Fatalf(" -http-redirect-addr NEEDS HTTPS (-tls-cert/-tls-key or -tls-self-signed)")
+	}
+
+	if *redirectAddr != "" {
+		redirect, err := redirectToHTTPS(*addr)
+		if err != nil {
+			logger.Fatalf(" INVALID -addr FOR REDIRECT :> %v", err)
+		}
+		go func() {
+			rs := &http.Server{Addr: *redirectAddr, Handler: redirect, ReadHeaderTimeout: 10 * time.Second}
+			if err := rs.ListenAndServe(); err != nil {
+				logError(">> Redirect listener failed B-( ", err)
+			}
+		}()
+	}
+
+	scheme := "http"
+	if useTLS {
+		scheme = "https"
+	}
+	color.New(color.FgHiBlue, color.Bold).Printf("\n 🌐 SERVER IS ONLINE AT :> %s://%s\n", scheme, *addr)
+	if *redirectAddr != "" {
+		color.New(color.FgHiBlue).Printf(" ↪️  REDIRECTING HTTP FROM :> http://%s\n", *redirectAddr)
+	}
 	color.New(color.FgHiCyan).Printf(" 🧠 DEBUG-MODE :> %v\n", debugMode)
 	color.New(color.FgHiWhite).Println(">> LOGS ARE STORED HERE :> ", logPath)
 	color.New(color.FgHiMagenta).Println("───────────────────────────────────────────────")
 
-	if err := http.ListenAndServe(addr, nil); err != nil {
+	var err error
+	if useTLS {
+		err = srv.ListenAndServeTLS("", "") // certificates come from TLSConfig.GetCertificate
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if err != nil {
 		logError(">> Server failed B-( ", err)
 	}
 }
-
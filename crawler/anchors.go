@@ -0,0 +1,106 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// maxAnchorsPerPage caps how many distinct anchors are indexed for one target
+const maxAnchorsPerPage = 20
+
+// genericAnchors say nothing about the page they point to
+var genericAnchors = map[string]bool{
+	"click here": true, "here": true, "read more": true, "more": true, "link": true,
+	"learn more": true, "continue reading": true, "see more": true, "view more": true,
+	"details": true, "this": true, "go": true, "next": true, "previous": true, "prev": true,
+	"»": true, "«": true, "→": true, "←": true,
+}
+
+// ----------------------
+// Anchor text
+// ----------------------
+
+// anchorText describes a link: its visible text, or for image links the alt
+// text, falling back to the title / aria-label attributes. Generic phrases
+// such as "click here" yield "".
+func anchorText(s *goquery.Selection) string {
+	text := cleanAnchor(s.Text())
+	if text == "" {
+		text = cleanAnchor(s.Find("img[alt]").First().AttrOr("alt", ""))
+	}
+	for _, attr := range []string{"title", "aria-label"} {
+		if text != "" {
+			break
+		}
+		text = cleanAnchor(s.AttrOr(attr, ""))
+	}
+	if genericAnchors[strings.ToLower(text)] {
+		return ""
+	}
+	return text
+}
+
+// ----------------------
+// `anchors` command
+// ----------------------
+
+// runAnchorIndex rebuilds pages.anchor_text from the link graph: for every
+// stored page, the distinct anchors other pages use to link to it (redirect
+// aliases included), most common first. It also runs after every crawl.
+func runAnchorIndex(args []string) error {
+	rows, err := db.Query(`
+		WITH resolved AS (
+			SELECT COALESCE(a.target, l.target) AS url, l.source, l.anchor
+			FROM links l LEFT JOIN url_aliases a ON a.alias = l.target
+			WHERE l.anchor != ''
+		)
+		SELECT url, MIN(anchor), COUNT(DISTINCT source) AS n
+		FROM resolved
+		WHERE source != url AND url IN (SELECT url FROM pages)
+		GROUP BY url, LOWER(anchor)
+		ORDER BY url, n DESC`)
+	if err != nil {
+		return err
+	}
+	anchors := map[string][]string{}
+	for rows.Next() {
+		var u, anchor string
+		var n int
+		if err := rows.Scan(&u, &anchor, &n); err != nil {
+			rows.Close()
+			return err
+		}
+		if len(anchors[u]) < maxAnchorsPerPage {
+			anchors[u] = append(anchors[u], anchor)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`UPDATE pages SET anchor_text = ''`); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(`UPDATE pages SET anchor_text = ? WHERE url = ?`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for u, list := range anchors {
+		if _, err := stmt.Exec(strings.Join(list, " | "), u); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	info("Anchor index: %d pages have inbound anchor text", len(anchors))
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestAnchorText(t *testing.T) {
+	tests := []struct{ html, want string }{
+		{`<a href="/x">  Kali   Linux
+			docs </a>`, "Kali Linux docs"},
+		{`<a href="/x"><img src="i.png" alt="Arch logo"></a>`, "Arch logo"},
+		{`<a href="/x" title="Release notes"></a>`, "Release notes"},
+		{`<a href="/x" aria-label="Search"></a>`, "Search"},
+		{`<a href="/x">Click here</a>`, ""},
+		{`<a href="/x">»</a>`, ""},
+		{`<a href="/x"></a>`, ""},
+	}
+	for _, tt := range tests {
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(tt.html))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := anchorText(doc.Find("a")); got != tt.want {
+			t.Errorf("anchorText(%s) = %q, want %q", tt.html, got, tt.want)
+		}
+	}
+	long := strings.Repeat("word ", 100)
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<a href="/x">` + long + `</a>`))
+	if got := anchorText(doc.Find("a")); len([]rune(got)) != maxAnchorLen {
+		t.Errorf("long anchor kept %d runes, want %d", len([]rune(got)), maxAnchorLen)
+	}
+}
+
+func TestAnchorIndex(t *testing.T) {
+	useTestDB(t)
+	for _, u := range []string{"http://example.com/", "https://a.test/", "https://b.test/", "https://example.com/docs"} {
+		if _, err := db.Exec(`INSERT INTO pages (url, title) VALUES (?, 'p')`, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recordAliases([]redirectHop{{URL: "https://example.com/old-docs", Status: 301}}, "https://example.com/docs")
+	for source, links := range map[string][]link{
+		"https://a.test/": {
+			{Target: "HTTP://Example.com?utm_source=x", Anchor: "Example home"},
+			{Target: "https://example.com/old-docs#intro", Anchor: "Example docs"},
+			{Target: "https://a.test/", Anchor: "self link"},
+			{Target: "/relative", Anchor: "unresolved"},
+		},
+		"https://b.test/": {
+			{Target: "http://EXAMPLE.com/", Anchor: "example HOME"},
+			{Target: "http://example.com/", Anchor: "Example site"},
+		},
+	} {
+		if err := saveLinks(source, links); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := runAnchorIndex(nil); err != nil {
+		t.Fatal(err)
+	}
+	for u, want := range map[string]string{
+		"http://example.com/":      "Example home | Example site", // two sources for one anchor come first
+		"https://example.com/docs": "Example docs",
+		"https://a.test/":          "",
+	} {
+		var got string
+		if err := db.QueryRow(`SELECT anchor_text FROM pages WHERE url = ?`, u).Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%s: anchor_text = %q, want %q", u, got, want)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"siteconfig"
+)
+
+// DomainConfig is a categories.json entry (see siteconfig.Site) with the
+// crawler's defaults filled in
+type DomainConfig struct {
+	siteconfig.Site
+
+	delay    time.Duration // CrawlDelay, or PolitenessDelay when unset
+	requeued []string      // URLs re-queued through the admin API (see crawlqueue.go)
+}
+
+func newDomainConfig(s siteconfig.Site) DomainConfig {
+	d := DomainConfig{Site: s, delay: s.CrawlDelay()}
+	if d.MaxPages == 0 {
+		d.MaxPages = MaxPagesPerDomain
+	}
+	if d.delay == 0 {
+		d.delay = PolitenessDelay
+	}
+	return d
+}
+
+// ----------------------
+// Categories loader
+// ----------------------
+
+// loadCategories reads categories.json and validates every entry (the same
+// checks the server's admin API applies before writing it); any invalid entry
+// fails the whole load with its category and position in the message. A
+// domain listed twice in one category keeps its first entry.
+func loadCategories(path string) (map[string][]DomainConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := siteconfig.Parse(b)
+	if problems, ok := err.(siteconfig.Problems); ok {
+		return nil, fmt.Errorf("invalid categories.json:\n  %s", strings.Join(problems, "\n  "))
+	} else if err != nil {
+		return nil, err
+	}
+	for _, r := range parsed.Repeats {
+		warn("categories.json: %s — keeping the first entry", r)
+	}
+	categories := make(map[string][]DomainConfig, len(parsed.Sites))
+	for cat, sites := range parsed.Sites {
+		entries := make([]DomainConfig, 0, len(sites))
+		for _, s := range sites {
+			entries = append(entries, newDomainConfig(s))
+		}
+		categories[cat] = entries
+	}
+	return categories, nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bufio"
+	"io"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// charsetSniffLen is how many leading bytes are inspected for a BOM or a
+// <meta charset> declaration (the HTML spec prescan limit).
+const charsetSniffLen = 1024
+
+// ----------------------
+// Charset detection & transcoding
+// ----------------------
+
+// decodeHTMLBody wraps body so that it yields UTF-8 regardless of how the
+// page was served. The encoding is picked the way browsers do it: a byte
+// order mark wins, then the charset parameter of the Content-Type header,
+// then a <meta charset> / http-equiv declaration in the first 1KB, and
+// finally a UTF-8 validity sniff falling back to windows-1252.
+// It returns the reader and the canonical name of the detected encoding.
+func decodeHTMLBody(body io.Reader, contentType string) (io.Reader, string) {
+	br := bufio.NewReaderSize(body, charsetSniffLen)
+	head, _ := br.Peek(charsetSniffLen) // short pages return fewer bytes, that's fine
+
+	enc, name, _ := charset.DetermineEncoding(head, contentType)
+	if name == "utf-8" {
+		// charset hands out a no-op decoder for UTF-8; validate instead, so
+		// invalid bytes and a rune cut off by truncation become U+FFFD
+		enc = unicode.UTF8
+	}
+	// BOMOverride strips a leading BOM (and switches decoder if the BOM
+	// disagrees with the declared charset), otherwise it defers to enc.
+	return transform.NewReader(br, unicode.BOMOverride(enc.NewDecoder())), name
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+func encodeText(t *testing.T, enc encoding.Encoding, s string) []byte {
+	t.Helper()
+	b, err := enc.NewEncoder().Bytes([]byte(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func decodeAll(t *testing.T, body []byte, contentType string) (string, string) {
+	t.Helper()
+	r, name := decodeHTMLBody(strings.NewReader(string(body)), contentType)
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out), name
+}
+
+func TestDecodeHTMLBody(t *testing.T) {
+	const latin = "<p>Café crème, naïve façade</p>"
+	const japaneseText = "<p>日本語のページ</p>"
+	metaLatin := `<html><head><meta charset="iso-8859-1"></head><body>` + latin + `</body></html>`
+	metaEquivSJIS := `<html><head><meta http-equiv="Content-Type" content="text/html; charset=Shift_JIS"></head><body>` + japaneseText + `</body></html>`
+	utf16BOM := append([]byte{0xff, 0xfe}, encodeText(t, unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM), latin)...)
+
+	tests := []struct {
+		name, contentType string
+		body              []byte
+		want, wantEnc     string
+	}{
+		{"utf-8 header", "text/html; charset=utf-8", []byte(latin), latin, "utf-8"},
+		{"windows-1252 header", "text/html; charset=windows-1252", encodeText(t, charmap.Windows1252, latin), latin, "windows-1252"},
+		{"latin1 is windows-1252", "text/html; charset=ISO-8859-1", encodeText(t, charmap.Windows1252, latin), latin, "windows-1252"},
+		{"shift_jis header", "text/html; charset=shift_jis", encodeText(t, japanese.ShiftJIS, japaneseText), japaneseText, "shift_jis"},
+		{"meta charset", "text/html", encodeText(t, charmap.Windows1252, metaLatin), metaLatin, "windows-1252"},
+		{"meta http-equiv", "", encodeText(t, japanese.ShiftJIS, metaEquivSJIS), metaEquivSJIS, "shift_jis"},
+		{"invalid utf-8 is replaced", "text/html; charset=utf-8", []byte("<p>a\xffb</p>"), "<p>a\ufffdb</p>", "utf-8"},
+		{"header beats meta", "text/html; charset=utf-8", []byte(metaLatin), metaLatin, "utf-8"},
+		{"utf-8 BOM beats header", "text/html; charset=windows-1252", append([]byte("\xef\xbb\xbf"), latin...), latin, "utf-8"},
+		{"utf-16 BOM", "", utf16BOM, latin, "utf-16le"},
+		{"undeclared utf-8", "", []byte(latin), latin, "utf-8"},
+		{"undeclared legacy bytes", "", encodeText(t, charmap.Windows1252, latin), latin, "windows-1252"},
+		{"unknown charset is sniffed", "text/html; charset=x-bogus", []byte(latin), latin, "utf-8"},
+		{"empty", "text/html", nil, "", "windows-1252"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, enc := decodeAll(t, tt.body, tt.contentType)
+			if got != tt.want || enc != tt.wantEnc {
+				t.Errorf("decoded %q as %s, want %q as %s", got, enc, tt.want, tt.wantEnc)
+			}
+		})
+	}
+}
+
+// TestFetchedBodyTranscodes runs compressed legacy-charset pages through
+// readBody and decodeHTMLBody the way the crawler does
+func TestFetchedBodyTranscodes(t *testing.T) {
+	setBodyLimits(t, 1<<20, true)
+	page := strings.Repeat("<p>日本語のページ, naïve café</p>\n", 100)
+	for _, tt := range []struct {
+		header, writer string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"deflate", "zlib"},
+		{"deflate", "flate"},
+		{"br", "br"},
+	} {
+		t.Run(tt.header+" "+tt.writer, func(t *testing.T) {
+			body := encodeText(t, japanese.EUCJP, page)
+			if tt.writer != "" {
+				body = encode(t, tt.writer, body)
+			}
+			raw, truncated, err := readBody(response(tt.header, body))
+			if err != nil || truncated {
+				t.Fatalf("readBody: truncated=%v, %v", truncated, err)
+			}
+			got, enc := decodeAll(t, raw, "text/html; charset=EUC-JP")
+			if got != page || enc != "euc-jp" {
+				t.Errorf("decoded %d bytes as %s, want the %d byte page as euc-jp", len(got), enc, len(page))
+			}
+		})
+	}
+}
+
+// TestTruncatedMidRune cuts a page inside a multibyte character: it must
+// still be read as UTF-8, losing only the cut character
+func TestTruncatedMidRune(t *testing.T) {
+	page := strings.Repeat("नमस्ते ", 100) // 3-byte runes and spaces
+	for cut := 1; cut <= 2; cut++ {
+		limit := int64(len("नमस्ते ")*40 + cut) // cut bytes into the next "न"
+		setBodyLimits(t, limit, true)
+		raw, truncated, err := readBody(response("gzip", encode(t, "gzip", []byte(page))))
+		if err != nil || !truncated || int64(len(raw)) != limit {
+			t.Fatalf("cut %d: readBody = %d bytes, truncated=%v, %v", cut, len(raw), truncated, err)
+		}
+		got, enc := decodeAll(t, raw, "")
+		want := strings.Repeat("नमस्ते ", 40)
+		if enc != "utf-8" || !utf8.ValidString(got) || strings.TrimSuffix(got, "\ufffd") != want {
+			t.Errorf("cut %d: decoded %q as %s, want %q and at most one U+FFFD", cut, got[len(got)-20:], enc, want[len(want)-20:])
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"database/sql"
+	"net/url"
+	"time"
+)
+
+// crawlQueueSchema holds URLs re-queued through the server's admin API. The
+// next crawl fetches them first, whether or not their domain is due.
+const crawlQueueSchema = `
+	CREATE TABLE IF NOT EXISTS crawl_queue (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		url TEXT NOT NULL,
+		requested_at TEXT NOT NULL,
+		requested_by TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL DEFAULT 'pending',
+		error TEXT NOT NULL DEFAULT '',
+		finished_at TEXT
+	);
+	CREATE INDEX IF NOT EXISTS idx_crawl_queue_status ON crawl_queue(status);`
+
+// crawl_queue.status values
+const (
+	queuePending  = "pending"
+	queueDone     = "done"
+	queueFailed   = "failed"
+	queueRejected = "rejected"
+)
+
+// queuedURL is one pending crawl_queue row
+type queuedURL struct {
+	ID  int64
+	URL string
+}
+
+// ----------------------
+// Queue consumption
+// ----------------------
+
+// pendingRequeues returns the pending queue rows keyed by category and
+// domain entry. URLs that no category covers are marked rejected.
+func pendingRequeues(categories map[string][]DomainConfig) (map[string]map[string][]queuedURL, error) {
+	rows, err := db.Query(`SELECT id, url FROM crawl_queue WHERE status = ? ORDER BY id`, queuePending)
+	if err != nil {
+		return nil, err
+	}
+	var pending []queuedURL
+	for rows.Next() {
+		var q queuedURL
+		if err := rows.Scan(&q.ID, &q.URL); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		pending = append(pending, q)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	byDomain := map[string]map[string][]queuedURL{}
+	for _, q := range pending {
+		if u, err := url.Parse(q.URL); err == nil {
+			q.URL = normalizeURL(u) // the form pages.url is stored in
+		}
+		cat, domain := requeueTarget(categories, q.URL)
+		if domain == "" {
+			finishRequeue(q.ID, queueRejected, "not in scope of any category")
+			continue
+		}
+		if byDomain[cat] == nil {
+			byDomain[cat] = map[string][]queuedURL{}
+		}
+		byDomain[cat][domain] = append(byDomain[cat][domain], q)
+	}
+	return byDomain, nil
+}
+
+// requeueTarget finds the category and domain entry whose scope covers raw
+func requeueTarget(categories map[string][]DomainConfig, raw string) (category, domain string) {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", ""
+	}
+	for cat, sites := range categories {
+		for _, site := range sites {
+			if site.AllowsHost(u.Hostname()) && site.AllowsPath(u) {
+				return cat, site.Domain
+			}
+		}
+	}
+	return "", ""
+}
+
+// finishRequeues marks the rows of a job that started at since from this
+// run's results: done when the page was stored at or after since, directly
+// or through a redirect recorded in this run, failed otherwise with the
+// URL's last fetch_log outcome as the reason. A copy stored by an earlier
+// crawl does not count.
+func finishRequeues(queued []queuedURL, since time.Time) {
+	after := since.UTC().Format(time.RFC3339)
+	for _, q := range queued {
+		var found int
+		err := db.QueryRow(`
+			SELECT 1 FROM pages WHERE url = ?1 AND crawled_at >= ?2
+			UNION ALL
+			SELECT 1 FROM url_aliases a JOIN pages p ON p.url = a.target
+			WHERE a.alias = ?1 AND a.recorded_at >= ?2 AND p.crawled_at >= ?2
+			LIMIT 1`, q.URL, after).Scan(&found)
+		switch {
+		case err == nil:
+			finishRequeue(q.ID, queueDone, "")
+		case err == sql.ErrNoRows:
+			finishRequeue(q.ID, queueFailed, requeueFailure(q.URL, after))
+		default:
+			errLog("crawl_queue lookup failed for %s: %v", q.URL, err)
+		}
+	}
+}
+
+// requeueFailure explains why u was not stored by the fetches logged at or
+// after since
+func requeueFailure(u, since string) string {
+	var class, msg string
+	err := db.QueryRow(`SELECT COALESCE(error_class, ''), COALESCE(error, '') FROM fetch_log
+		WHERE url = ? AND fetched_at >= ? ORDER BY id DESC LIMIT 1`, u, since).Scan(&class, &msg)
+	switch {
+	case err != nil:
+		return "not fetched in this run"
+	case class == classOK || class == classTruncated:
+		return "fetched but not stored; see the crawler log"
+	case msg != "":
+		return class + ": " + msg
+	}
+	return class
+}
+
+func finishRequeue(id int64, status, reason string) {
+	if _, err := db.Exec(`UPDATE crawl_queue SET status = ?, error = ?, finished_at = ? WHERE id = ?`,
+		status, reason, time.Now().UTC().Format(time.RFC3339), id); err != nil {
+		errLog("Failed to update crawl_queue row %d: %v", id, err)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFinishRequeues(t *testing.T) {
+	useTestDB(t)
+	since := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	before := since.Add(-24 * time.Hour).Format(time.RFC3339)
+	during := since.Add(time.Minute).Format(time.RFC3339)
+
+	for _, q := range []struct {
+		sql  string
+		args []any
+	}{
+		{`INSERT INTO pages (url, title, crawled_at) VALUES (?, 'p', ?), (?, 'p', ?), (?, 'p', ?), (?, 'p', ?)`,
+			[]any{"https://a.test/fresh", during, "https://a.test/stale-404", before, "https://a.test/stale-robots", before, "https://a.test/target", during}},
+		{`INSERT INTO url_aliases (alias, target, recorded_at) VALUES (?, ?, ?), (?, ?, ?)`,
+			[]any{"https://a.test/moved", "https://a.test/target", during, "https://a.test/moved-long-ago", "https://a.test/stale-404", before}},
+		{`INSERT INTO fetch_log (url, error_class, error, status_code, fetched_at) VALUES (?, ?, ?, ?, ?), (?, ?, ?, ?, ?), (?, ?, ?, ?, ?), (?, ?, ?, ?, ?)`,
+			[]any{
+				"https://a.test/stale-404", classHTTP4xx, "status 404", 404, during,
+				"https://a.test/stale-robots", classRobots, "", 0, during,
+				"https://a.test/unsaved", classOK, "", 200, during,
+				"https://a.test/untouched", classHTTP5xx, "status 503", 503, before, // an earlier run
+			}},
+	} {
+		if _, err := db.Exec(q.sql, q.args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		url, status, reason string
+	}{
+		{"https://a.test/fresh", queueDone, ""},
+		{"https://a.test/moved", queueDone, ""},
+		{"https://a.test/stale-404", queueFailed, "http_4xx: status 404"},
+		{"https://a.test/stale-robots", queueFailed, "robots"},
+		{"https://a.test/moved-long-ago", queueFailed, "not fetched in this run"},
+		{"https://a.test/unsaved", queueFailed, "fetched but not stored; see the crawler log"},
+		{"https://a.test/untouched", queueFailed, "not fetched in this run"},
+	}
+	var queued []queuedURL
+	for _, tt := range tests {
+		res, err := db.Exec(`INSERT INTO crawl_queue (url, requested_at) VALUES (?, ?)`, tt.url, before)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := res.LastInsertId()
+		queued = append(queued, queuedURL{ID: id, URL: tt.url})
+	}
+	finishRequeues(queued, since)
+	for i, tt := range tests {
+		var status, reason string
+		if err := db.QueryRow(`SELECT status, error FROM crawl_queue WHERE id = ?`, queued[i].ID).Scan(&status, &reason); err != nil {
+			t.Fatal(err)
+		}
+		if status != tt.status || reason != tt.reason {
+			t.Errorf("%s: %s (%q), want %s (%q)", tt.url, status, reason, tt.status, tt.reason)
+		}
+	}
+}
+
+func TestPendingRequeues(t *testing.T) {
+	useTestDB(t)
+	path := filepath.Join(t.TempDir(), "categories.json")
+	if err := os.WriteFile(path, []byte(`{"linux": ["kali.org", {"domain": "archlinux.org", "exclude": ["^/private/"]}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	categories, err := loadCategories(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	urls := []string{
+		"https://www.kali.org/docs/?utm_source=admin#x",
+		"https://archlinux.org/news/",
+		"https://archlinux.org/private/x",
+		"https://evilkali.org/",
+		"ftp://kali.org/",
+	}
+	for _, u := range urls {
+		if _, err := db.Exec(`INSERT INTO crawl_queue (url, requested_at) VALUES (?, '2026-03-01T00:00:00Z')`, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	byDomain, err := pendingRequeues(categories)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := byDomain["linux"]["kali.org"]; len(q) != 1 || q[0].URL != "https://www.kali.org/docs/" {
+		t.Errorf("kali.org queue = %+v", q)
+	}
+	if q := byDomain["linux"]["archlinux.org"]; len(q) != 1 || q[0].URL != "https://archlinux.org/news/" {
+		t.Errorf("archlinux.org queue = %+v", q)
+	}
+	var rejected int
+	db.QueryRow(`SELECT COUNT(*) FROM crawl_queue WHERE status = ?`, queueRejected).Scan(&rejected)
+	if rejected != 3 {
+		t.Errorf("%d rows rejected, want 3", rejected)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// publishedMeta and modifiedMeta are <meta> names / properties that carry a
+// page date, in order of trust
+var (
+	publishedMeta = []string{
+		"article:published_time", "og:published_time", "datePublished", "pubdate", "publishdate",
+		"publish-date", "publish_date", "date", "dc.date.issued", "dc.date", "dcterms.created",
+		"dcterms.date", "citation_publication_date", "parsely-pub-date", "sailthru.date",
+	}
+	modifiedMeta = []string{
+		"article:modified_time", "og:updated_time", "dateModified", "last-modified",
+		"dcterms.modified", "dc.date.modified", "revised",
+	}
+)
+
+// ----------------------
+// Page dates
+// ----------------------
+
+// pageDates settles a page's published and modified dates (RFC 3339, UTC, ""
+// when unknown). Structured data is trusted first, then <meta> tags, then
+// <time> elements; the Last-Modified header is only used for the modified
+// date. A modified date before the published one is dropped.
+func pageDates(doc *goquery.Document, sd structuredData, lastModified string) (published, modified string) {
+	published, modified = sd.PublishedAt, sd.ModifiedAt
+	if published == "" {
+		published = metaDate(doc, publishedMeta)
+	}
+	if published == "" {
+		published = timeElementDate(doc)
+	}
+	if modified == "" {
+		modified = metaDate(doc, modifiedMeta)
+	}
+	if modified == "" {
+		modified = normalizeDate(lastModified)
+	}
+	if published != "" && modified != "" && modified < published {
+		modified = ""
+	}
+	return published, modified
+}
+
+// metaDate returns the first parseable date among the named meta tags
+func metaDate(doc *goquery.Document, names []string) string {
+	values := map[string]string{}
+	doc.Find("meta[content]").Each(func(i int, s *goquery.Selection) {
+		for _, attr := range []string{"property", "name", "itemprop", "http-equiv"} {
+			if key, ok := s.Attr(attr); ok {
+				key = strings.ToLower(strings.TrimSpace(key))
+				if _, dup := values[key]; !dup {
+					values[key] = s.AttrOr("content", "")
+				}
+			}
+		}
+	})
+	for _, name := range names {
+		if d := normalizeDate(values[strings.ToLower(name)]); d != "" {
+			return d
+		}
+	}
+	return ""
+}
+
+// timeElementDate looks for the <time> that dates the article: one marked
+// pubdate or datePublished, then the first inside <article> or <header>,
+// then the first on the page
+func timeElementDate(doc *goquery.Document) string {
+	for _, sel := range []string{
+		"time[pubdate][datetime]",
+		`time[itemprop~="datePublished"][datetime]`,
+		"article time[datetime]",
+		"header time[datetime]",
+		"time[datetime]",
+	} {
+		if d := normalizeDate(doc.Find(sel).First().AttrOr("datetime", "")); d != "" {
+			return d
+		}
+	}
+	return ""
+}
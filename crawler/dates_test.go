@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNormalizeDate(t *testing.T) {
+	tomorrow := time.Now().UTC().Add(24 * time.Hour).Format("2006-01-02")
+	nextWeek := time.Now().UTC().Add(7 * 24 * time.Hour).Format("2006-01-02")
+	tests := []struct{ in, want string }{
+		{"2024-03-01T09:30:00+05:30", "2024-03-01T04:00:00Z"},
+		{"2024-03-01T09:30:00.123Z", "2024-03-01T09:30:00Z"},
+		{"2024-03-01T09:30:00+0530", "2024-03-01T04:00:00Z"},
+		{"2024-03-01T09:30:00", "2024-03-01T09:30:00Z"},
+		{"2024-03-01T09:30", "2024-03-01T09:30:00Z"},
+		{"2024-03-01 09:30:00 -0700", "2024-03-01T16:30:00Z"},
+		{"  2024-03-01 ", "2024-03-01T00:00:00Z"},
+		{"2024/03/01", "2024-03-01T00:00:00Z"},
+		{"20240301", "2024-03-01T00:00:00Z"},
+		{"Fri, 01 Mar 2024 09:30:00 GMT", "2024-03-01T09:30:00Z"},
+		{"Fri, 01 Mar 2024 09:30:00 +0100", "2024-03-01T08:30:00Z"},
+		{"March 1, 2024", "2024-03-01T00:00:00Z"},
+		{"Mar 1, 2024", "2024-03-01T00:00:00Z"},
+		{"1 March 2024", "2024-03-01T00:00:00Z"},
+		{tomorrow, tomorrow + "T00:00:00Z"}, // time zones put "today" up to a day ahead
+		{nextWeek, ""},
+		{"0001-01-01T00:00:00Z", ""},
+		{"1970-01-01", ""},
+		{"yesterday", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeDate(tt.in); got != tt.want {
+			t.Errorf("normalizeDate(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPageDates(t *testing.T) {
+	tests := []struct {
+		name          string
+		html          string
+		sd            structuredData
+		lastModified  string
+		pub, modified string
+	}{
+		{
+			"structured data first",
+			`<meta property="article:published_time" content="2020-01-01">`,
+			structuredData{PublishedAt: "2024-03-01T00:00:00Z", ModifiedAt: "2024-03-05T00:00:00Z"},
+			"Sat, 01 Jun 2024 00:00:00 GMT",
+			"2024-03-01T00:00:00Z", "2024-03-05T00:00:00Z",
+		},
+		{
+			"meta tags in order of trust, names matched case-insensitively",
+			`<meta name="date" content="2022-02-02"><meta name="DC.Date" content="2021-01-01">
+			<meta name="article:published_time" content="not a date"><meta property="og:published_time" content="2023-03-03">
+			<meta http-equiv="last-modified" content="2023-04-04">`,
+			structuredData{}, "",
+			"2023-03-03T00:00:00Z", "2023-04-04T00:00:00Z",
+		},
+		{
+			"a pubdate time beats the first one on the page",
+			`<nav><time datetime="2019-09-09">nav</time></nav>
+			<article><time datetime="2022-08-01">body</time><time pubdate datetime="2022-07-31">posted</time></article>`,
+			structuredData{}, "",
+			"2022-07-31T00:00:00Z", "",
+		},
+		{
+			"article time before header time before any time",
+			`<header><time datetime="2019-09-09">header</time></header><article><p><time datetime="2022-08-01">x</time></p></article>`,
+			structuredData{}, "",
+			"2022-08-01T00:00:00Z", "",
+		},
+		{
+			"Last-Modified only dates the modification",
+			`<p>undated</p>`,
+			structuredData{}, "Fri, 01 Mar 2024 09:30:00 GMT",
+			"", "2024-03-01T09:30:00Z",
+		},
+		{
+			"a modified date before the published one is dropped",
+			`<time datetime="2024-05-01">`,
+			structuredData{}, "Fri, 01 Mar 2024 09:30:00 GMT",
+			"2024-05-01T00:00:00Z", "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pub, mod := pageDates(mustDoc(t, tt.html), tt.sd, tt.lastModified)
+			if pub != tt.pub || mod != tt.modified {
+				t.Errorf("pageDates = %q, %q; want %q, %q", pub, mod, tt.pub, tt.modified)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"flag"
+	"hash/fnv"
+	"math/bits"
+	"strings"
+	"unicode"
+)
+
+// shingleSize is the number of consecutive words hashed together
+const shingleSize = 3
+
+// ----------------------
+// SimHash fingerprints
+// ----------------------
+
+// simHash is a 64-bit SimHash over word shingles of text. Pages built from
+// the same template with small differences end up a few bits apart.
+func simHash(text string) uint64 {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	if len(words) == 0 {
+		return 0
+	}
+	size := shingleSize
+	if len(words) < size {
+		size = len(words)
+	}
+
+	var weights [64]int
+	h := fnv.New64a()
+	for i := 0; i+size <= len(words); i++ {
+		h.Reset()
+		h.Write([]byte(strings.Join(words[i:i+size], " ")))
+		sum := h.Sum64()
+		for b := 0; b < 64; b++ {
+			if sum&(1<<b) != 0 {
+				weights[b]++
+			} else {
+				weights[b]--
+			}
+		}
+	}
+	var fp uint64
+	for b, w := range weights {
+		if w > 0 {
+			fp |= 1 << b
+		}
+	}
+	return fp
+}
+
+// ----------------------
+// `dedup` command
+// ----------------------
+
+// runDedup clusters pages whose fingerprints differ in at most -distance bits
+// and writes the cluster id to pages.dup_group. The id is that of the cluster's
+// representative: highest pagerank, then oldest row. It also runs after every crawl.
+//
+// Candidate pairs come from splitting each fingerprint into four 16-bit bands:
+// two fingerprints within 3 bits of each other must agree on at least one band.
+func runDedup(args []string) error {
+	fs := flag.NewFlagSet("dedup", flag.ExitOnError)
+	distance := fs.Int("distance", SimHashDistance, "maximum differing bits for two pages to count as near-duplicates")
+	fs.Parse(args)
+	if *distance > 3 {
+		warn("distance %d exceeds what 4-band lookup guarantees to find; some pairs will be missed", *distance)
+	}
+
+	type fpPage struct {
+		id   int64
+		fp   uint64
+		rank float64
+	}
+	rows, err := db.Query(`SELECT id, simhash, COALESCE(pagerank, 0) FROM pages WHERE simhash IS NOT NULL AND simhash != 0`)
+	if err != nil {
+		return err
+	}
+	var pages []fpPage
+	for rows.Next() {
+		var p fpPage
+		var fp int64
+		if err := rows.Scan(&p.id, &fp, &p.rank); err != nil {
+			rows.Close()
+			return err
+		}
+		p.fp = uint64(fp)
+		pages = append(pages, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// union-find over page indexes
+	parent := make([]int, len(pages))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for band := 0; band < 4; band++ {
+		buckets := map[uint64][]int{}
+		for i, p := range pages {
+			key := (p.fp >> (16 * band)) & 0xffff
+			buckets[key] = append(buckets[key], i)
+		}
+		for _, idx := range buckets {
+			for a := 0; a < len(idx); a++ {
+				for b := a + 1; b < len(idx); b++ {
+					i, j := idx[a], idx[b]
+					if bits.OnesCount64(pages[i].fp^pages[j].fp) <= *distance {
+						parent[find(i)] = find(j)
+					}
+				}
+			}
+		}
+	}
+
+	// pick a representative per cluster
+	best := map[int]int{}
+	size := map[int]int{}
+	for i, p := range pages {
+		root := find(i)
+		size[root]++
+		cur, ok := best[root]
+		if !ok || p.rank > pages[cur].rank || (p.rank == pages[cur].rank && p.id < pages[cur].id) {
+			best[root] = i
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`UPDATE pages SET dup_group = id`); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(`UPDATE pages SET dup_group = ? WHERE id = ?`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	clusters, duplicates := 0, 0
+	for i, p := range pages {
+		root := find(i)
+		if size[root] < 2 {
+			continue
+		}
+		if best[root] == i {
+			clusters++
+		} else {
+			duplicates++
+		}
+		if _, err := stmt.Exec(pages[best[root]].id, p.id); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	info("Dedup: %d pages fingerprinted, %d near-duplicate clusters, %d pages collapsed", len(pages), clusters, duplicates)
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"io"
+	"log"
+	"math/bits"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useTestDB points the package's db at a fresh database in a temp dir and
+// silences the logger
+func useTestDB(t *testing.T) {
+	t.Helper()
+	oldDB, oldLogger := db, logger
+	db = initDB(filepath.Join(t.TempDir(), "search.db"))
+	logger = log.New(io.Discard, "", 0)
+	t.Cleanup(func() {
+		db.Close()
+		db, logger = oldDB, oldLogger
+	})
+}
+
+func TestSimHash(t *testing.T) {
+	article := strings.Repeat("The quick brown fox jumps over the lazy dog near the river bank. ", 20) +
+		"Published by the village news desk on a sunny Tuesday morning."
+	edited := strings.Replace(article, "sunny Tuesday", "rainy Wednesday", 1)
+	other := strings.Repeat("Kernel modules load at boot and register device drivers with the system. ", 20)
+
+	if simHash("") != 0 {
+		t.Errorf("simHash of empty text = %x, want 0", simHash(""))
+	}
+	if simHash(article) != simHash(strings.ToUpper(article)) {
+		t.Error("simHash depends on case")
+	}
+	if d := bits.OnesCount64(simHash(article) ^ simHash(edited)); d > SimHashDistance {
+		t.Errorf("a one-phrase edit moved the fingerprint %d bits, want at most %d", d, SimHashDistance)
+	}
+	if d := bits.OnesCount64(simHash(article) ^ simHash(other)); d <= SimHashDistance {
+		t.Errorf("unrelated texts are only %d bits apart", d)
+	}
+}
+
+func TestRunDedupBands(t *testing.T) {
+	useTestDB(t)
+	const base = uint64(0x0123_4567_89ab_cdef)
+	pages := []struct {
+		url  string
+		fp   uint64
+		rank float64
+	}{
+		{"https://a.test/1", base, 0.1},
+		// 3 bits off, one in each of bands 0-2: band 3 still matches
+		{"https://a.test/2", base ^ (1 | 1<<16 | 1<<32), 0.5},
+		// 2 bits off inside band 3 only
+		{"https://a.test/3", base ^ (1<<48 | 1<<50), 0.1},
+		// 4 bits off, one per band: no band matches and too far anyway
+		{"https://a.test/4", base ^ (1<<1 | 1<<17 | 1<<33 | 1<<49), 0.9},
+		// unrelated
+		{"https://b.test/1", ^base, 0},
+	}
+	for _, p := range pages {
+		if _, err := db.Exec(`INSERT INTO pages (url, simhash, pagerank) VALUES (?, ?, ?)`, p.url, int64(p.fp), p.rank); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := runDedup(nil); err != nil {
+		t.Fatalf("runDedup: %v", err)
+	}
+
+	groups := map[string]string{} // url -> url of its representative
+	rows, err := db.Query(`SELECT p.url, r.url FROM pages p JOIN pages r ON r.id = p.dup_group`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var u, rep string
+		if err := rows.Scan(&u, &rep); err != nil {
+			t.Fatal(err)
+		}
+		groups[u] = rep
+	}
+	want := map[string]string{
+		// clustered under the highest pagerank
+		"https://a.test/1": "https://a.test/2",
+		"https://a.test/2": "https://a.test/2",
+		"https://a.test/3": "https://a.test/2",
+		// alone
+		"https://a.test/4": "https://a.test/4",
+		"https://b.test/1": "https://b.test/1",
+	}
+	for u, rep := range want {
+		if groups[u] != rep {
+			t.Errorf("%s grouped under %q, want %q", u, groups[u], rep)
+		}
+	}
+}
+
+func TestRunDedupTieBreak(t *testing.T) {
+	useTestDB(t)
+	// equal pagerank: the oldest row represents the cluster
+	for _, u := range []string{"https://a.test/old", "https://a.test/new"} {
+		if _, err := db.Exec(`INSERT INTO pages (url, simhash, pagerank) VALUES (?, 42, 0.3)`, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := runDedup(nil); err != nil {
+		t.Fatalf("runDedup: %v", err)
+	}
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM pages WHERE dup_group = (SELECT id FROM pages WHERE url = 'https://a.test/old')`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("%d pages grouped under the oldest row, want 2", n)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// acceptEncoding is sent on every page fetch. Setting it ourselves turns off
+// the transport's transparent gzip handling, so decoding happens in readBody
+// where the size and ratio limits are enforced.
+const acceptEncoding = "br, gzip, deflate"
+
+// bombCheckAfter is how many decoded bytes are read before the compression
+// ratio check kicks in; small pages legitimately compress very well.
+const bombCheckAfter = 1 << 20
+
+var (
+	errBodyTooLarge        = errors.New("body exceeds size limit")
+	errDecompressionBomb   = errors.New("compression ratio exceeds limit")
+	errUnsupportedEncoding = errors.New("unsupported content-encoding")
+)
+
+// ----------------------
+// Content-Encoding & body limits
+// ----------------------
+
+// readBody decodes resp.Body according to its Content-Encoding and reads at
+// most MaxBodyBytes of decoded content. Oversized bodies are truncated or
+// rejected depending on TruncateOversized; the returned bool reports a
+// truncation. Bodies whose decoded size outgrows the bytes on the wire by
+// more than MaxCompressionRatio are rejected as decompression bombs.
+func readBody(resp *http.Response) ([]byte, bool, error) {
+	if !TruncateOversized && resp.ContentLength > MaxBodyBytes {
+		return nil, false, fmt.Errorf("%w: content-length %d", errBodyTooLarge, resp.ContentLength)
+	}
+
+	wire := &countingReader{r: resp.Body}
+	decoded, err := newContentDecoder(resp.Header.Get("Content-Encoding"), wire)
+	if err != nil {
+		return nil, false, err
+	}
+	defer decoded.Close()
+
+	guard := &ratioGuard{r: decoded, wire: wire}
+	body, err := io.ReadAll(io.LimitReader(guard, MaxBodyBytes+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if int64(len(body)) <= MaxBodyBytes {
+		return body, false, nil
+	}
+	if !TruncateOversized {
+		return nil, false, fmt.Errorf("%w: more than %d bytes", errBodyTooLarge, MaxBodyBytes)
+	}
+	return body[:MaxBodyBytes], true, nil
+}
+
+// newContentDecoder stacks decoders for a Content-Encoding header value.
+// Encodings are listed in the order they were applied, so they are undone
+// from last to first.
+func newContentDecoder(header string, r io.Reader) (io.ReadCloser, error) {
+	var closers []io.Closer
+	for _, enc := range reverse(strings.Split(header, ",")) {
+		switch strings.ToLower(strings.TrimSpace(enc)) {
+		case "", "identity":
+			continue
+		case "gzip", "x-gzip":
+			zr, err := gzip.NewReader(r)
+			if err != nil {
+				return nil, fmt.Errorf("gzip: %w", err)
+			}
+			closers = append(closers, zr)
+			r = zr
+		case "deflate":
+			fr, err := newDeflateReader(r)
+			if err != nil {
+				return nil, fmt.Errorf("deflate: %w", err)
+			}
+			closers = append(closers, fr)
+			r = fr
+		case "br":
+			r = brotli.NewReader(r)
+		default:
+			return nil, fmt.Errorf("%w: %q", errUnsupportedEncoding, enc)
+		}
+	}
+	return &multiCloseReader{Reader: r, closers: closers}, nil
+}
+
+// newDeflateReader handles both flavours seen in the wild for "deflate":
+// the zlib-wrapped stream the RFC asks for and the raw stream some servers send.
+func newDeflateReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	if head[0]&0x0f == 8 && (uint16(head[0])<<8|uint16(head[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+func reverse(s []string) []string {
+	out := make([]string, len(s))
+	for i, v := range s {
+		out[len(s)-1-i] = v
+	}
+	return out
+}
+
+// countingReader counts bytes read from the wire
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// ratioGuard fails the read once decoded output outgrows the wire bytes by
+// more than MaxCompressionRatio
+type ratioGuard struct {
+	r    io.Reader
+	wire *countingReader
+	n    int64
+}
+
+func (g *ratioGuard) Read(p []byte) (int, error) {
+	n, err := g.r.Read(p)
+	g.n += int64(n)
+	if g.n > bombCheckAfter && g.n > g.wire.n*MaxCompressionRatio {
+		return n, fmt.Errorf("%w: %d bytes from %d on the wire", errDecompressionBomb, g.n, g.wire.n)
+	}
+	return n, err
+}
+
+type multiCloseReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (m *multiCloseReader) Close() error {
+	for _, c := range m.closers {
+		c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func encode(t *testing.T, encoding string, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "zlib":
+		w = zlib.NewWriter(&buf)
+	case "flate":
+		w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	case "br":
+		w = brotli.NewWriter(&buf)
+	default:
+		t.Fatalf("unknown encoding %q", encoding)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func response(contentEncoding string, body []byte) *http.Response {
+	h := http.Header{}
+	if contentEncoding != "" {
+		h.Set("Content-Encoding", contentEncoding)
+	}
+	return &http.Response{Header: h, Body: io.NopCloser(bytes.NewReader(body)), ContentLength: int64(len(body))}
+}
+
+func setBodyLimits(t *testing.T, maxBytes int64, truncate bool) {
+	t.Helper()
+	oldMax, oldTruncate := MaxBodyBytes, TruncateOversized
+	MaxBodyBytes, TruncateOversized = maxBytes, truncate
+	t.Cleanup(func() { MaxBodyBytes, TruncateOversized = oldMax, oldTruncate })
+}
+
+func TestReadBodyDecodes(t *testing.T) {
+	setBodyLimits(t, 1<<20, true)
+	page := []byte(strings.Repeat("<p>नमस्ते, hello</p>\n", 200))
+	tests := []struct {
+		name, header string
+		body         []byte
+	}{
+		{"identity", "", page},
+		{"gzip", "gzip", encode(t, "gzip", page)},
+		{"x-gzip", "x-gzip", encode(t, "gzip", page)},
+		{"zlib deflate", "deflate", encode(t, "zlib", page)},
+		{"raw deflate", "deflate", encode(t, "flate", page)},
+		{"brotli", "br", encode(t, "br", page)},
+		{"stacked", "gzip, br", encode(t, "br", encode(t, "gzip", page))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, truncated, err := readBody(response(tt.header, tt.body))
+			if err != nil {
+				t.Fatalf("readBody: %v", err)
+			}
+			if truncated || !bytes.Equal(got, page) {
+				t.Errorf("got %d bytes (truncated=%v), want the %d byte page", len(got), truncated, len(page))
+			}
+		})
+	}
+}
+
+func TestReadBodyUnsupportedEncoding(t *testing.T) {
+	if _, _, err := readBody(response("compress", []byte("x"))); !errors.Is(err, errUnsupportedEncoding) {
+		t.Errorf("err = %v, want errUnsupportedEncoding", err)
+	}
+}
+
+func TestReadBodyOversized(t *testing.T) {
+	body := bytes.Repeat([]byte("a"), 1000)
+	tests := []struct {
+		name      string
+		truncate  bool
+		resp      *http.Response
+		wantLen   int
+		wantTrunc bool
+		wantErr   error
+	}{
+		{"at the limit", true, response("", body[:100]), 100, false, nil},
+		{"truncated", true, response("", body), 100, true, nil},
+		{"truncated gzip", true, response("gzip", encode(t, "gzip", body)), 100, true, nil},
+		{"rejected", false, response("gzip", encode(t, "gzip", body)), 0, false, errBodyTooLarge},
+		{"rejected by content-length", false, response("", body), 0, false, errBodyTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setBodyLimits(t, 100, tt.truncate)
+			got, truncated, err := readBody(tt.resp)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if len(got) != tt.wantLen || truncated != tt.wantTrunc {
+				t.Errorf("got %d bytes (truncated=%v), want %d (truncated=%v)", len(got), truncated, tt.wantLen, tt.wantTrunc)
+			}
+		})
+	}
+}
+
+func TestReadBodyDecompressionBomb(t *testing.T) {
+	// 16 MiB of zeros compress about 1000:1, far past MaxCompressionRatio
+	zeros := make([]byte, 16<<20)
+	for _, enc := range []struct{ name, header, writer string }{
+		{"gzip", "gzip", "gzip"},
+		{"deflate", "deflate", "zlib"},
+		{"brotli", "br", "br"},
+	} {
+		t.Run(enc.name, func(t *testing.T) {
+			for _, truncate := range []bool{true, false} {
+				setBodyLimits(t, 64<<20, truncate)
+				_, _, err := readBody(response(enc.header, encode(t, enc.writer, zeros)))
+				if !errors.Is(err, errDecompressionBomb) {
+					t.Errorf("truncate=%v: err = %v, want errDecompressionBomb", truncate, err)
+				}
+			}
+		})
+	}
+
+	setBodyLimits(t, 64<<20, true)
+	tests := []struct {
+		name    string
+		size    int
+		wantErr error
+	}{
+		// well-compressed small pages stay under bombCheckAfter and pass
+		{"under bombCheckAfter", bombCheckAfter / 2, nil},
+		{"at bombCheckAfter", bombCheckAfter, nil},
+		// a few KiB on the wire is caught as soon as the check kicks in
+		{"small bomb", 2 * bombCheckAfter, errDecompressionBomb},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := readBody(response("gzip", encode(t, "gzip", make([]byte, tt.size))))
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+	// a bomb larger than MaxBodyBytes is a bomb, not a truncated page
+	setBodyLimits(t, bombCheckAfter+bombCheckAfter/2, true)
+	if _, _, err := readBody(response("br", encode(t, "br", zeros))); !errors.Is(err, errDecompressionBomb) {
+		t.Errorf("bomb over the size cap: err = %v, want errDecompressionBomb", err)
+	}
+}
+
+func TestReadBodyCompressedWithinRatio(t *testing.T) {
+	setBodyLimits(t, 64<<20, true)
+	// repetitive but real markup compresses well below MaxCompressionRatio
+	var b strings.Builder
+	for i := 0; b.Len() < 3*bombCheckAfter; i++ {
+		fmt.Fprintf(&b, "<li><a href=\"/item/%d\">item %d</a></li>\n", i*7919, i)
+	}
+	page := []byte(b.String())
+	for _, enc := range []struct{ header, writer string }{{"gzip", "gzip"}, {"deflate", "zlib"}, {"br", "br"}} {
+		got, truncated, err := readBody(response(enc.header, encode(t, enc.writer, page)))
+		if err != nil || truncated || !bytes.Equal(got, page) {
+			t.Errorf("%s: got %d bytes (truncated=%v), %v; want the %d byte page", enc.header, len(got), truncated, err, len(page))
+		}
+	}
+}
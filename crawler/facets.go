@@ -0,0 +1,111 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+
+	"siteconfig"
+)
+
+// ----------------------
+// Facet fields
+// ----------------------
+
+// pageLanguage returns the primary language subtag of a page ("en", "hi"):
+// from <html lang>, then Content-Language and og:locale. Undeclared pages
+// written mostly in Devanagari are taken as Hindi; otherwise "" (unknown).
+func pageLanguage(doc *goquery.Document, contentLanguage, text string) string {
+	candidates := []string{
+		doc.Find("html").AttrOr("lang", ""),
+		doc.Find("html").AttrOr("xml:lang", ""),
+		contentLanguage,
+		doc.Find(`meta[http-equiv="content-language" i]`).AttrOr("content", ""),
+		doc.Find(`meta[property="og:locale"]`).AttrOr("content", ""),
+	}
+	for _, c := range candidates {
+		if lang := primaryLanguage(c); lang != "" {
+			return lang
+		}
+	}
+	if mostlyDevanagari(text) {
+		return "hi"
+	}
+	return ""
+}
+
+// primaryLanguage reduces a language tag or list ("en-US", "en_GB", "hi, en")
+// to its first primary subtag
+func primaryLanguage(tag string) string {
+	tag = strings.TrimSpace(strings.SplitN(tag, ",", 2)[0])
+	if i := strings.IndexAny(tag, "-_"); i >= 0 {
+		tag = tag[:i]
+	}
+	tag = strings.ToLower(tag)
+	// ISO 639 codes are two or three letters; anything else is noise
+	if len(tag) < 2 || len(tag) > 3 || strings.Trim(tag, "abcdefghijklmnopqrstuvwxyz") != "" {
+		return ""
+	}
+	return tag
+}
+
+// mostlyDevanagari reports whether more than half the letters in the first
+// 8 KB of text are Devanagari
+func mostlyDevanagari(text string) bool {
+	letters, deva := 0, 0
+	for i, r := range text {
+		if i > 8000 {
+			break
+		}
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		if unicode.Is(unicode.Devanagari, r) {
+			deva++
+		}
+	}
+	return letters > 0 && deva*2 > letters
+}
+
+// backfillDomains fills pages.domain for rows stored before the column existed
+func backfillDomains() error {
+	rows, err := db.Query(`SELECT id, url FROM pages WHERE domain IS NULL OR domain = ''`)
+	if err != nil {
+		return err
+	}
+	type row struct {
+		id  int64
+		url string
+	}
+	var todo []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.url); err != nil {
+			rows.Close()
+			return err
+		}
+		todo = append(todo, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(todo) == 0 {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`UPDATE pages SET domain = ? WHERE id = ?`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, r := range todo {
+		if _, err := stmt.Exec(siteconfig.PageDomain(r.url), r.id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
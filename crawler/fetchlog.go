@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// fetchLogSchema holds one row per attempted URL
+const fetchLogSchema = `
+	CREATE TABLE IF NOT EXISTS fetch_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		url TEXT,
+		final_url TEXT,
+		domain TEXT,
+		category TEXT,
+		status_code INTEGER,
+		error_class TEXT,
+		error TEXT,
+		redirect_chain TEXT,
+		content_type TEXT,
+		latency_ms INTEGER,
+		attempts INTEGER,
+		fetched_at TEXT
+	);
+	CREATE INDEX IF NOT EXISTS idx_fetch_log_domain ON fetch_log(domain, error_class);
+	CREATE INDEX IF NOT EXISTS idx_fetch_log_fetched_at ON fetch_log(fetched_at);`
+
+// Error classes recorded in fetch_log.error_class
+const (
+	classOK                = "ok"
+	classTruncated         = "truncated"
+	classRobots            = "robots"
+	classTrap              = "trap"
+	classDNS               = "dns"
+	classTimeout           = "timeout"
+	classTLS               = "tls"
+	classConnection        = "connection"
+	classHTTP4xx           = "http_4xx"
+	classHTTP5xx           = "http_5xx"
+	classNonHTML           = "non_html"
+	classTooLarge          = "too_large"
+	classDecompressionBomb = "decompression_bomb"
+	classBadEncoding       = "bad_encoding"
+	classTooManyRedirects  = "too_many_redirects"
+	classOffsiteRedirect   = "offsite_redirect"
+	classParse             = "parse"
+	classOther             = "other"
+)
+
+var errNonHTML = errors.New("non-html content")
+
+// httpStatusError is returned for responses outside 2xx/3xx
+type httpStatusError struct {
+	Code int
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("status %d", e.Code)
+}
+
+// fetchOutcome is what gets written to fetch_log for one URL
+type fetchOutcome struct {
+	URL         string
+	FinalURL    string
+	Domain      string
+	Category    string
+	StatusCode  int
+	ErrorClass  string
+	Error       string
+	Redirects   []redirectHop
+	ContentType string
+	Latency     time.Duration
+	Attempts    int
+}
+
+// ----------------------
+// Fetch outcome recording
+// ----------------------
+func recordFetch(o fetchOutcome) {
+	chain := ""
+	if len(o.Redirects) > 0 {
+		b, _ := json.Marshal(o.Redirects)
+		chain = string(b)
+	}
+	_, err := db.Exec(`INSERT INTO fetch_log
+		(url, final_url, domain, category, status_code, error_class, error, redirect_chain, content_type, latency_ms, attempts, fetched_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		o.URL, o.FinalURL, o.Domain, o.Category, o.StatusCode, o.ErrorClass, o.Error, chain,
+		o.ContentType, o.Latency.Milliseconds(), o.Attempts, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		errLog("fetch_log insert failed for %s: %v", o.URL, err)
+	}
+}
+
+// classifyFetchError maps a fetch error to one of the class* constants
+func classifyFetchError(err error) string {
+	if err == nil {
+		return classOK
+	}
+	var statusErr *httpStatusError
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var certErr *tls.CertificateVerificationError
+	var recordErr tls.RecordHeaderError
+	var unknownAuth x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var certInvalid x509.CertificateInvalidError
+	switch {
+	case errors.As(err, &statusErr):
+		if statusErr.Code >= 500 {
+			return classHTTP5xx
+		}
+		return classHTTP4xx
+	case errors.Is(err, errNonHTML):
+		return classNonHTML
+	case errors.Is(err, errBodyTooLarge):
+		return classTooLarge
+	case errors.Is(err, errDecompressionBomb):
+		return classDecompressionBomb
+	case errors.Is(err, errUnsupportedEncoding):
+		return classBadEncoding
+	case errors.Is(err, errTooManyRedirects):
+		return classTooManyRedirects
+	case errors.Is(err, errOffsiteRedirect):
+		return classOffsiteRedirect
+	case errors.As(err, &dnsErr):
+		return classDNS
+	case errors.As(err, &certErr), errors.As(err, &recordErr), errors.As(err, &unknownAuth),
+		errors.As(err, &hostnameErr), errors.As(err, &certInvalid):
+		return classTLS
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return classTimeout
+	case errors.As(err, new(*net.OpError)):
+		return classConnection
+	}
+	return classOther
+}
+
+// ----------------------
+// `report` command (also `crawl report`)
+// ----------------------
+
+// runReport prints fetch failures grouped by class and by domain
+func runReport(args []string) error {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	since := fs.Duration("since", 0, "only include fetches newer than this (e.g. 24h); 0 means all")
+	domain := fs.String("domain", "", "restrict the report to one domain")
+	top := fs.Int("top", 10, "number of most frequent failing URLs to list")
+	fs.Parse(args)
+
+	where := []string{"1=1"}
+	var params []interface{}
+	if *since > 0 {
+		where = append(where, "fetched_at >= ?")
+		params = append(params, time.Now().Add(-*since).UTC().Format(time.RFC3339))
+	}
+	if *domain != "" {
+		where = append(where, "domain = ?")
+		params = append(params, *domain)
+	}
+	cond := strings.Join(where, " AND ")
+
+	rows, err := db.Query(`SELECT domain, error_class, COUNT(*) FROM fetch_log WHERE `+cond+
+		` GROUP BY domain, error_class`, params...)
+	if err != nil {
+		return err
+	}
+	type domainStats struct {
+		total   int
+		ok      int
+		classes map[string]int
+	}
+	byDomain := map[string]*domainStats{}
+	byClass := map[string]int{}
+	for rows.Next() {
+		var d, class string
+		var n int
+		if err := rows.Scan(&d, &class, &n); err != nil {
+			rows.Close()
+			return err
+		}
+		st := byDomain[d]
+		if st == nil {
+			st = &domainStats{classes: map[string]int{}}
+			byDomain[d] = st
+		}
+		st.total += n
+		if class == classOK || class == classTruncated {
+			st.ok += n
+		}
+		if class == classOK {
+			continue
+		}
+		st.classes[class] += n
+		byClass[class] += n
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(byDomain) == 0 {
+		info("No fetches recorded yet")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "\nFAILURES BY CLASS")
+	fmt.Fprintln(tw, "class\tcount")
+	for _, c := range sortedByCount(byClass) {
+		fmt.Fprintf(tw, "%s\t%d\n", c, byClass[c])
+	}
+
+	fmt.Fprintln(tw, "\nFAILURES BY DOMAIN")
+	fmt.Fprintln(tw, "domain\tattempted\tok\tfailed\tbreakdown")
+	domains := make([]string, 0, len(byDomain))
+	for d := range byDomain {
+		domains = append(domains, d)
+	}
+	sort.Slice(domains, func(i, j int) bool {
+		fi := byDomain[domains[i]].total - byDomain[domains[i]].ok
+		fj := byDomain[domains[j]].total - byDomain[domains[j]].ok
+		if fi != fj {
+			return fi > fj
+		}
+		return domains[i] < domains[j]
+	})
+	for _, d := range domains {
+		st := byDomain[d]
+		var parts []string
+		for _, c := range sortedByCount(st.classes) {
+			parts = append(parts, fmt.Sprintf("%s=%d", c, st.classes[c]))
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\n", d, st.total, st.ok, st.total-st.ok, strings.Join(parts, " "))
+	}
+
+	if *top > 0 {
+		rows, err := db.Query(`SELECT url, error_class, COALESCE(error, ''), COUNT(*) AS n FROM fetch_log WHERE `+cond+
+			` AND error_class NOT IN (?, ?) GROUP BY url, error_class ORDER BY n DESC, url LIMIT ?`,
+			append(params, classOK, classTruncated, *top)...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		fmt.Fprintln(tw, "\nTOP FAILING URLS")
+		fmt.Fprintln(tw, "count\tclass\turl\terror")
+		for rows.Next() {
+			var u, class, msg string
+			var n int
+			if err := rows.Scan(&u, &class, &msg, &n); err != nil {
+				return err
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", n, class, u, msg)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+func sortedByCount(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if m[keys[i]] != m[keys[j]] {
+			return m[keys[i]] > m[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// lastFetch returns when domain was last fetched successfully (zero if never)
+func lastFetch(domain string) time.Time {
+	var ts sql.NullString
+	db.QueryRow(`SELECT MAX(fetched_at) FROM fetch_log WHERE domain = ? AND error_class IN (?, ?)`,
+		domain, classOK, classTruncated).Scan(&ts)
+	t, _ := time.Parse(time.RFC3339, ts.String)
+	return t
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"siteconfig"
+)
+
+// useTestClient gives the package a short-timeout client for the test
+func useTestClient(t *testing.T) {
+	t.Helper()
+	old := httpClient
+	httpClient = &http.Client{Timeout: 2 * time.Second, CheckRedirect: checkRedirect}
+	t.Cleanup(func() { httpClient = old })
+}
+
+func TestClassifyFetchError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, classOK},
+		{&httpStatusError{Code: 404}, classHTTP4xx},
+		{fmt.Errorf("get: %w", &httpStatusError{Code: 503}), classHTTP5xx},
+		{errNonHTML, classNonHTML},
+		{errBodyTooLarge, classTooLarge},
+		{errDecompressionBomb, classDecompressionBomb},
+		{errUnsupportedEncoding, classBadEncoding},
+		{fmt.Errorf("%w: 11 hops", errTooManyRedirects), classTooManyRedirects},
+		{errOffsiteRedirect, classOffsiteRedirect},
+		{&net.DNSError{Err: "no such host", Name: "nowhere.test", IsNotFound: true}, classDNS},
+		{context.DeadlineExceeded, classTimeout},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, classConnection},
+		{errors.New("something else"), classOther},
+	}
+	for _, tt := range tests {
+		if got := classifyFetchError(tt.err); got != tt.want {
+			t.Errorf("classifyFetchError(%v) = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestProbeSeed(t *testing.T) {
+	useTestClient(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/gone" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	if status, err := probeSeed(srv.URL); status != 200 || err != nil {
+		t.Errorf("live seed: %d, %v", status, err)
+	}
+	status, err := probeSeed(srv.URL + "/gone")
+	if status != 404 || classifyFetchError(err) != classHTTP4xx {
+		t.Errorf("missing seed: %d, %v", status, err)
+	}
+}
+
+func TestUnreachableSeedRecorded(t *testing.T) {
+	useTestDB(t)
+	useTestClient(t)
+	// a port nothing listens on: both https and http are refused
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	domain := l.Addr().String()
+	l.Close()
+
+	err = crawlDomain(context.Background(), "linux", newDomainConfig(siteconfig.Site{Domain: domain}), nil)
+	if err == nil || !strings.Contains(err.Error(), "seed not reachable") {
+		t.Fatalf("crawlDomain = %v, want a seed error", err)
+	}
+	rows, err := db.Query(`SELECT url, domain, category, error_class FROM fetch_log ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var u, d, c, class string
+		if err := rows.Scan(&u, &d, &c, &class); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%s %s %s %s", u, d, c, class))
+	}
+	want := []string{
+		"https://" + domain + " " + domain + " linux connection",
+		"http://" + domain + " " + domain + " linux connection",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("fetch_log:\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"container/heap"
+	"math"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// frontierEntry is a URL waiting to be crawled together with what is known
+// about it when it is scored
+type frontierEntry struct {
+	URL      string
+	Depth    int     // link hops from a seed; sitemap URLs count as one hop
+	Priority float64 // <priority> from a sitemap (0..1), -1 when not listed in one
+	Inlinks  int     // crawled pages seen linking here so far
+
+	score float64
+	seq   int // insertion order, breaks ties so equal scores stay FIFO
+	index int // position in the heap
+}
+
+// URLScorer ranks frontier entries; higher scores are crawled first. Scores
+// are recomputed whenever an entry gains an inbound link.
+type URLScorer func(e *frontierEntry) float64
+
+var (
+	lowValuePath = regexp.MustCompile(`(?i)(^|[/_.?&=-])(login|log-in|signin|sign-in|signup|sign-up|register|logout|log-out|cart|basket|checkout|account|my-account|wishlist|password|auth|oauth)([/_.?&=-]|$)`)
+	utilityPath  = regexp.MustCompile(`(?i)(^|[/_.?&=-])(print|share|feed|rss|search|replytocom|comment-page)([/_.?&=-]|$)`)
+)
+
+// ----------------------
+// Scoring
+// ----------------------
+
+// defaultURLScorer prefers shallow URLs, URLs a sitemap rates highly and URLs
+// many pages link to, and pushes account, cart and other utility pages back
+func defaultURLScorer(e *frontierEntry) float64 {
+	score := -float64(e.Depth)
+	if e.Priority >= 0 {
+		score += 2 * e.Priority
+	}
+	score += 0.5 * math.Log1p(float64(e.Inlinks))
+	if u, err := url.Parse(e.URL); err == nil {
+		target := u.RequestURI()
+		if lowValuePath.MatchString(target) {
+			score -= 3
+		} else if utilityPath.MatchString(target) {
+			score--
+		}
+	}
+	return score
+}
+
+// ----------------------
+// Priority frontier
+// ----------------------
+
+// frontier is a per-domain priority queue of URLs to crawl. It is safe for
+// concurrent use; callers dedupe URLs before pushing.
+type frontier struct {
+	mu      sync.Mutex
+	entries frontierHeap
+	queued  map[string]*frontierEntry
+	scorer  URLScorer
+	limit   int
+	seq     int
+}
+
+func newFrontier(scorer URLScorer, limit int) *frontier {
+	if scorer == nil {
+		scorer = defaultURLScorer
+	}
+	return &frontier{queued: map[string]*frontierEntry{}, scorer: scorer, limit: limit}
+}
+
+// push queues e. When the frontier is full the lowest-scored entry is dropped
+// (possibly e itself); push reports whether e was kept.
+func (f *frontier) push(e frontierEntry) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.queued[e.URL]; ok {
+		return true
+	}
+	entry := &e
+	entry.score = f.scorer(entry)
+	entry.seq = f.seq
+	f.seq++
+	if f.limit > 0 && len(f.entries) >= f.limit {
+		worst := f.entries.worst()
+		if !f.entries.less(worst, entry) {
+			return false // e ranks no higher than anything queued
+		}
+		delete(f.queued, worst.URL)
+		heap.Remove(&f.entries, worst.index)
+	}
+	f.queued[entry.URL] = entry
+	heap.Push(&f.entries, entry)
+	return true
+}
+
+// link records another inbound link to u if it is still queued
+func (f *frontier) link(u string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if e, ok := f.queued[u]; ok {
+		e.Inlinks++
+		e.score = f.scorer(e)
+		heap.Fix(&f.entries, e.index)
+	}
+}
+
+// pop removes and returns the highest-scored entry
+func (f *frontier) pop() (frontierEntry, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.entries) == 0 {
+		return frontierEntry{}, false
+	}
+	e := heap.Pop(&f.entries).(*frontierEntry)
+	delete(f.queued, e.URL)
+	return *e, true
+}
+
+func (f *frontier) len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.entries)
+}
+
+// frontierHeap implements heap.Interface with the best entry on top
+type frontierHeap []*frontierEntry
+
+// less reports whether a ranks below b
+func (h frontierHeap) less(a, b *frontierEntry) bool {
+	if a.score != b.score {
+		return a.score < b.score
+	}
+	return a.seq > b.seq
+}
+
+// worst returns the lowest-ranked entry, which is always a leaf
+func (h frontierHeap) worst() *frontierEntry {
+	w := h[len(h)/2]
+	for _, e := range h[len(h)/2:] {
+		if h.less(e, w) {
+			w = e
+		}
+	}
+	return w
+}
+
+func (h frontierHeap) Len() int           { return len(h) }
+func (h frontierHeap) Less(i, j int) bool { return h.less(h[j], h[i]) }
+func (h frontierHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *frontierHeap) Push(x any) {
+	e := x.(*frontierEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *frontierHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	e.index = -1
+	return e
+}
+
+// normalizeURL gives a crawlable URL one spelling: no fragment, no session
+// or tracking parameters and "/" for an empty path
+func normalizeURL(u *url.URL) string {
+	u.Fragment = ""
+	stripSessionParams(u)
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	u.Host = strings.ToLower(u.Host)
+	return u.String()
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/url"
+	"slices"
+	"testing"
+)
+
+func popAll(f *frontier) []string {
+	var out []string
+	for {
+		e, ok := f.pop()
+		if !ok {
+			return out
+		}
+		out = append(out, e.URL)
+	}
+}
+
+func TestFrontierOrder(t *testing.T) {
+	f := newFrontier(nil, 0)
+	for _, e := range []frontierEntry{
+		{URL: "https://a.test/deep/page", Depth: 3, Priority: -1},
+		{URL: "https://a.test/login", Depth: 1, Priority: -1},
+		{URL: "https://a.test/about", Depth: 1, Priority: -1},
+		{URL: "https://a.test/", Depth: 0, Priority: -1},
+		{URL: "https://a.test/sitemap-top", Depth: 1, Priority: 1},
+		{URL: "https://a.test/feed", Depth: 1, Priority: -1},
+		{URL: "https://a.test/contact", Depth: 1, Priority: -1},
+	} {
+		f.push(e)
+	}
+	want := []string{
+		"https://a.test/sitemap-top", // depth 1 + 2 for sitemap priority 1.0
+		"https://a.test/",
+		"https://a.test/about", // equal scores stay in push order
+		"https://a.test/contact",
+		"https://a.test/feed", // utility page
+		"https://a.test/deep/page",
+		"https://a.test/login", // account page
+	}
+	if got := popAll(f); !slices.Equal(got, want) {
+		t.Errorf("pop order:\n got %q\nwant %q", got, want)
+	}
+}
+
+func TestFrontierInlinks(t *testing.T) {
+	f := newFrontier(nil, 0)
+	f.push(frontierEntry{URL: "https://a.test/x", Depth: 2, Priority: -1})
+	f.push(frontierEntry{URL: "https://a.test/y", Depth: 2, Priority: -1})
+	for range 5 {
+		f.link("https://a.test/y")
+	}
+	f.link("https://a.test/not-queued")
+	if got := popAll(f); !slices.Equal(got, []string{"https://a.test/y", "https://a.test/x"}) {
+		t.Errorf("linked entry not promoted: %q", got)
+	}
+}
+
+func TestFrontierLimit(t *testing.T) {
+	f := newFrontier(nil, 2)
+	if !f.push(frontierEntry{URL: "https://a.test/d2", Depth: 2, Priority: -1}) ||
+		!f.push(frontierEntry{URL: "https://a.test/d1", Depth: 1, Priority: -1}) {
+		t.Fatal("push into a frontier with room was refused")
+	}
+	// a better entry evicts the worst one
+	if !f.push(frontierEntry{URL: "https://a.test/d0", Depth: 0, Priority: -1}) {
+		t.Error("better entry refused by a full frontier")
+	}
+	// a worse one is refused
+	if f.push(frontierEntry{URL: "https://a.test/d5", Depth: 5, Priority: -1}) {
+		t.Error("worse entry accepted by a full frontier")
+	}
+	// re-pushing a queued URL is a no-op
+	if !f.push(frontierEntry{URL: "https://a.test/d1", Depth: 9, Priority: -1}) || f.len() != 2 {
+		t.Errorf("re-push changed the frontier: len %d", f.len())
+	}
+	if got := popAll(f); !slices.Equal(got, []string{"https://a.test/d0", "https://a.test/d1"}) {
+		t.Errorf("kept %q", got)
+	}
+}
+
+func TestNormalizeURL(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"https://A.Test", "https://a.test/"},
+		{"https://a.test/p#section", "https://a.test/p"},
+		{"https://a.test/p?utm_source=x&id=1", "https://a.test/p?id=1"},
+		{"https://a.test/p/", "https://a.test/p/"},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := normalizeURL(u); got != tt.want {
+			t.Errorf("normalizeURL(%s) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
@@ -4,15 +4,23 @@ go 1.25.1
 
 require (
 	github.com/PuerkitoBio/goquery v1.10.3
+	github.com/andybalholm/brotli v1.1.1
 	github.com/fatih/color v1.18.0
 	github.com/mattn/go-sqlite3 v1.14.32
 	github.com/temoto/robotstxt v1.1.2
+	golang.org/x/net v0.39.0
+	golang.org/x/text v0.24.0
+	sanitize v0.0.0
+	siteconfig v0.0.0
 )
 
 require (
 	github.com/andybalholm/cascadia v1.3.3 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
-	golang.org/x/net v0.39.0 // indirect
 	golang.org/x/sys v0.32.0 // indirect
 )
+
+replace siteconfig => ../siteconfig
+
+replace sanitize => ../sanitize
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"flag"
+	"math"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// linksSchema stores every outgoing link seen on a crawled page
+const linksSchema = `
+	CREATE TABLE IF NOT EXISTS links (
+		source TEXT NOT NULL,
+		target TEXT NOT NULL,
+		anchor TEXT NOT NULL DEFAULT '',
+		discovered_at TEXT,
+		PRIMARY KEY (source, target, anchor)
+	) WITHOUT ROWID;
+	CREATE INDEX IF NOT EXISTS idx_links_target ON links(target);`
+
+// maxAnchorLen caps stored anchor text; longer anchors are usually whole cards
+const maxAnchorLen = 200
+
+// link is one source→target edge
+type link struct {
+	Target string
+	Anchor string
+}
+
+// resolveLink resolves href on the page at base into a web URL, and target,
+// its spelling in pages.url (see normalizeURL), so edges join stored pages
+func resolveLink(base, href string) (u *url.URL, target string, ok bool) {
+	abs := toAbsoluteURL(base, href)
+	if abs == "" {
+		return nil, "", false
+	}
+	u, err := url.Parse(abs)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, "", false
+	}
+	return u, normalizeURL(u), true
+}
+
+// ----------------------
+// Link graph storage
+// ----------------------
+
+// saveLinks replaces the outgoing edges of source with links. Targets are
+// stored normalized, however the caller spelled them, so they join pages.url
+// and url_aliases; unparsable ones are dropped.
+func saveLinks(source string, links []link) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM links WHERE source = ?`, source); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(`INSERT OR IGNORE INTO links (source, target, anchor, discovered_at) VALUES (?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, l := range links {
+		u, err := url.Parse(l.Target)
+		if err != nil || !u.IsAbs() {
+			continue
+		}
+		if _, err := stmt.Exec(source, normalizeURL(u), l.Anchor, now); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// cleanAnchor collapses whitespace and trims anchor text to maxAnchorLen runes
+func cleanAnchor(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > maxAnchorLen {
+		s = string(r[:maxAnchorLen])
+	}
+	return s
+}
+
+// ----------------------
+// `pagerank` command
+// ----------------------
+
+// runPageRank computes PageRank over the stored link graph and writes the
+// max-normalised score (0..1) to pages.pagerank. Redirect aliases are folded
+// into their targets and self links are ignored.
+func runPageRank(args []string) error {
+	fs := flag.NewFlagSet("pagerank", flag.ExitOnError)
+	damping := fs.Float64("damping", 0.85, "probability of following a link rather than jumping")
+	iterations := fs.Int("iterations", 50, "maximum power iterations")
+	tolerance := fs.Float64("tolerance", 1e-6, "stop once the L1 change between iterations drops below this")
+	fs.Parse(args)
+
+	aliases := map[string]string{}
+	rows, err := db.Query(`SELECT alias, target FROM url_aliases`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var a, t string
+		if err := rows.Scan(&a, &t); err != nil {
+			rows.Close()
+			return err
+		}
+		aliases[a] = t
+	}
+	rows.Close()
+	resolve := func(u string) string {
+		if t, ok := aliases[u]; ok {
+			return t
+		}
+		return u
+	}
+
+	// node ids and deduplicated adjacency
+	ids := map[string]int{}
+	var urls []string
+	node := func(u string) int {
+		if id, ok := ids[u]; ok {
+			return id
+		}
+		ids[u] = len(urls)
+		urls = append(urls, u)
+		return ids[u]
+	}
+	out := map[int]map[int]struct{}{}
+	rows, err = db.Query(`SELECT DISTINCT source, target FROM links`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var s, t string
+		if err := rows.Scan(&s, &t); err != nil {
+			rows.Close()
+			return err
+		}
+		src, dst := node(resolve(s)), node(resolve(t))
+		if src == dst {
+			continue
+		}
+		if out[src] == nil {
+			out[src] = map[int]struct{}{}
+		}
+		out[src][dst] = struct{}{}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	n := len(urls)
+	if n == 0 {
+		info("No links stored yet — run a crawl first")
+		return nil
+	}
+
+	// power iteration; rank from dangling nodes is spread evenly
+	rank := make([]float64, n)
+	next := make([]float64, n)
+	for i := range rank {
+		rank[i] = 1 / float64(n)
+	}
+	iter := 0
+	for iter < *iterations {
+		iter++
+		dangling := 0.0
+		for i := range next {
+			next[i] = 0
+		}
+		for i, r := range rank {
+			targets := out[i]
+			if len(targets) == 0 {
+				dangling += r
+				continue
+			}
+			share := r / float64(len(targets))
+			for t := range targets {
+				next[t] += share
+			}
+		}
+		base := (1-*damping)/float64(n) + *damping*dangling/float64(n)
+		delta := 0.0
+		for i := range next {
+			next[i] = base + *damping*next[i]
+			delta += math.Abs(next[i] - rank[i])
+		}
+		rank, next = next, rank
+		if delta < *tolerance {
+			break
+		}
+	}
+
+	maxRank := 0.0
+	for _, r := range rank {
+		maxRank = math.Max(maxRank, r)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`UPDATE pages SET pagerank = 0`); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(`UPDATE pages SET pagerank = ? WHERE url = ?`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	updated := int64(0)
+	for i, u := range urls {
+		res, err := stmt.Exec(rank[i]/maxRank, u)
+		if err != nil {
+			return err
+		}
+		m, _ := res.RowsAffected()
+		updated += m
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	info("PageRank: %d nodes, %d iterations, %d pages scored", n, iter, updated)
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestResolveLink(t *testing.T) {
+	const base = "https://a.test/docs/page"
+	tests := []struct {
+		href, want string // want "" when the link is skipped
+	}{
+		{"other", "https://a.test/docs/other"},
+		{"/x#part", "https://a.test/x"},
+		{"HTTP://Example.COM", "http://example.com/"},
+		{"https://example.com/p?utm_source=x&id=1", "https://example.com/p?id=1"},
+		{"//cdn.test/lib", "https://cdn.test/lib"},
+		{"mailto:someone@a.test", ""},
+		{"javascript:void(0)", ""},
+		{"", ""},
+		{"http://[::1", ""},
+	}
+	for _, tt := range tests {
+		_, got, ok := resolveLink(base, tt.href)
+		if ok != (tt.want != "") || got != tt.want {
+			t.Errorf("resolveLink(%q) = %q, %v; want %q", tt.href, got, ok, tt.want)
+		}
+	}
+}
+
+func TestRecordAliasesNormalized(t *testing.T) {
+	useTestDB(t)
+	recordAliases([]redirectHop{
+		{URL: "https://a.test/new", Status: 301}, // the target itself
+		{URL: "HTTP://A.test/old?utm_campaign=y", Status: 301},
+		{URL: "http://a.test/older#top", Status: 302},
+	}, "https://a.test/new")
+	rows, err := db.Query(`SELECT alias, target FROM url_aliases ORDER BY alias`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var a, tg string
+		if err := rows.Scan(&a, &tg); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, a+" -> "+tg)
+	}
+	want := []string{"http://a.test/old -> https://a.test/new", "http://a.test/older -> https://a.test/new"}
+	if !slices.Equal(got, want) {
+		t.Errorf("aliases = %q, want %q", got, want)
+	}
+}
+
+// TestPageRankJoinsPages links pages the way they are written in HTML and
+// checks every stored page gets its rank
+func TestPageRankJoinsPages(t *testing.T) {
+	useTestDB(t)
+	pages := []string{"https://a.test/", "https://a.test/docs", "https://b.test/"}
+	for _, u := range pages {
+		if _, err := db.Exec(`INSERT INTO pages (url, title) VALUES (?, 'p')`, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recordAliases([]redirectHop{{URL: "https://a.test/old-docs", Status: 301}}, "https://a.test/docs")
+	edges := map[string][]string{
+		"https://a.test/":     {"/docs?utm_source=nav", "HTTPS://B.TEST"},
+		"https://a.test/docs": {"/#top", "https://b.test/?fbclid=1"},
+		"https://b.test/":     {"https://A.test/old-docs?utm_medium=x"},
+	}
+	for source, hrefs := range edges {
+		var links []link
+		for _, href := range hrefs {
+			_, target, ok := resolveLink(source, href)
+			if !ok {
+				t.Fatalf("resolveLink(%q) skipped", href)
+			}
+			links = append(links, link{Target: target})
+		}
+		if err := saveLinks(source, links); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := runPageRank(nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range pages {
+		var rank float64
+		if err := db.QueryRow(`SELECT pagerank FROM pages WHERE url = ?`, u).Scan(&rank); err != nil {
+			t.Fatal(err)
+		}
+		if rank <= 0 {
+			t.Errorf("%s has pagerank %v; its inbound links missed it", u, rank)
+		}
+	}
+}
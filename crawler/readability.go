@@ -0,0 +1,348 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+const (
+	maxContentLen    = 100000    // runes of main content stored per page
+	maxContentHTML   = 512 << 10 // bytes of main content markup stored per page
+	maxSnippetLen    = 300       // runes of a snippet derived from content
+	minParagraphLen  = 25        // shorter blocks do not vote for their container
+	minContentLen    = 250       // below this the extraction is treated as failed
+	siblingThreshold = 0.2       // share of the top score a sibling needs to be kept
+)
+
+var (
+	// unlikelyCandidates name page furniture rather than content
+	unlikelyCandidates = regexp.MustCompile(`(?i)banner|breadcrumb|combx|comment|community|consent|cookie|disqus|extra|footer|gdpr|header|legends|menu|modal|nav|newsletter|pager|pagination|popup|promo|related|remark|replies|rss|share|shoutbox|sidebar|skyscraper|social|sponsor|subscribe|supplemental|tags|toolbar|widget|ad-break|agegate`)
+	// maybeCandidates rescue elements that also match unlikelyCandidates
+	maybeCandidates = regexp.MustCompile(`(?i)and|article|body|column|content|main|shadow`)
+	positiveHints   = regexp.MustCompile(`(?i)article|body|content|entry|hentry|h-entry|main|page|post|text|blog|story`)
+	negativeHints   = regexp.MustCompile(`(?i)-ad-|hidden|^hid$| hid$| hid |^hid |banner|combx|comment|com-|contact|cookie|consent|footer|footnote|gdpr|masthead|media|meta|modal|outbrain|popup|promo|related|scroll|share|shoutbox|sidebar|skyscraper|sponsor|shopping|social|tags|tool|widget`)
+)
+
+// skipTags never hold main content
+var skipTags = map[string]bool{
+	"script": true, "style": true, "noscript": true, "template": true, "svg": true,
+	"iframe": true, "form": true, "button": true, "select": true, "textarea": true,
+	"nav": true, "header": true, "footer": true, "aside": true, "dialog": true,
+}
+
+// blockTags start a new line in extracted text
+var blockTags = map[string]bool{
+	"p": true, "div": true, "section": true, "article": true, "main": true, "br": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"li": true, "ul": true, "ol": true, "dl": true, "dt": true, "dd": true,
+	"pre": true, "blockquote": true, "table": true, "tr": true, "td": true, "th": true,
+	"figure": true, "figcaption": true, "hr": true,
+}
+
+// ----------------------
+// Main content extraction
+// ----------------------
+
+// mainContent is the extracted article as plain text and as markup. The
+// markup is not sanitised yet; savePage does that before storing it.
+type mainContent struct {
+	Text string
+	HTML string
+}
+
+// extractMainContent returns the article text of a page, readability style:
+// text blocks vote for their parent and grandparent with a score built from
+// their length and comma count, containers are weighted by tag and class/id
+// hints and discounted by link density, and the best container is returned
+// together with siblings that score close to it. Navigation, banners, cookie
+// notices and similar furniture are skipped. When no container stands out
+// the visible body text, minus that furniture, is used instead.
+func extractMainContent(doc *goquery.Document) mainContent {
+	body := doc.Find("body")
+	if body.Length() == 0 {
+		return mainContent{}
+	}
+	scores := map[*html.Node]float64{}
+	var order []*html.Node
+
+	vote := func(n *html.Node, points float64) {
+		if n == nil || n.Type != html.ElementNode {
+			return
+		}
+		if _, ok := scores[n]; !ok {
+			scores[n] = initialScore(n)
+			order = append(order, n)
+		}
+		scores[n] += points
+	}
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			if skipTags[n.Data] || isUnlikely(n) {
+				return
+			}
+			switch n.Data {
+			case "p", "pre", "td", "blockquote":
+				text := blockText(n)
+				if l := utf8.RuneCountInString(text); l >= minParagraphLen {
+					points := 1 + float64(strings.Count(text, ",")) + min(float64(l)/100, 3)
+					vote(n.Parent, points)
+					if n.Parent != nil {
+						vote(n.Parent.Parent, points/2)
+					}
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	for _, n := range body.Nodes {
+		walk(n)
+	}
+
+	var top *html.Node
+	best := 0.0
+	for _, n := range order {
+		scores[n] *= 1 - linkDensity(n)
+		if top == nil || scores[n] > best {
+			top, best = n, scores[n]
+		}
+	}
+
+	var nodes []*html.Node
+	if top != nil {
+		nodes = siblingContent(top, scores, best)
+	}
+	text := nodesText(nodes)
+	if utf8.RuneCountInString(text) < minContentLen {
+		if fallback := blockText(body.Nodes[0]); utf8.RuneCountInString(fallback) > utf8.RuneCountInString(text) {
+			nodes, text = body.Nodes[:1], fallback
+		}
+	}
+	if r := []rune(text); len(r) > maxContentLen {
+		text = string(r[:maxContentLen])
+	}
+	return mainContent{Text: text, HTML: renderContent(nodes)}
+}
+
+// siblingContent returns top with the siblings that look like more of the same article
+func siblingContent(top *html.Node, scores map[*html.Node]float64, best float64) []*html.Node {
+	if top.Parent == nil {
+		return []*html.Node{top}
+	}
+	threshold := max(10, best*siblingThreshold)
+	var kept []*html.Node
+	for s := top.Parent.FirstChild; s != nil; s = s.NextSibling {
+		if s.Type != html.ElementNode || skipTags[s.Data] || isUnlikely(s) {
+			continue
+		}
+		keep := s == top
+		if score, ok := scores[s]; ok && score >= threshold {
+			keep = true
+		}
+		if !keep && s.Data == "p" {
+			text := blockText(s)
+			l := utf8.RuneCountInString(text)
+			density := linkDensity(s)
+			keep = (l > 80 && density < 0.25) || (l > 0 && l <= 80 && density == 0 && strings.ContainsAny(text, ".!?"))
+		}
+		if keep {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
+
+func nodesText(nodes []*html.Node) string {
+	var parts []string
+	for _, n := range nodes {
+		if text := blockText(n); text != "" {
+			parts = append(parts, text)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// renderContent serialises nodes without the furniture blockText skips,
+// stopping before maxContentHTML is exceeded
+func renderContent(nodes []*html.Node) string {
+	var out strings.Builder
+	for _, n := range nodes {
+		var buf strings.Builder
+		if err := html.Render(&buf, cleanClone(n)); err != nil {
+			continue
+		}
+		if out.Len()+buf.Len() > maxContentHTML {
+			break
+		}
+		out.WriteString(buf.String())
+	}
+	return out.String()
+}
+
+// cleanClone deep-copies n, leaving out skipped and unlikely descendants
+func cleanClone(n *html.Node) *html.Node {
+	c := &html.Node{Type: n.Type, DataAtom: n.DataAtom, Data: n.Data, Namespace: n.Namespace, Attr: n.Attr}
+	for k := n.FirstChild; k != nil; k = k.NextSibling {
+		if k.Type == html.CommentNode {
+			continue
+		}
+		if k.Type == html.ElementNode && (skipTags[k.Data] || isUnlikely(k)) {
+			continue
+		}
+		c.AppendChild(cleanClone(k))
+	}
+	return c
+}
+
+// initialScore weights a container by its tag and class/id hints
+func initialScore(n *html.Node) float64 {
+	score := 0.0
+	switch n.Data {
+	case "article", "main":
+		score += 10
+	case "div":
+		score += 5
+	case "pre", "td", "blockquote":
+		score += 3
+	case "address", "ol", "ul", "dl", "dd", "dt", "li":
+		score -= 3
+	case "h1", "h2", "h3", "h4", "h5", "h6", "th":
+		score -= 5
+	}
+	hints := attr(n, "class") + " " + attr(n, "id")
+	if negativeHints.MatchString(hints) {
+		score -= 25
+	}
+	if positiveHints.MatchString(hints) {
+		score += 25
+	}
+	if attr(n, "itemprop") == "articleBody" || attr(n, "role") == "main" {
+		score += 25
+	}
+	return score
+}
+
+func isUnlikely(n *html.Node) bool {
+	if n.Data == "body" || n.Data == "article" || n.Data == "main" || n.Data == "a" {
+		return false
+	}
+	if attr(n, "aria-hidden") == "true" || hasAttr(n, "hidden") {
+		return true
+	}
+	switch attr(n, "role") {
+	case "navigation", "banner", "contentinfo", "complementary", "dialog", "alertdialog", "menu", "menubar":
+		return true
+	}
+	hints := attr(n, "class") + " " + attr(n, "id")
+	return unlikelyCandidates.MatchString(hints) && !maybeCandidates.MatchString(hints)
+}
+
+// linkDensity is the share of n's text that sits inside links
+func linkDensity(n *html.Node) float64 {
+	total := utf8.RuneCountInString(blockText(n))
+	if total == 0 {
+		return 0
+	}
+	linked := 0
+	var walk func(*html.Node)
+	walk = func(c *html.Node) {
+		if c.Type == html.ElementNode && c.Data == "a" {
+			linked += utf8.RuneCountInString(blockText(c))
+			return
+		}
+		for k := c.FirstChild; k != nil; k = k.NextSibling {
+			walk(k)
+		}
+	}
+	walk(n)
+	return float64(linked) / float64(total)
+}
+
+// blockText renders n as plain text with one line per block element,
+// skipping furniture; runs of whitespace inside a line are collapsed
+func blockText(n *html.Node) string {
+	var sb strings.Builder
+	var walk func(*html.Node)
+	walk = func(c *html.Node) {
+		switch c.Type {
+		case html.TextNode:
+			sb.WriteString(c.Data)
+			return
+		case html.ElementNode:
+			if c != n && (skipTags[c.Data] || isUnlikely(c)) {
+				return
+			}
+			if blockTags[c.Data] {
+				sb.WriteByte('\n')
+				defer sb.WriteByte('\n')
+			}
+		}
+		for k := c.FirstChild; k != nil; k = k.NextSibling {
+			walk(k)
+		}
+	}
+	walk(n)
+	var lines []string
+	for _, line := range strings.Split(sb.String(), "\n") {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func hasAttr(n *html.Node, key string) bool {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// contentSnippet builds a snippet from the start of the main content, cut at
+// a sentence or word boundary
+func contentSnippet(content string) string {
+	var text string
+	for _, line := range strings.Split(content, "\n") {
+		// skip headings, bylines and other short lines before the first paragraph
+		if utf8.RuneCountInString(line) >= minParagraphLen*2 || text != "" {
+			text += line + " "
+		}
+		if utf8.RuneCountInString(text) >= maxSnippetLen {
+			break
+		}
+	}
+	if text == "" {
+		text = strings.ReplaceAll(content, "\n", " ")
+	}
+	text = strings.TrimSpace(text)
+	r := []rune(text)
+	if len(r) <= maxSnippetLen {
+		return text
+	}
+	cut := string(r[:maxSnippetLen])
+	if i := strings.LastIndexAny(cut, ".!?"); i > maxSnippetLen/2 {
+		return cut[:i+1]
+	}
+	if i := strings.LastIndex(cut, " "); i > 0 {
+		cut = cut[:i]
+	}
+	return cut + "…"
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+const articlePara = "The kernel release brings faster boot times, better power management on laptops, and a reworked scheduler that favours interactive tasks."
+
+func TestExtractMainContent(t *testing.T) {
+	links := strings.Repeat(`<a href="/x">A related story with a long enough headline to count</a> `, 8)
+	page := `<html><body>
+		<header><h1>Site name</h1><p>Tagline of the site that is long enough to vote, honestly.</p></header>
+		<nav><p>Home, News, Reviews, Tutorials, Downloads, Forum, About us, Contact</p></nav>
+		<div class="cookie-consent"><p>We use cookies to improve your experience, accept them please.</p></div>
+		<div id="main" class="post">
+			<h2>Linux 6.9 released</h2>
+			<p>` + articlePara + `</p>
+			<p>` + articlePara + `</p>
+			<script>track("view")</script>
+			<p>` + articlePara + `</p>
+			<div class="share"><p>Share this on every network you know of, right now.</p></div>
+		</div>
+		<p>Short, closing sentence.</p>
+		<div class="links"><p>` + links + `</p></div>
+		<aside><p>` + articlePara + `</p></aside>
+		<div id="comments"><p>First comment, with plenty of words, commas, and opinions.</p></div>
+		<footer><p>Copyright, all rights reserved, terms, privacy, imprint.</p></footer>
+	</body></html>`
+
+	got := extractMainContent(mustDoc(t, page))
+	if n := strings.Count(got.Text, articlePara); n != 3 {
+		t.Errorf("article paragraphs kept %d times, want 3:\n%s", n, got.Text)
+	}
+	if !strings.Contains(got.Text, "Linux 6.9 released") || !strings.Contains(got.Text, "Short, closing sentence.") {
+		t.Errorf("heading or sibling paragraph lost:\n%s", got.Text)
+	}
+	for _, furniture := range []string{"Tagline", "Home, News", "cookies", "Share this", "related story", "First comment", "Copyright", "track("} {
+		if strings.Contains(got.Text, furniture) || strings.Contains(got.HTML, furniture) {
+			t.Errorf("%q was extracted", furniture)
+		}
+	}
+	if !strings.Contains(got.HTML, `<div id="main" class="post">`) || !strings.Contains(got.HTML, "<h2>Linux 6.9 released</h2>") {
+		t.Errorf("markup of the article container missing:\n%s", got.HTML)
+	}
+}
+
+func TestExtractMainContentFallsBack(t *testing.T) {
+	tests := []struct {
+		name, html, want string
+	}{
+		{"no paragraphs", `<body><div>Just a short line.</div><nav>Menu</nav><span>And a span.</span></body>`, "Just a short line.\nAnd a span."},
+		{"hidden and role furniture is dropped", `<body><p>Visible.</p><div hidden>Hidden.</div><div role="navigation">Nav</div></body>`, "Visible."},
+		{"empty body", `<body></body>`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractMainContent(mustDoc(t, tt.html)); got.Text != tt.want {
+				t.Errorf("text = %q, want %q", got.Text, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractMainContentLimits(t *testing.T) {
+	para := "<p>" + strings.Repeat("नमस्ते, ", 200) + "</p>"
+	got := extractMainContent(mustDoc(t, "<body><article>"+strings.Repeat(para, 100)+"</article></body>"))
+	if n := utf8.RuneCountInString(got.Text); n != maxContentLen {
+		t.Errorf("text is %d runes, want the cap of %d", n, maxContentLen)
+	}
+	if !utf8.ValidString(got.Text) {
+		t.Error("text cut inside a rune")
+	}
+
+	big := "<p>" + strings.Repeat("word, ", maxContentHTML/12) + "</p>"
+	got = extractMainContent(mustDoc(t, "<body><article>"+big+"</article><article>"+big+"</article></body>"))
+	if len(got.HTML) > maxContentHTML {
+		t.Errorf("markup is %d bytes, over the cap of %d", len(got.HTML), maxContentHTML)
+	}
+}
+
+func TestContentSnippet(t *testing.T) {
+	long := strings.Repeat("lorem ipsum dolor ", 30)
+	tests := []struct {
+		name, content, want string
+	}{
+		{"short lines before the first paragraph are skipped", "Linux 6.9 released\nBy Asha Rao\n" + articlePara, articlePara},
+		{"only short lines", "Home\nAbout", "Home About"},
+		{"cut at a sentence", articlePara + " " + articlePara + " " + articlePara, articlePara + " " + articlePara},
+		{"cut at a word", long, strings.TrimSpace(long[:strings.LastIndex(long[:maxSnippetLen], " ")]) + "…"},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := contentSnippet(tt.content); got != tt.want {
+				t.Errorf("contentSnippet = %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// aliasSchema maps URLs that redirected somewhere to the page they landed on
+const aliasSchema = `
+	CREATE TABLE IF NOT EXISTS url_aliases (
+		alias TEXT PRIMARY KEY,
+		target TEXT NOT NULL,
+		recorded_at TEXT
+	);
+	CREATE INDEX IF NOT EXISTS idx_url_aliases_target ON url_aliases(target);`
+
+var (
+	errTooManyRedirects = errors.New("too many redirects")
+	errOffsiteRedirect  = errors.New("redirect leaves crawl scope")
+)
+
+// redirectHop is one 3xx response on the way to the final URL
+type redirectHop struct {
+	URL    string `json:"url"`
+	Status int    `json:"status"`
+}
+
+// scopeKey carries the sites a fetch may be redirected to
+type scopeKey struct{}
+
+// withRedirectScope limits redirects followed for requests made with ctx to
+// hosts that one of sites allows
+func withRedirectScope(ctx context.Context, sites []DomainConfig) context.Context {
+	return context.WithValue(ctx, scopeKey{}, sites)
+}
+
+// ----------------------
+// Redirect policy
+// ----------------------
+
+// checkRedirect is the http.Client redirect policy: it caps the chain at
+// MaxRedirects and, for requests that carry a scope, refuses to follow a
+// redirect to a host outside it
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	// via starts with the original request, so following req makes len(via) hops
+	if len(via) > MaxRedirects {
+		return fmt.Errorf("%w: stopped after %d", errTooManyRedirects, MaxRedirects)
+	}
+	sites, _ := req.Context().Value(scopeKey{}).([]DomainConfig)
+	if len(sites) == 0 {
+		return nil
+	}
+	host := req.URL.Hostname()
+	for i := range sites {
+		if sites[i].AllowsHost(host) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", errOffsiteRedirect, req.URL)
+}
+
+// redirectChain lists every hop taken before the request that produced resp
+func redirectChain(resp *http.Response) []redirectHop {
+	var chain []redirectHop
+	for r := resp.Request; r != nil && r.Response != nil; r = r.Response.Request {
+		hop := redirectHop{URL: r.Response.Request.URL.String(), Status: r.Response.StatusCode}
+		chain = append([]redirectHop{hop}, chain...)
+	}
+	return chain
+}
+
+// ----------------------
+// Aliases
+// ----------------------
+
+// recordAliases points every URL in the redirect chain at target (already
+// normalized) so later lookups of a redirecting URL resolve to the stored
+// page. Aliases are normalized too, to match link targets.
+func recordAliases(chain []redirectHop, target string) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, hop := range chain {
+		u, err := url.Parse(hop.URL)
+		if err != nil {
+			continue
+		}
+		alias := normalizeURL(u)
+		if alias == target {
+			continue
+		}
+		_, err = db.Exec(`INSERT INTO url_aliases (alias, target, recorded_at) VALUES (?, ?, ?)
+			ON CONFLICT(alias) DO UPDATE SET target = excluded.target, recorded_at = excluded.recorded_at`,
+			alias, target, now)
+		if err != nil {
+			errLog("alias insert failed for %s: %v", alias, err)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"siteconfig"
+)
+
+// redirectServer serves /hop/N, which redirects to /hop/N-1 with the status
+// in ?s= (302 by default), down to /hop/0, a page; /away redirects to the
+// same server under the name localhost, a host of its own
+func redirectServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/away" {
+			_, port, _ := strings.Cut(srv.Listener.Addr().String(), ":")
+			http.Redirect(w, r, "http://localhost:"+port+"/hop/0", http.StatusMovedPermanently)
+			return
+		}
+		n, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/hop/"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if n == 0 {
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, "<title>landed</title>")
+			return
+		}
+		status := http.StatusFound
+		if s := r.URL.Query().Get("s"); s != "" {
+			status, _ = strconv.Atoi(s)
+		}
+		http.Redirect(w, r, fmt.Sprintf("/hop/%d?s=%s", n-1, r.URL.Query().Get("s")), status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func scopedTo(t *testing.T, rawURL string) context.Context {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	site := newDomainConfig(siteconfig.Site{Domain: u.Hostname()})
+	return withRedirectScope(context.Background(), []DomainConfig{site})
+}
+
+func TestRedirectChain(t *testing.T) {
+	useTestClient(t)
+	srv := redirectServer(t)
+
+	res, err := fetchURLWithBody(scopedTo(t, srv.URL), srv.URL+"/hop/3?s=301")
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	want := []redirectHop{
+		{srv.URL + "/hop/3?s=301", 301},
+		{srv.URL + "/hop/2?s=301", 301},
+		{srv.URL + "/hop/1?s=301", 301},
+	}
+	if len(res.Redirects) != len(want) {
+		t.Fatalf("chain = %+v, want %+v", res.Redirects, want)
+	}
+	for i := range want {
+		if res.Redirects[i] != want[i] {
+			t.Errorf("hop %d = %+v, want %+v", i, res.Redirects[i], want[i])
+		}
+	}
+	if res.FinalURL != srv.URL+"/hop/0?s=301" || res.StatusCode != 200 {
+		t.Errorf("landed on %s with %d", res.FinalURL, res.StatusCode)
+	}
+
+	res, err = fetchURLWithBody(scopedTo(t, srv.URL), srv.URL+"/hop/0")
+	if err != nil || len(res.Redirects) != 0 {
+		t.Errorf("direct fetch: chain %+v, %v", res.Redirects, err)
+	}
+}
+
+func TestCheckRedirect(t *testing.T) {
+	useTestClient(t)
+	srv := redirectServer(t)
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		path     string
+		wantErr  error
+		wantHops int
+		lastHop  string // URL of the last hop recorded
+	}{
+		{"at the limit", scopedTo(t, srv.URL), fmt.Sprintf("/hop/%d", MaxRedirects), nil, MaxRedirects, srv.URL + "/hop/1?s="},
+		{"past the limit", scopedTo(t, srv.URL), fmt.Sprintf("/hop/%d", MaxRedirects+1), errTooManyRedirects, MaxRedirects + 1, srv.URL + "/hop/1?s="},
+		{"offsite", scopedTo(t, srv.URL), "/away", errOffsiteRedirect, 1, srv.URL + "/away"},
+		{"unscoped requests go anywhere", context.Background(), "/away", nil, 1, srv.URL + "/away"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := fetchURLWithBody(tt.ctx, srv.URL+tt.path)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if len(res.Redirects) != tt.wantHops || res.Redirects[len(res.Redirects)-1].URL != tt.lastHop {
+				t.Errorf("chain = %+v, want %d hops ending at %s", res.Redirects, tt.wantHops, tt.lastHop)
+			}
+			if tt.wantErr != nil && (res.StatusCode/100 != 3 || retryable(err)) {
+				t.Errorf("refused redirect: status %d, retryable %v", res.StatusCode, retryable(err))
+			}
+		})
+	}
+}
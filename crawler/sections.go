@@ -0,0 +1,202 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// sectionsSchema stores the h1–h3 outline of each page with the text under
+// every heading, for section-level results
+const sectionsSchema = `
+	CREATE TABLE IF NOT EXISTS sections (
+		page_url TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		level INTEGER NOT NULL,
+		heading TEXT NOT NULL,
+		anchor TEXT NOT NULL DEFAULT '',
+		text TEXT NOT NULL DEFAULT '',
+		PRIMARY KEY (page_url, position)
+	) WITHOUT ROWID;`
+
+const (
+	maxSections    = 100  // sections stored per page
+	maxSectionText = 2000 // runes of text stored per section
+	maxHeadingLen  = 300  // runes of heading text
+)
+
+// section is one h1–h3 heading and the text up to the next one
+type section struct {
+	Level   int
+	Heading string
+	Anchor  string // fragment that jumps to the heading, "" when it has none
+	Text    string
+}
+
+// outlineSkipTags are never part of a section
+var outlineSkipTags = map[string]bool{
+	"script": true, "style": true, "noscript": true, "template": true, "svg": true,
+	"nav": true, "footer": true, "aside": true, "form": true, "dialog": true,
+}
+
+// ----------------------
+// Outline extraction
+// ----------------------
+
+// extractSections walks the body in document order and splits it at h1, h2
+// and h3. Text before the first heading belongs to no section; h4–h6 are
+// kept as text of the section they sit in.
+func extractSections(doc *goquery.Document) []section {
+	var secs []section
+	var text strings.Builder
+	flush := func() {
+		if len(secs) == 0 {
+			text.Reset()
+			return
+		}
+		cur := &secs[len(secs)-1]
+		cur.Text = strings.Join(strings.Fields(text.String()), " ")
+		if r := []rune(cur.Text); len(r) > maxSectionText {
+			cur.Text = string(r[:maxSectionText])
+		}
+		text.Reset()
+	}
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			if len(secs) > 0 && text.Len() < maxSectionText*4 {
+				text.WriteString(n.Data)
+			}
+			return
+		case html.ElementNode:
+			if outlineSkipTags[n.Data] {
+				return
+			}
+			// past maxSections headings stay text of the last section
+			if level := headingLevel(n.Data); level > 0 && level <= 3 && len(secs) < maxSections {
+				heading := strings.Join(strings.Fields(blockText(n)), " ")
+				if heading == "" {
+					return
+				}
+				flush()
+				if r := []rune(heading); len(r) > maxHeadingLen {
+					heading = string(r[:maxHeadingLen])
+				}
+				secs = append(secs, section{Level: level, Heading: heading, Anchor: headingAnchor(n)})
+				return
+			}
+			if blockTags[n.Data] {
+				text.WriteByte(' ')
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	for _, n := range doc.Find("body").Nodes {
+		walk(n)
+	}
+	flush()
+	return secs
+}
+
+func headingLevel(tag string) int {
+	if len(tag) == 2 && tag[0] == 'h' && tag[1] >= '1' && tag[1] <= '6' {
+		return int(tag[1] - '0')
+	}
+	return 0
+}
+
+// headingAnchor finds an id that scrolls to heading h: its own id, an
+// id or name on an element inside it (the <a name> / permalink idiom), or
+// the id of a <section> or <article> the heading opens
+func headingAnchor(h *html.Node) string {
+	if id := attr(h, "id"); id != "" {
+		return id
+	}
+	var inner string
+	var find func(*html.Node)
+	find = func(n *html.Node) {
+		for c := n.FirstChild; c != nil && inner == ""; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+			if id := attr(c, "id"); id != "" {
+				inner = id
+			} else if c.Data == "a" && attr(c, "name") != "" {
+				inner = attr(c, "name")
+			} else {
+				find(c)
+			}
+		}
+	}
+	find(h)
+	if inner != "" {
+		return inner
+	}
+	// a named anchor right before the heading
+	for p := h.PrevSibling; p != nil; p = p.PrevSibling {
+		if p.Type == html.TextNode && strings.TrimSpace(p.Data) == "" {
+			continue
+		}
+		if p.Type == html.ElementNode && p.Data == "a" && p.FirstChild == nil {
+			if id := attr(p, "id"); id != "" {
+				return id
+			}
+			return attr(p, "name")
+		}
+		break
+	}
+	if parent := h.Parent; parent != nil && (parent.Data == "section" || parent.Data == "article") {
+		if id := attr(parent, "id"); id != "" && firstHeading(parent) == h {
+			return id
+		}
+	}
+	return ""
+}
+
+// firstHeading returns the first h1–h6 element inside n
+func firstHeading(n *html.Node) *html.Node {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode {
+			continue
+		}
+		if headingLevel(c.Data) > 0 {
+			return c
+		}
+		if h := firstHeading(c); h != nil {
+			return h
+		}
+	}
+	return nil
+}
+
+// ----------------------
+// Section storage
+// ----------------------
+
+// saveSections replaces the stored outline of pageURL with secs
+func saveSections(pageURL string, secs []section) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM sections WHERE page_url = ?`, pageURL); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(`INSERT INTO sections (page_url, position, level, heading, anchor, text) VALUES (?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for i, s := range secs {
+		if _, err := stmt.Exec(pageURL, i, s.Level, s.Heading, s.Anchor, s.Text); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestExtractSections(t *testing.T) {
+	page := `<body>
+		<p>Intro text before any heading.</p>
+		<nav><h2>Menu</h2><a href="/">Home</a></nav>
+		<h1 id="top">Installing  <em>Kali</em></h1>
+		<p>Download the image.</p><script>var x = 1;</script>
+		<h2><a name="usb"></a>Write it to USB</h2>
+		<p>Use dd or Etcher.</p>
+		<h4>On Windows</h4><p>Use Rufus.</p>
+		<a id="boot"></a>
+		<h3>Boot   it</h3>
+		<section id="faq"><h2>FAQ</h2><p>Questions.</p><h3>Secure boot?</h3><p>Disable it.</p></section>
+		<h2><a href="#x"></a></h2>
+		<footer><h3>Contact</h3></footer>
+		<p>Trailing text.</p>
+	</body>`
+	want := []section{
+		{1, "Installing Kali", "top", "Download the image."},
+		{2, "Write it to USB", "usb", "Use dd or Etcher. On Windows Use Rufus."},
+		{3, "Boot it", "boot", ""},
+		{2, "FAQ", "faq", "Questions."},
+		// only the first heading of a section takes its id
+		{3, "Secure boot?", "", "Disable it. Trailing text."},
+	}
+	got := extractSections(mustDoc(t, page))
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got  %+v\nwant %+v", got, want)
+	}
+}
+
+func TestExtractSectionsLimits(t *testing.T) {
+	var b strings.Builder
+	for range maxSections + 5 {
+		b.WriteString("<h2>Part</h2><p>text</p>")
+	}
+	secs := extractSections(mustDoc(t, "<body>"+b.String()+"</body>"))
+	if len(secs) != maxSections {
+		t.Fatalf("%d sections, want the cap of %d", len(secs), maxSections)
+	}
+	// headings past the cap fold into the last section's text
+	if last := secs[len(secs)-1].Text; !strings.HasPrefix(last, "text Part text") {
+		t.Errorf("last section text = %.40q", last)
+	}
+
+	long := strings.Repeat("ক", maxHeadingLen+10)
+	body := strings.Repeat("খ ", maxSectionText*3)
+	secs = extractSections(mustDoc(t, "<body><h2>"+long+"</h2><p>"+body+"</p></body>"))
+	if n := utf8.RuneCountInString(secs[0].Heading); n != maxHeadingLen {
+		t.Errorf("heading is %d runes, want %d", n, maxHeadingLen)
+	}
+	if n := utf8.RuneCountInString(secs[0].Text); n != maxSectionText {
+		t.Errorf("text is %d runes, want %d", n, maxSectionText)
+	}
+}
+
+func TestSaveSections(t *testing.T) {
+	useTestDB(t)
+	const page = "https://docs.test/guide"
+	load := func() []section {
+		t.Helper()
+		rows, err := db.Query(`SELECT level, heading, anchor, text FROM sections WHERE page_url = ? ORDER BY position`, page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var out []section
+		for rows.Next() {
+			var s section
+			if err := rows.Scan(&s.Level, &s.Heading, &s.Anchor, &s.Text); err != nil {
+				t.Fatal(err)
+			}
+			out = append(out, s)
+		}
+		return out
+	}
+
+	first := []section{{1, "One", "one", "a"}, {2, "Two", "", "b"}, {2, "Three", "three", "c"}}
+	if err := saveSections(page, first); err != nil {
+		t.Fatal(err)
+	}
+	if got := load(); !reflect.DeepEqual(got, first) {
+		t.Errorf("stored %+v, want %+v", got, first)
+	}
+	// a recrawl replaces the outline, shorter ones included
+	second := []section{{1, "Only", "", "d"}}
+	if err := saveSections(page, second); err != nil {
+		t.Fatal(err)
+	}
+	if got := load(); !reflect.DeepEqual(got, second) {
+		t.Errorf("after recrawl stored %+v, want %+v", got, second)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html/charset"
+)
+
+// defaultSitemapPriority is the sitemaps.org default for <url> without <priority>
+const defaultSitemapPriority = 0.5
+
+// sitemapURL is one <url> entry of a sitemap
+type sitemapURL struct {
+	Loc      string
+	Priority float64
+}
+
+// sitemapDoc covers both a <urlset> and a <sitemapindex>
+type sitemapDoc struct {
+	URLs []struct {
+		Loc      string `xml:"loc"`
+		Priority string `xml:"priority"`
+	} `xml:"url"`
+	Sitemaps []struct {
+		Loc string `xml:"loc"`
+	} `xml:"sitemap"`
+}
+
+// ----------------------
+// Sitemaps
+// ----------------------
+
+// fetchSitemaps reads the given sitemaps and returns at most MaxSitemapURLs
+// page URLs. Sitemap indexes are followed one level deep; gzipped sitemaps
+// are accepted. Unreadable sitemaps are logged and skipped.
+func fetchSitemaps(ctx context.Context, sitemaps []string) []sitemapURL {
+	var out []sitemapURL
+	seen := map[string]bool{}
+	var read func(loc string, nested bool)
+	read = func(loc string, nested bool) {
+		if seen[loc] || len(out) >= MaxSitemapURLs || ctx.Err() != nil {
+			return
+		}
+		seen[loc] = true
+		doc, err := fetchSitemap(ctx, loc)
+		if err != nil {
+			warn("Sitemap %s: %v", loc, err)
+			return
+		}
+		for _, u := range doc.URLs {
+			if len(out) >= MaxSitemapURLs {
+				break
+			}
+			loc := strings.TrimSpace(u.Loc)
+			if loc == "" {
+				continue
+			}
+			priority := defaultSitemapPriority
+			if p, err := strconv.ParseFloat(strings.TrimSpace(u.Priority), 64); err == nil && p >= 0 && p <= 1 {
+				priority = p
+			}
+			out = append(out, sitemapURL{Loc: loc, Priority: priority})
+		}
+		if nested {
+			return
+		}
+		for _, s := range doc.Sitemaps {
+			read(strings.TrimSpace(s.Loc), true)
+		}
+	}
+	for _, s := range sitemaps {
+		read(s, false)
+	}
+	return out
+}
+
+func fetchSitemap(ctx context.Context, loc string) (*sitemapDoc, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", loc, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", UserAgent)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, &httpStatusError{Code: resp.StatusCode}
+	}
+
+	// sitemap.xml.gz is usually served as a gzip file rather than with
+	// Content-Encoding, so sniff the magic bytes
+	var body io.Reader = bufio.NewReader(io.LimitReader(resp.Body, MaxBodyBytes))
+	if magic, _ := body.(*bufio.Reader).Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		body = io.LimitReader(zr, MaxBodyBytes)
+	}
+
+	var doc sitemapDoc
+	dec := xml.NewDecoder(body)
+	dec.CharsetReader = charset.NewReaderLabel
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("parse: %v", err)
+	}
+	return &doc, nil
+}
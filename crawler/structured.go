@@ -0,0 +1,439 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// structuredData is what a page says about itself in JSON-LD, schema.org
+// microdata and OpenGraph / Twitter Card tags, reduced to the fields used
+// for rich results
+type structuredData struct {
+	Type        string  // schema.org type, e.g. "NewsArticle" or "Product"
+	Image       string  // absolute URL
+	Author      string  // names, comma separated
+	PublishedAt string  // RFC 3339, UTC
+	ModifiedAt  string  // RFC 3339, UTC
+	Price       string  // amount and currency, e.g. "499 INR"
+	Rating      float64 // aggregate rating value, 0 when absent
+}
+
+// boilerplateTypes describe the site or navigation rather than the page
+var boilerplateTypes = map[string]bool{
+	"WebSite": true, "WebPage": true, "BreadcrumbList": true, "ListItem": true,
+	"Organization": true, "SearchAction": true, "SiteNavigationElement": true,
+	"WPHeader": true, "WPFooter": true, "ImageObject": true, "Person": true,
+}
+
+// ----------------------
+// Structured data extraction
+// ----------------------
+
+// extractStructuredData reads every supported source; JSON-LD wins over
+// microdata, which wins over OpenGraph and Twitter tags. Each source only
+// fills fields the stronger ones left empty.
+func extractStructuredData(doc *goquery.Document, pageURL string) structuredData {
+	var sd structuredData
+	sd.merge(jsonLD(doc))
+	sd.merge(microdata(doc))
+	sd.merge(openGraph(doc))
+	if sd.Image != "" {
+		sd.Image = toAbsoluteURL(pageURL, sd.Image)
+	}
+	return sd
+}
+
+func (sd *structuredData) merge(o structuredData) {
+	if sd.Type == "" {
+		sd.Type = o.Type
+	}
+	if sd.Image == "" {
+		sd.Image = o.Image
+	}
+	if sd.Author == "" {
+		sd.Author = o.Author
+	}
+	if sd.PublishedAt == "" {
+		sd.PublishedAt = o.PublishedAt
+	}
+	if sd.ModifiedAt == "" {
+		sd.ModifiedAt = o.ModifiedAt
+	}
+	if sd.Price == "" {
+		sd.Price = o.Price
+	}
+	if sd.Rating == 0 {
+		sd.Rating = o.Rating
+	}
+}
+
+// ----------------------
+// JSON-LD
+// ----------------------
+
+func jsonLD(doc *goquery.Document) structuredData {
+	var nodes []map[string]any
+	doc.Find(`script[type="application/ld+json"]`).Each(func(i int, s *goquery.Selection) {
+		var v any
+		if err := json.Unmarshal([]byte(s.Text()), &v); err != nil {
+			return
+		}
+		nodes = append(nodes, flattenLD(v)...)
+	})
+	node := pickMainNode(nodes)
+	if node == nil {
+		return structuredData{}
+	}
+	sd := structuredData{
+		Type:        ldType(node),
+		Image:       ldURL(node["image"]),
+		Author:      ldNames(node["author"]),
+		PublishedAt: normalizeDate(ldString(node["datePublished"])),
+		ModifiedAt:  normalizeDate(ldString(node["dateModified"])),
+	}
+	if sd.PublishedAt == "" {
+		sd.PublishedAt = normalizeDate(ldString(node["uploadDate"]))
+	}
+	if offers := ldFirst(node["offers"]); offers != nil {
+		amount := ldString(offers["price"])
+		if amount == "" {
+			amount = ldString(offers["lowPrice"])
+		}
+		sd.Price = formatPrice(amount, ldString(offers["priceCurrency"]))
+	}
+	if rating := ldFirst(node["aggregateRating"]); rating != nil {
+		sd.Rating = parseRating(ldString(rating["ratingValue"]))
+	}
+	return sd
+}
+
+// flattenLD returns the objects of a JSON-LD value, unwrapping arrays and @graph
+func flattenLD(v any) []map[string]any {
+	switch t := v.(type) {
+	case []any:
+		var out []map[string]any
+		for _, e := range t {
+			out = append(out, flattenLD(e)...)
+		}
+		return out
+	case map[string]any:
+		if graph, ok := t["@graph"]; ok {
+			return flattenLD(graph)
+		}
+		return []map[string]any{t}
+	}
+	return nil
+}
+
+// pickMainNode prefers the first node that describes page content over site boilerplate
+func pickMainNode(nodes []map[string]any) map[string]any {
+	for _, n := range nodes {
+		if typ := ldType(n); typ != "" && !boilerplateTypes[typ] {
+			return n
+		}
+	}
+	if len(nodes) > 0 {
+		return nodes[0]
+	}
+	return nil
+}
+
+func ldType(n map[string]any) string {
+	switch t := n["@type"].(type) {
+	case string:
+		return t
+	case []any:
+		for _, e := range t {
+			if s, ok := e.(string); ok {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// ldString renders a scalar JSON-LD value as text
+func ldString(v any) string {
+	switch t := v.(type) {
+	case string:
+		return strings.TrimSpace(t)
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case []any:
+		if len(t) > 0 {
+			return ldString(t[0])
+		}
+	}
+	return ""
+}
+
+// ldFirst returns v, or its first element, as an object
+func ldFirst(v any) map[string]any {
+	if list, ok := v.([]any); ok && len(list) > 0 {
+		v = list[0]
+	}
+	m, _ := v.(map[string]any)
+	return m
+}
+
+// ldURL handles "url", {"url": ...} and lists of either
+func ldURL(v any) string {
+	switch t := v.(type) {
+	case string:
+		return strings.TrimSpace(t)
+	case map[string]any:
+		if u := ldString(t["url"]); u != "" {
+			return u
+		}
+		return ldString(t["contentUrl"])
+	case []any:
+		for _, e := range t {
+			if u := ldURL(e); u != "" {
+				return u
+			}
+		}
+	}
+	return ""
+}
+
+// ldNames joins the names of one or more people or organisations
+func ldNames(v any) string {
+	var names []string
+	var add func(any)
+	add = func(v any) {
+		switch t := v.(type) {
+		case string:
+			if s := strings.TrimSpace(t); s != "" {
+				names = append(names, s)
+			}
+		case map[string]any:
+			add(t["name"])
+		case []any:
+			for _, e := range t {
+				add(e)
+			}
+		}
+	}
+	add(v)
+	return strings.Join(names, ", ")
+}
+
+// ----------------------
+// Microdata
+// ----------------------
+
+func microdata(doc *goquery.Document) structuredData {
+	var item *goquery.Selection
+	doc.Find("[itemscope][itemtype]").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		// top-level items only; nested ones are properties of another item
+		if _, isProp := s.Attr("itemprop"); isProp {
+			return true
+		}
+		if typ := microdataType(s); typ != "" && !boilerplateTypes[typ] {
+			item = s
+			return false
+		}
+		return true
+	})
+	if item == nil {
+		return structuredData{}
+	}
+	sd := structuredData{
+		Type:        microdataType(item),
+		Image:       itemValue(itemProp(item, "image")),
+		PublishedAt: normalizeDate(itemValue(itemProp(item, "datePublished"))),
+		ModifiedAt:  normalizeDate(itemValue(itemProp(item, "dateModified"))),
+	}
+	if author := itemProp(item, "author"); author.Length() > 0 {
+		if _, scoped := author.Attr("itemscope"); scoped {
+			sd.Author = itemValue(itemProp(author, "name"))
+		} else {
+			sd.Author = itemValue(author)
+		}
+	}
+	offers := item
+	if o := itemProp(item, "offers"); o.Length() > 0 {
+		offers = o
+	}
+	sd.Price = formatPrice(itemValue(itemProp(offers, "price")), itemValue(itemProp(offers, "priceCurrency")))
+	rating := item
+	if r := itemProp(item, "aggregateRating"); r.Length() > 0 {
+		rating = r
+	}
+	sd.Rating = parseRating(itemValue(itemProp(rating, "ratingValue")))
+	return sd
+}
+
+// microdataType is the last path segment of itemtype, e.g. "Product"
+func microdataType(s *goquery.Selection) string {
+	t := strings.Fields(s.AttrOr("itemtype", ""))
+	if len(t) == 0 {
+		return ""
+	}
+	return t[0][strings.LastIndex(t[0], "/")+1:]
+}
+
+// itemProp finds the first property name that belongs to item itself and
+// not to an item nested inside it
+func itemProp(item *goquery.Selection, name string) *goquery.Selection {
+	var found *goquery.Selection
+	item.Find("[itemprop]").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		if !hasToken(s.AttrOr("itemprop", ""), name) {
+			return true
+		}
+		if owner := s.Parent().Closest("[itemscope]"); owner.Length() == 0 || owner.Get(0) != item.Get(0) {
+			return true
+		}
+		found = s
+		return false
+	})
+	if found == nil {
+		return item.Slice(0, 0)
+	}
+	return found
+}
+
+// itemValue reads a property value the way the microdata spec defines it per element
+func itemValue(s *goquery.Selection) string {
+	if s.Length() == 0 {
+		return ""
+	}
+	if v, ok := s.Attr("content"); ok {
+		return strings.TrimSpace(v)
+	}
+	var attr string
+	switch goquery.NodeName(s) {
+	case "a", "link", "area":
+		attr = "href"
+	case "img", "audio", "video", "source", "embed", "iframe":
+		attr = "src"
+	case "time":
+		attr = "datetime"
+	case "data", "meter":
+		attr = "value"
+	}
+	if attr != "" {
+		if v, ok := s.Attr(attr); ok {
+			return strings.TrimSpace(v)
+		}
+	}
+	return strings.Join(strings.Fields(s.Text()), " ")
+}
+
+func hasToken(list, token string) bool {
+	for _, t := range strings.Fields(list) {
+		if t == token {
+			return true
+		}
+	}
+	return false
+}
+
+// ----------------------
+// OpenGraph & Twitter Cards
+// ----------------------
+
+// ogTypes maps og:type values to the schema.org type they correspond to
+var ogTypes = map[string]string{
+	"article": "Article", "product": "Product", "book": "Book", "profile": "ProfilePage",
+	"video.movie": "Movie", "video.episode": "TVEpisode", "video.other": "VideoObject",
+	"music.song": "MusicRecording", "music.album": "MusicAlbum",
+}
+
+func openGraph(doc *goquery.Document) structuredData {
+	meta := func(names ...string) string {
+		for _, n := range names {
+			sel := doc.Find(fmt.Sprintf(`meta[property=%q], meta[name=%q]`, n, n)).First()
+			if v := strings.TrimSpace(sel.AttrOr("content", "")); v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	sd := structuredData{
+		Type:        ogTypes[strings.ToLower(meta("og:type"))],
+		Image:       meta("og:image", "og:image:url", "og:image:secure_url", "twitter:image", "twitter:image:src"),
+		Author:      meta("article:author", "author", "twitter:creator"),
+		PublishedAt: normalizeDate(meta("article:published_time", "og:published_time")),
+		ModifiedAt:  normalizeDate(meta("article:modified_time", "og:updated_time")),
+		Price:       formatPrice(meta("product:price:amount", "og:price:amount"), meta("product:price:currency", "og:price:currency")),
+	}
+	// article:author is often a profile URL; only keep it when it reads as a name
+	if strings.HasPrefix(sd.Author, "http://") || strings.HasPrefix(sd.Author, "https://") {
+		sd.Author = ""
+	}
+	return sd
+}
+
+// ----------------------
+// Value normalisation
+// ----------------------
+
+// dateLayouts are the date spellings seen in structured data and meta tags
+var dateLayouts = []string{
+	time.RFC3339Nano,
+	time.RFC3339,
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	"2006/01/02",
+	"20060102",
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC850,
+	time.ANSIC,
+	"January 2, 2006",
+	"Jan 2, 2006",
+	"2 January 2006",
+	"2 Jan 2006",
+}
+
+// normalizeDate parses s in any of dateLayouts and returns it as RFC 3339 in
+// UTC, or "" when s is not a recognisable date. Dates without a zone are
+// taken as UTC.
+func normalizeDate(s string) string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return ""
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			if t.Year() < 1990 || t.After(time.Now().Add(48*time.Hour)) {
+				return "" // placeholder or nonsense dates
+			}
+			return t.UTC().Format(time.RFC3339)
+		}
+	}
+	return ""
+}
+
+// formatPrice joins a numeric amount with its currency code
+func formatPrice(amount, currency string) string {
+	amount = strings.TrimSpace(amount)
+	if amount == "" {
+		return ""
+	}
+	if currency = strings.ToUpper(strings.TrimSpace(currency)); currency != "" {
+		return amount + " " + currency
+	}
+	return amount
+}
+
+// parseRating accepts "4.5" and "4,5"
+func parseRating(s string) float64 {
+	v, err := strconv.ParseFloat(strings.Replace(strings.TrimSpace(s), ",", ".", 1), 64)
+	if err != nil || v < 0 {
+		return 0
+	}
+	return v
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func mustDoc(t *testing.T, html string) *goquery.Document {
+	t.Helper()
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestExtractStructuredData(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want structuredData
+	}{
+		{
+			"json-ld article in a graph behind site boilerplate",
+			`<script type="application/ld+json">{"@context":"https://schema.org","@graph":[
+				{"@type":"WebSite","name":"News"},
+				{"@type":["NewsArticle","Article"],"image":[{"url":"/img/lead.jpg"}],
+				 "author":[{"@type":"Person","name":"Asha Rao"},{"name":"Vikram Sen"}],
+				 "datePublished":"2024-03-01T09:30:00+05:30","dateModified":"2024-03-02"}]}</script>`,
+			structuredData{Type: "NewsArticle", Image: "https://news.test/img/lead.jpg", Author: "Asha Rao, Vikram Sen",
+				PublishedAt: "2024-03-01T04:00:00Z", ModifiedAt: "2024-03-02T00:00:00Z"},
+		},
+		{
+			"json-ld product with offers and rating",
+			`<script type="application/ld+json">[{"@type":"BreadcrumbList"},
+				{"@type":"Product","image":"https://cdn.test/p.png",
+				 "offers":[{"lowPrice":1299,"priceCurrency":"inr"}],"aggregateRating":{"ratingValue":"4,5"}}]</script>`,
+			structuredData{Type: "Product", Image: "https://cdn.test/p.png", Price: "1299 INR", Rating: 4.5},
+		},
+		{
+			"video upload date stands in for datePublished",
+			`<script type="application/ld+json">{"@type":"VideoObject","uploadDate":"2023-11-05","author":"Channel"}</script>`,
+			structuredData{Type: "VideoObject", Author: "Channel", PublishedAt: "2023-11-05T00:00:00Z"},
+		},
+		{
+			"broken json-ld is skipped",
+			`<script type="application/ld+json">{"@type": "Article",</script>
+			<meta property="og:type" content="article">`,
+			structuredData{Type: "Article"},
+		},
+		{
+			"microdata product, nested items keep their own properties",
+			`<div itemscope itemtype="https://schema.org/Product">
+				<img itemprop="image" src="/p.jpg">
+				<div itemprop="brand" itemscope itemtype="https://schema.org/Brand"><span itemprop="name">Acme</span></div>
+				<div itemprop="offers" itemscope itemtype="https://schema.org/Offer">
+					<meta itemprop="price" content="499"><meta itemprop="priceCurrency" content="INR">
+				</div>
+				<div itemprop="aggregateRating" itemscope itemtype="https://schema.org/AggregateRating">
+					<span itemprop="ratingValue">4.2</span>
+				</div>
+			</div>`,
+			structuredData{Type: "Product", Image: "https://news.test/p.jpg", Price: "499 INR", Rating: 4.2},
+		},
+		{
+			"microdata article with a scoped author and a time",
+			`<article itemscope itemtype="http://schema.org/BlogPosting">
+				<span itemprop="author" itemscope itemtype="http://schema.org/Person"><span itemprop="name"> Meera  Iyer </span></span>
+				<time itemprop="datePublished" datetime="2022-07-14">14 July</time>
+			</article>`,
+			structuredData{Type: "BlogPosting", Author: "Meera Iyer", PublishedAt: "2022-07-14T00:00:00Z"},
+		},
+		{
+			"opengraph and twitter tags",
+			`<meta property="og:type" content="product">
+			<meta name="twitter:image" content="//cdn.test/t.png">
+			<meta property="article:author" content="https://facebook.test/someone">
+			<meta property="product:price:amount" content="20"><meta property="product:price:currency" content="usd">`,
+			structuredData{Type: "Product", Image: "https://cdn.test/t.png", Price: "20 USD"},
+		},
+		{
+			"json-ld wins, weaker sources fill the gaps",
+			`<script type="application/ld+json">{"@type":"Article","author":{"name":"LD Author"}}</script>
+			<div itemscope itemtype="https://schema.org/Article"><meta itemprop="author" content="Microdata Author">
+				<meta itemprop="datePublished" content="2021-01-01"></div>
+			<meta property="og:image" content="/og.png"><meta property="article:published_time" content="2020-01-01">`,
+			structuredData{Type: "Article", Image: "https://news.test/og.png", Author: "LD Author", PublishedAt: "2021-01-01T00:00:00Z"},
+		},
+		{"nothing", `<p>plain page</p>`, structuredData{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := extractStructuredData(mustDoc(t, tt.html), "https://news.test/story/1")
+			if got != tt.want {
+				t.Errorf("got  %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatPriceAndRating(t *testing.T) {
+	for _, tt := range []struct{ amount, currency, want string }{
+		{"499", "inr", "499 INR"},
+		{" 20.50 ", "", "20.50"},
+		{"", "USD", ""},
+	} {
+		if got := formatPrice(tt.amount, tt.currency); got != tt.want {
+			t.Errorf("formatPrice(%q, %q) = %q, want %q", tt.amount, tt.currency, got, tt.want)
+		}
+	}
+	for _, tt := range []struct {
+		in   string
+		want float64
+	}{
+		{"4.5", 4.5}, {"4,5", 4.5}, {" 3 ", 3}, {"-1", 0}, {"five", 0}, {"", 0},
+	} {
+		if got := parseRating(tt.in); got != tt.want {
+			t.Errorf("parseRating(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// sessionParams carry per-visitor state and are stripped from discovered URLs
+var sessionParams = map[string]bool{
+	"sid": true, "sessionid": true, "session_id": true, "jsessionid": true,
+	"phpsessid": true, "aspsessionid": true, "cfid": true, "cftoken": true,
+	"utm_source": true, "utm_medium": true, "utm_campaign": true, "utm_term": true,
+	"utm_content": true, "fbclid": true, "gclid": true,
+}
+
+// facetParams reorder or filter a listing rather than naming new content
+var facetParams = map[string]bool{
+	"sort": true, "sortby": true, "sort_by": true, "order": true, "orderby": true, "dir": true,
+	"filter": true, "filters": true, "facet": true, "view": true, "layout": true,
+	"limit": true, "per_page": true, "pagesize": true, "page_size": true,
+	"color": true, "colour": true, "size": true, "brand": true, "price": true, "rating": true,
+	"min_price": true, "max_price": true,
+}
+
+// calendarParams page through dates, which never run out
+var calendarParams = map[string]bool{
+	"year": true, "month": true, "day": true, "date": true, "week": true, "cal": true, "calendar": true,
+}
+
+var (
+	numericSegment = regexp.MustCompile(`^\d+$`)
+	idSegment      = regexp.MustCompile(`^(?i)[0-9a-f]{12,}$|^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+	jsessionInPath = regexp.MustCompile(`(?i);jsessionid=[^/?]*`)
+)
+
+// ----------------------
+// Crawler trap detection
+// ----------------------
+
+// trapDetector flags URLs that look like an infinite URL space: very deep
+// paths, looping path segments, endless query variations of one path and
+// calendar / faceted navigation. It also budgets fetches per path pattern so
+// a single template cannot consume the whole per-domain page budget. Each
+// pattern is reported once per crawl, however many of its URLs are refused.
+type trapDetector struct {
+	mu       sync.Mutex
+	fetched  map[string]int             // path pattern -> URLs fetched
+	variants map[string]map[string]bool // path pattern -> distinct query strings
+	reported map[string]bool            // path patterns already refused once
+}
+
+func newTrapDetector() *trapDetector {
+	return &trapDetector{
+		fetched:  map[string]int{},
+		variants: map[string]map[string]bool{},
+		reported: map[string]bool{},
+	}
+}
+
+// check returns why u, a discovered link, is a trap ("" if it is not), and
+// first when this is the first refusal for its pattern, the one to log.
+// A pattern whose budget is used up refuses further links, but only fetch
+// charges the budget.
+func (t *trapDetector) check(u *url.URL) (reason string, first bool) {
+	pattern := trapPattern(u)
+	reason = shapeTrap(u)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if query := u.Query(); reason == "" && len(query) > 0 {
+		seen := t.variants[pattern]
+		if seen == nil {
+			seen = map[string]bool{}
+			t.variants[pattern] = seen
+		}
+		key := canonicalQuery(query)
+		if !seen[key] && len(seen) >= TrapMaxQueryVariants {
+			reason = fmt.Sprintf("more than %d query variants of %s", TrapMaxQueryVariants, pattern)
+		} else {
+			seen[key] = true
+		}
+	}
+	if reason == "" && t.fetched[pattern] >= TrapPatternBudget {
+		reason = budgetReason(pattern)
+	}
+	return reason, t.refuse(pattern, reason)
+}
+
+// fetch charges u to its pattern budget just before it is fetched; once the
+// budget is used up it returns why u is skipped, with first as in check
+func (t *trapDetector) fetch(u *url.URL) (reason string, first bool) {
+	pattern := trapPattern(u)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.fetched[pattern] >= TrapPatternBudget {
+		reason = budgetReason(pattern)
+		return reason, t.refuse(pattern, reason)
+	}
+	t.fetched[pattern]++
+	return "", false
+}
+
+// refuse reports whether reason is the first refusal of pattern; t.mu is held
+func (t *trapDetector) refuse(pattern, reason string) bool {
+	if reason == "" || t.reported[pattern] {
+		return false
+	}
+	t.reported[pattern] = true
+	return true
+}
+
+func budgetReason(pattern string) string {
+	return fmt.Sprintf("pattern budget of %d used up for %s", TrapPatternBudget, pattern)
+}
+
+// shapeTrap checks u on its own: path depth, looping segments and the
+// number of sort/filter parameters
+func shapeTrap(u *url.URL) string {
+	segments := pathSegments(u.Path)
+	if len(segments) > TrapMaxPathDepth {
+		return fmt.Sprintf("path depth %d > %d", len(segments), TrapMaxPathDepth)
+	}
+	counts := map[string]int{}
+	for i, seg := range segments {
+		if numericSegment.MatchString(seg) {
+			continue // dates like /2024/01/01/ repeat numbers legitimately; pathPattern budgets them as {n}
+		}
+		if i > 0 && seg == segments[i-1] {
+			return fmt.Sprintf("repeated path segment %q", seg)
+		}
+		counts[seg]++
+		if counts[seg] > TrapMaxSegmentRepeats {
+			return fmt.Sprintf("path segment %q repeats %d times", seg, counts[seg])
+		}
+	}
+	facets := 0
+	for k := range u.Query() {
+		if facetParams[strings.ToLower(k)] {
+			facets++
+		}
+	}
+	if facets > TrapMaxFacetParams {
+		return fmt.Sprintf("%d sort/filter parameters", facets)
+	}
+	return ""
+}
+
+// trapPattern is the pattern u is budgeted and reported under; calendar
+// navigation gets a pattern of its own
+func trapPattern(u *url.URL) string {
+	pattern := pathPattern(u.Hostname(), pathSegments(u.Path))
+	for k := range u.Query() {
+		if calendarParams[strings.ToLower(k)] {
+			return pattern + "?calendar"
+		}
+	}
+	return pattern
+}
+
+// reportTrap logs and records the first URL refused for a pattern; the
+// rest of that pattern is skipped quietly
+func reportTrap(u, domain, category, reason string) {
+	warn("[Trap] %s: %s (skipping this pattern for the rest of the crawl)", reason, u)
+	recordFetch(fetchOutcome{URL: u, Domain: domain, Category: category, ErrorClass: classTrap, Error: reason})
+}
+
+// pathPattern generalises ids and numbers so /post/123 and /post/456 share a pattern
+func pathPattern(host string, segments []string) string {
+	parts := make([]string, len(segments))
+	for i, seg := range segments {
+		switch {
+		case numericSegment.MatchString(seg):
+			parts[i] = "{n}"
+		case idSegment.MatchString(seg):
+			parts[i] = "{id}"
+		default:
+			parts[i] = seg
+		}
+	}
+	return host + "/" + strings.Join(parts, "/")
+}
+
+func pathSegments(p string) []string {
+	var out []string
+	for _, seg := range strings.Split(p, "/") {
+		if seg != "" {
+			out = append(out, strings.ToLower(seg))
+		}
+	}
+	return out
+}
+
+// canonicalQuery orders parameters so ?a=1&b=2 and ?b=2&a=1 count once
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var sb strings.Builder
+	for _, k := range keys {
+		vals := append([]string(nil), q[k]...)
+		sort.Strings(vals)
+		sb.WriteString(k + "=" + strings.Join(vals, ",") + "&")
+	}
+	return sb.String()
+}
+
+// stripSessionParams removes session ids and tracking parameters, which
+// otherwise make every visit look like a new URL
+func stripSessionParams(u *url.URL) {
+	if jsessionInPath.MatchString(u.Path) {
+		u.Path = jsessionInPath.ReplaceAllString(u.Path, "")
+		u.RawPath = ""
+	}
+	if u.RawQuery == "" {
+		return
+	}
+	q := u.Query()
+	changed := false
+	for k := range q {
+		if sessionParams[strings.ToLower(k)] {
+			q.Del(k)
+			changed = true
+		}
+	}
+	if changed {
+		u.RawQuery = q.Encode()
+	}
+}
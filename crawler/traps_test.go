@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func mustParse(t *testing.T, raw string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestTrapCheck(t *testing.T) {
+	tests := []struct {
+		url  string
+		trap string // substring of the reason, "" when not a trap
+	}{
+		{"https://news.test/2024/01/01/slug", ""},
+		{"https://news.test/2023/03/03/budget-session", ""},
+		{"https://news.test/2020/02/20/02/20", ""},
+		{"https://docs.test/guide/install/linux", ""},
+		{"https://docs.test/a/b/c/d/e/f/g/h", ""},
+		{"https://docs.test/a/b/c/d/e/f/g/h/i", "path depth 9"},
+		{"https://docs.test/en/en/guide", `repeated path segment "en"`},
+		{"https://docs.test/Tag/tag/guide", `repeated path segment "tag"`},
+		{"https://docs.test/x/a/x/b/x", `"x" repeats 3 times`},
+		{"https://shop.test/list?sort=price&filter=red", ""},
+		{"https://shop.test/list?sort=price&filter=red&view=grid", "3 sort/filter parameters"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			got, _ := newTrapDetector().check(mustParse(t, tt.url))
+			if tt.trap == "" && got != "" {
+				t.Errorf("flagged as trap: %s", got)
+			}
+			if tt.trap != "" && !strings.Contains(got, tt.trap) {
+				t.Errorf("reason = %q, want one containing %q", got, tt.trap)
+			}
+		})
+	}
+}
+
+func TestTrapPatternBudget(t *testing.T) {
+	d := newTrapDetector()
+	product := func(i int) *url.URL { return mustParse(t, fmt.Sprintf("https://shop.test/product/%d", i)) }
+
+	// queued links do not spend the budget
+	for i := 1; i <= 3*TrapPatternBudget; i++ {
+		if why, _ := d.check(product(i)); why != "" {
+			t.Fatalf("link to product %d flagged: %s", i, why)
+		}
+	}
+	for i := 1; i <= TrapPatternBudget; i++ {
+		if why, _ := d.fetch(product(i)); why != "" {
+			t.Fatalf("fetch of product %d refused: %s", i, why)
+		}
+	}
+	why, first := d.fetch(product(999))
+	if !strings.Contains(why, "budget") || !first {
+		t.Errorf("fetch past the pattern budget: reason = %q, first = %v", why, first)
+	}
+	// the pattern is reported once, whether refused at fetch or as a link
+	if why, first := d.fetch(product(1000)); why == "" || first {
+		t.Errorf("second fetch past the budget: reason = %q, first = %v", why, first)
+	}
+	if why, first := d.check(product(1001)); !strings.Contains(why, "budget") || first {
+		t.Errorf("link past the budget: reason = %q, first = %v", why, first)
+	}
+
+	// ids share a pattern the same way numbers do, but not with numbers
+	if why, _ := d.fetch(mustParse(t, "https://shop.test/product/0123456789abcdef")); why != "" {
+		t.Errorf("first id URL refused: %s", why)
+	}
+	// other hosts have their own budgets
+	if why, _ := d.fetch(mustParse(t, "https://other.test/product/1")); why != "" {
+		t.Errorf("other host refused: %s", why)
+	}
+}
+
+func TestTrapQueryVariants(t *testing.T) {
+	d := newTrapDetector()
+	for i := 1; i <= TrapMaxQueryVariants; i++ {
+		if why, _ := d.check(mustParse(t, fmt.Sprintf("https://forum.test/t/topic?page=%d", i))); why != "" {
+			t.Fatalf("variant %d flagged: %s", i, why)
+		}
+	}
+	why, first := d.check(mustParse(t, "https://forum.test/t/topic?page=99"))
+	if !strings.Contains(why, "query variants") || !first {
+		t.Errorf("variant past the limit: reason = %q, first = %v", why, first)
+	}
+	if why, first := d.check(mustParse(t, "https://forum.test/t/topic?page=100")); why == "" || first {
+		t.Errorf("next variant past the limit: reason = %q, first = %v", why, first)
+	}
+	// calendar navigation is budgeted apart from the plain pattern
+	if why, _ := d.check(mustParse(t, "https://forum.test/t/topic?month=5")); why != "" {
+		t.Errorf("calendar variant flagged: %s", why)
+	}
+}
+
+func TestStripSessionParams(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"https://a.test/p?id=7&utm_source=x&SID=abc", "https://a.test/p?id=7"},
+		{"https://a.test/p;jsessionid=ABC123?x=1", "https://a.test/p?x=1"},
+		{"https://a.test/p?q=go", "https://a.test/p?q=go"},
+	}
+	for _, tt := range tests {
+		u := mustParse(t, tt.in)
+		stripSessionParams(u)
+		if u.String() != tt.want {
+			t.Errorf("stripSessionParams(%s) = %s, want %s", tt.in, u, tt.want)
+		}
+	}
+}
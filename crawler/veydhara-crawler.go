@@ -1,9 +1,9 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -20,8 +20,11 @@ import (
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/fatih/color"
-	"github.com/temoto/robotstxt"
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/temoto/robotstxt"
+
+	"sanitize"
+	"siteconfig"
 )
 
 // ----------------------
@@ -29,13 +32,28 @@ import (
 // ----------------------
 var (
 	// tweak these as needed
-	MaxPagesPerDomain = 50             // maximum pages to crawl per domain
-	RequestTimeout    = 8 * time.Second
-	PolitenessDelay   = 800 * time.Millisecond // delay between requests to same domain
-	MaxWorkersPerDomain = 4                  // concurrent fetchers per domain
-	MaxGlobalWorkers    = 16                 // global concurrency cap across domains
-	MaxRetries          = 2                  // retry on transient HTTP errors
-	UserAgent           = "CategorySearchBot/1.0"
+	MaxPagesPerDomain   = 50 // maximum pages to crawl per domain
+	RequestTimeout      = 8 * time.Second
+	PolitenessDelay     = 800 * time.Millisecond // delay between requests to same domain
+	MaxWorkersPerDomain = 4                      // concurrent fetchers per domain
+	MaxGlobalWorkers    = 16                     // global concurrency cap across domains
+	MaxRetries          = 2                      // retry on transient HTTP errors
+	MaxRedirects        = 5                      // redirect hops followed per fetch
+	SimHashDistance     = 3                      // fingerprint bits two near-duplicate pages may differ in
+	MaxFrontierSize     = 10000                  // queued URLs per domain; the lowest-scored are dropped beyond this
+	MaxSitemapURLs      = 1000                   // URLs taken from a domain's sitemaps
+	FrontierScorer      = defaultURLScorer       // crawl order within a domain (see frontier.go)
+
+	// crawler trap heuristics (see traps.go)
+	TrapMaxPathDepth      = 8              // path segments before a URL counts as a trap
+	TrapMaxSegmentRepeats = 2              // times one segment may appear in a path
+	TrapMaxQueryVariants  = 10             // distinct query strings allowed per path pattern
+	TrapMaxFacetParams    = 2              // sort/filter parameters allowed in one URL
+	TrapPatternBudget     = 10             // URLs fetched per path pattern (e.g. /product/{n})
+	MaxBodyBytes          = int64(5 << 20) // decoded page size cap
+	TruncateOversized     = true           // truncate pages over MaxBodyBytes instead of rejecting them
+	MaxCompressionRatio   = int64(100)     // decoded:wire ratio treated as a decompression bomb
+	UserAgent             = "CategorySearchBot/1.0"
 )
 
 // ----------------------
@@ -60,6 +78,12 @@ type Page struct {
 	Title    string
 	Snippet  string
 	Category string
+	Content  string // main text with boilerplate removed (see readability.go)
+	HTML     string // markup of the same main content; savePage stores it sanitised
+	SimHash  uint64 // content fingerprint for near-duplicate detection
+	Rich     structuredData
+	Domain   string // facet host, see siteconfig.PageDomain
+	Lang     string // primary language subtag, "" when unknown
 }
 
 // ----------------------
@@ -69,6 +93,23 @@ func main() {
 	setupPathsAndLogging()
 	defer db.Close()
 
+	// sub-commands, also accepted after "crawl" ("crawl report"); no
+	// argument (or "crawl" alone) runs the crawl
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "crawl" {
+		args = args[1:]
+	}
+	if len(args) > 0 {
+		cmd, ok := commands[args[0]]
+		if !ok {
+			logFatal("Unknown command %q", args[0])
+		}
+		if err := cmd(args[1:]); err != nil {
+			logFatal("%s failed: %v", args[0], err)
+		}
+		return
+	}
+
 	// graceful shutdown context
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -80,19 +121,43 @@ func main() {
 		logFatal("Failed to load categories: %v", err)
 	}
 
+	// pages stored before pages.domain existed
+	if err := backfillDomains(); err != nil {
+		errLog("Failed to backfill page domains: %v", err)
+	}
+
+	// URLs re-queued through the admin API
+	requeues, err := pendingRequeues(categories)
+	if err != nil {
+		errLog("Failed to read crawl_queue: %v", err)
+	}
+
 	// create jobs
 	type job struct {
 		Category string
-		Domain   string
+		Site     DomainConfig
+		Scope    []DomainConfig // every site of the category; redirects may not leave it
+		Requeued []queuedURL
 	}
 	var jobs []job
-	for cat, domains := range categories {
-		for _, d := range domains {
-			dom := strings.TrimSpace(d)
-			if dom == "" {
-				continue
+	for cat, sites := range categories {
+		for _, site := range sites {
+			queued := requeues[cat][site.Domain]
+			for _, q := range queued {
+				site.requeued = append(site.requeued, q.URL)
 			}
-			jobs = append(jobs, job{Category: cat, Domain: dom})
+			if recrawl := site.RecrawlAfter(); recrawl > 0 {
+				if last := lastFetch(site.Domain); time.Since(last) < recrawl {
+					if len(queued) == 0 {
+						info("Skipping %s: crawled %s ago (recrawl every %s)", site.Domain, time.Since(last).Round(time.Minute), recrawl)
+						continue
+					}
+					// not due: fetch only the re-queued URLs, which go first
+					info("Re-crawling %d queued URL(s) of %s", len(queued), site.Domain)
+					site.MaxPages = len(queued)
+				}
+			}
+			jobs = append(jobs, job{Category: cat, Site: site, Scope: sites, Requeued: queued})
 		}
 	}
 
@@ -117,8 +182,12 @@ func main() {
 			defer domainCancel()
 
 			// run domain crawl
-			if err := crawlDomain(domainCtx, j.Category, j.Domain); err != nil {
-				errLog("Domain crawl failed: %s -> %v", j.Domain, err)
+			started := time.Now()
+			if err := crawlDomain(domainCtx, j.Category, j.Site, j.Scope); err != nil {
+				errLog("Domain crawl failed: %s -> %v", j.Site.Domain, err)
+			}
+			if ctx.Err() == nil {
+				finishRequeues(j.Requeued, started)
 			}
 		}(j)
 	}
@@ -126,6 +195,22 @@ func main() {
 	// wait for all jobs or shutdown
 	wg.Wait()
 	info("All crawling jobs complete")
+
+	// refresh inbound anchor text and near-duplicate clusters
+	if err := runAnchorIndex(nil); err != nil {
+		errLog("Anchor indexing failed: %v", err)
+	}
+	if err := runDedup(nil); err != nil {
+		errLog("Near-duplicate clustering failed: %v", err)
+	}
+}
+
+// commands are the `veydhara-crawler <name>` maintenance tasks
+var commands = map[string]func(args []string) error{
+	"report":   runReport,
+	"pagerank": runPageRank,
+	"anchors":  runAnchorIndex,
+	"dedup":    runDedup,
 }
 
 // ----------------------
@@ -165,7 +250,8 @@ func setupPathsAndLogging() {
 
 	// http client
 	httpClient = &http.Client{
-		Timeout: RequestTimeout,
+		Timeout:       RequestTimeout,
+		CheckRedirect: checkRedirect,
 	}
 
 	printBanner()
@@ -175,6 +261,27 @@ func setupPathsAndLogging() {
 	info("Debug: %v", debugMode)
 }
 
+// pageColumns were added to pages after the original schema; initDB adds
+// any that an older database is missing
+var pageColumns = []struct{ Name, Decl string }{
+	{"crawled_at", "TEXT"},
+	{"pagerank", "REAL DEFAULT 0"},
+	{"anchor_text", "TEXT DEFAULT ''"},
+	{"simhash", "INTEGER"},
+	{"dup_group", "INTEGER"},
+	{"schema_type", "TEXT DEFAULT ''"},
+	{"image", "TEXT DEFAULT ''"},
+	{"author", "TEXT DEFAULT ''"},
+	{"published_at", "TEXT"},
+	{"price", "TEXT DEFAULT ''"},
+	{"rating", "REAL"},
+	{"modified_at", "TEXT"},
+	{"content", "TEXT DEFAULT ''"},
+	{"content_html", "TEXT DEFAULT ''"},
+	{"domain", "TEXT DEFAULT ''"},
+	{"lang", "TEXT DEFAULT ''"},
+}
+
 // initDB opens sqlite and creates table if needed
 func initDB(path string) *sql.DB {
 	db, err := sql.Open("sqlite3", path+"?_busy_timeout=5000")
@@ -192,9 +299,61 @@ func initDB(path string) *sql.DB {
 	if _, err := db.Exec(createStmt); err != nil {
 		log.Fatalf("failed to create pages table: %v", err)
 	}
+	for _, c := range pageColumns {
+		if err := ensureColumn(db, "pages", c.Name, c.Decl); err != nil {
+			log.Fatalf("failed to add pages.%s: %v", c.Name, err)
+		}
+	}
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_pages_url ON pages(url)`); err != nil {
+		log.Fatalf("failed to index pages: %v", err)
+	}
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_pages_domain ON pages(domain)`); err != nil {
+		log.Fatalf("failed to index pages: %v", err)
+	}
+	if _, err := db.Exec(fetchLogSchema); err != nil {
+		log.Fatalf("failed to create fetch_log table: %v", err)
+	}
+	if _, err := db.Exec(aliasSchema); err != nil {
+		log.Fatalf("failed to create url_aliases table: %v", err)
+	}
+	if _, err := db.Exec(linksSchema); err != nil {
+		log.Fatalf("failed to create links table: %v", err)
+	}
+	if _, err := db.Exec(sectionsSchema); err != nil {
+		log.Fatalf("failed to create sections table: %v", err)
+	}
+	if _, err := db.Exec(crawlQueueSchema); err != nil {
+		log.Fatalf("failed to create crawl_queue table: %v", err)
+	}
 	return db
 }
 
+// ensureColumn adds column to table unless it already exists
+func ensureColumn(db *sql.DB, table, column, decl string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, typ string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, decl))
+	return err
+}
+
 func printBanner() {
 	color := colorNew(colorMagenta, true)
 	color("\n───────────────────────────────────────────────")
@@ -212,30 +371,17 @@ func monitorSignals(cancel context.CancelFunc) {
 	cancel()
 }
 
-// ----------------------
-// Categories loader
-// ----------------------
-func loadCategories(path string) (map[string][]string, error) {
-	b, err := os.ReadFile(path)
-	if err != nil {
-		return nil, err
-	}
-	var categories map[string][]string
-	if err := json.Unmarshal(b, &categories); err != nil {
-		return nil, err
-	}
-	return categories, nil
-}
-
 // ----------------------
 // Domain crawler
 // ----------------------
-func crawlDomain(ctx context.Context, category, domain string) error {
+
+func crawlDomain(ctx context.Context, category string, site DomainConfig, scope []DomainConfig) error {
+	domain := site.Domain
 	info("Starting domain crawl: %s (category=%s)", domain, category)
 
 	// prepare robots.txt rules
 	allowAll := true
-	robotsGroup, err := fetchRobotsForDomain(domain)
+	robotsGroup, sitemaps, err := fetchRobotsForDomain(domain)
 	if err == nil && robotsGroup != nil {
 		allowAll = false
 	} else if err != nil {
@@ -243,224 +389,368 @@ func crawlDomain(ctx context.Context, category, domain string) error {
 		warn("Failed to fetch robots for %s: %v — continuing with polite defaults", domain, err)
 	}
 
-	// visited set and queue
+	// visited set and priority frontier
 	visited := make(map[string]struct{})
 	visitedMu := sync.Mutex{}
-	toCrawl := make(chan string, 1024)
-	defer close(toCrawl)
+	queue := newFrontier(FrontierScorer, MaxFrontierSize)
+	traps := newTrapDetector()
 
 	// per-domain rate limiter
-	ticker := time.NewTicker(PolitenessDelay)
+	ticker := time.NewTicker(site.delay)
 	defer ticker.Stop()
 
-	// seed URL(s): try https then http fallback
-	seeds := []string{"https://" + domain, "http://" + domain}
-	var seedURL string
-	for _, s := range seeds {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
+	// seed URL(s): configured seeds, else try https then http fallback; a
+	// domain neither answers on has both failures written to fetch_log
+	seedURLs := site.Seeds
+	if len(seedURLs) == 0 {
+		var failures []fetchOutcome
+		for _, s := range []string{"https://" + domain, "http://" + domain} {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+			}
+			start := time.Now()
+			status, err := probeSeed(s)
+			if err == nil {
+				seedURLs = []string{s}
+				break
+			}
+			failures = append(failures, fetchOutcome{
+				URL:        s,
+				Domain:     domain,
+				Category:   category,
+				StatusCode: status,
+				ErrorClass: classifyFetchError(err),
+				Error:      err.Error(),
+				Latency:    time.Since(start),
+				Attempts:   1,
+			})
 		}
-		if ok := testURLReachable(s); ok {
-			seedURL = s
-			break
+		if len(seedURLs) == 0 {
+			for _, o := range failures {
+				recordFetch(o)
+			}
+			return fmt.Errorf("seed not reachable for domain %s: %s", domain, failures[len(failures)-1].Error)
 		}
 	}
-	if seedURL == "" {
-		return fmt.Errorf("seed not reachable for domain %s", domain)
-	}
 
-	enqueue := func(u string) {
+	// enqueue adds an unseen URL to the frontier and reports whether it was
+	// queued; a URL seen before only gains an inbound link if still waiting
+	enqueue := func(u *url.URL, depth int, priority float64) bool {
+		abs := normalizeURL(u)
 		visitedMu.Lock()
 		defer visitedMu.Unlock()
-		if _, seen := visited[u]; seen {
-			return
+		if _, seen := visited[abs]; seen {
+			queue.link(abs)
+			return false
 		}
-		visited[u] = struct{}{}
-		select {
-		case toCrawl <- u:
-		default:
-			// if channel full, drop politely
-			warn("queue full, dropping URL: %s", u)
+		visited[abs] = struct{}{}
+		if reason, first := traps.check(u); reason != "" {
+			if first {
+				reportTrap(abs, domain, category, reason)
+			}
+			return false
+		}
+		if !queue.push(frontierEntry{URL: abs, Depth: depth, Priority: priority}) {
+			if debugMode {
+				info("frontier full, dropping URL: %s", abs)
+			}
+			return false
 		}
+		return true
 	}
 
-	enqueue(seedURL)
+	// re-queued URLs jump the queue: depth 0 and top sitemap priority
+	// outscore every seed and sitemap entry. An operator asked for them, so
+	// they bypass the trap heuristics and pattern budgets in enqueue. They go
+	// in before the seeds so a re-queued seed keeps its priority.
+	requeued := map[string]bool{}
+	for _, r := range site.requeued {
+		if u, err := url.Parse(r); err == nil {
+			abs := normalizeURL(u)
+			requeued[abs] = true
+			visitedMu.Lock()
+			visited[abs] = struct{}{}
+			visitedMu.Unlock()
+			queue.push(frontierEntry{URL: abs, Priority: 1})
+		}
+	}
 
-	// worker pool for domain
+	for _, s := range seedURLs {
+		if u, err := url.Parse(s); err == nil {
+			seed := normalizeURL(u)
+			visitedMu.Lock()
+			visited[seed] = struct{}{}
+			visitedMu.Unlock()
+			queue.push(frontierEntry{URL: seed, Priority: -1})
+		}
+	}
+	// sitemap URLs enter one hop from the seeds, ordered by their <priority>
+	if len(sitemaps) == 0 {
+		if u, err := url.Parse(seedURLs[0]); err == nil {
+			sitemaps = []string{u.Scheme + "://" + u.Host + "/sitemap.xml"}
+		}
+	}
+	listed := 0
+	for _, sm := range fetchSitemaps(ctx, sitemaps) {
+		u, err := url.Parse(sm.Loc)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			continue
+		}
+		if !site.AllowsHost(u.Hostname()) {
+			continue
+		}
+		normalizeURL(u)
+		if site.AllowsPath(u) && enqueue(u, 1, sm.Priority) {
+			listed++
+		}
+	}
+	if listed > 0 {
+		info("Sitemaps for %s: %d URLs queued", domain, listed)
+	}
+
+	// claim marks a fetched page's final URL as handled; it returns false
+	// when another fetch (usually via a different redirect) got there first
+	processed := make(map[string]struct{})
+	claim := func(finalURL string) bool {
+		visitedMu.Lock()
+		defer visitedMu.Unlock()
+		if _, done := processed[finalURL]; done {
+			return false
+		}
+		processed[finalURL] = struct{}{}
+		visited[finalURL] = struct{}{}
+		return true
+	}
+	fetchCtx := withRedirectScope(ctx, scope)
+
+	// workers signal wake when they finish so the loop can launch the next URL
 	workerWG := sync.WaitGroup{}
-	sem := make(chan struct{}, MaxWorkersPerDomain)
+	wake := make(chan struct{}, 1)
 
 	crawledCount := 0
+	inFlight := 0
 	crawledMu := sync.Mutex{}
 
-	// shutdown watcher
-	stop := make(chan struct{})
-	go func() {
-		<-ctx.Done()
-		close(stop)
-	}()
-
+crawl:
 	for {
-		// stop conditions
+		// stop conditions: page budget used, or nothing queued and nothing
+		// running that could still discover links
 		crawledMu.Lock()
-		if crawledCount >= MaxPagesPerDomain {
-			crawledMu.Unlock()
-			break
-		}
+		crawled, running := crawledCount, inFlight
 		crawledMu.Unlock()
-
-		select {
-		case <-ctx.Done():
-			info("context cancelled for domain %s", domain)
+		if crawled >= site.MaxPages || ctx.Err() != nil {
 			break
-		case u, ok := <-toCrawl:
-			if !ok {
+		}
+		if running < MaxWorkersPerDomain && crawled+running < site.MaxPages {
+			item, ok := queue.pop()
+			if !ok && running == 0 {
 				break
 			}
-
-			// Respect robots if available
-			if !allowAll && robotsGroup != nil {
+			if ok {
+				u := item.URL
 				parsed, perr := url.Parse(u)
-				if perr == nil {
-					if !robotsGroup.Test(parsed.RequestURI()) {
-						info("Robots disallow: %s", u)
-						continue
-					}
+				if perr != nil {
+					continue
 				}
-			}
 
-			// check limit again
-			crawledMu.Lock()
-			if crawledCount >= MaxPagesPerDomain {
-				crawledMu.Unlock()
-				break
-			}
-			crawledMu.Unlock()
-
-			// worker semaphore & launch
-			sem <- struct{}{}
-			workerWG.Add(1)
-			go func(pageURL string) {
-				defer workerWG.Done()
-				defer func() { <-sem }()
-
-				// wait politeness ticker
-				select {
-				case <-ticker.C:
-				case <-stop:
-					return
+				// Respect robots if available
+				if !allowAll && robotsGroup != nil && !robotsGroup.Test(parsed.RequestURI()) {
+					info("Robots disallow: %s", u)
+					recordFetch(fetchOutcome{URL: u, Domain: domain, Category: category, ErrorClass: classRobots})
+					continue
 				}
-
-				// fetch & process with retries
-				var respBody io.ReadCloser
-				var finalURL string
-				var err error
-				for attempt := 0; attempt <= MaxRetries; attempt++ {
-					finalURL, respBody, err = fetchURLWithBody(pageURL)
-					if err == nil && respBody != nil {
-						break
+				// the pattern budget is spent on fetches, not on queued links
+				if !requeued[u] {
+					if reason, first := traps.fetch(parsed); reason != "" {
+						if first {
+							reportTrap(u, domain, category, reason)
+						}
+						continue
 					}
-					// backoff
-					sleep := time.Duration((attempt+1)*(attempt+1)) * 200 * time.Millisecond
-					time.Sleep(sleep)
-				}
-				if err != nil {
-					errLog("Failed fetch %s: %v", pageURL, err)
-					return
-				}
-				defer respBody.Close()
-
-				// parse and extract
-				doc, err := goquery.NewDocumentFromReader(respBody)
-				if err != nil {
-					errLog("Failed parse HTML %s: %v", pageURL, err)
-					return
-				}
-
-				title := strings.TrimSpace(doc.Find("title").First().Text())
-				if title == "" {
-					title = "No Title"
 				}
-				snippet := ""
-				if desc, ok := doc.Find(`meta[name="description"]`).Attr("content"); ok {
-					snippet = strings.TrimSpace(desc)
-				} else {
-					snippet = strings.TrimSpace(doc.Find("p").First().Text())
+				if debugMode {
+					info("[Frontier] %s (depth=%d score=%.2f, %d queued)", u, item.Depth, item.score, queue.len())
 				}
 
-				// persist
-				if err := savePage(Page{
-					URL:      finalURL,
-					Title:    title,
-					Snippet:  snippet,
-					Category: category,
-				}); err != nil {
-					errLog("DB save failed for %s: %v", finalURL, err)
-				} else {
-					info("[Saved] %s", finalURL)
-				}
-
-				// increment count
 				crawledMu.Lock()
-				crawledCount++
+				inFlight++
 				crawledMu.Unlock()
+				workerWG.Add(1)
+				go func(pageURL string, depth int) {
+					defer workerWG.Done()
+					defer func() {
+						crawledMu.Lock()
+						inFlight--
+						crawledMu.Unlock()
+						select {
+						case wake <- struct{}{}:
+						default:
+						}
+					}()
 
-				// discover internal links and enqueue
-				doc.Find("a[href]").Each(func(i int, s *goquery.Selection) {
-					href, ok := s.Attr("href")
-					if !ok || href == "" {
+					// wait politeness ticker
+					select {
+					case <-ticker.C:
+					case <-ctx.Done():
 						return
 					}
-					abs := toAbsoluteURL(finalURL, href)
-					if abs == "" {
+
+					// fetch & process with retries
+					var res *fetchResult
+					var err error
+					attempts := 0
+					for attempt := 0; attempt <= MaxRetries; attempt++ {
+						attempts++
+						res, err = fetchURLWithBody(fetchCtx, pageURL)
+						if err == nil {
+							break
+						}
+						if !retryable(err) {
+							break
+						}
+						// backoff
+						sleep := time.Duration((attempt+1)*(attempt+1)) * 200 * time.Millisecond
+						time.Sleep(sleep)
+					}
+					outcome := fetchOutcome{
+						URL:        pageURL,
+						Domain:     domain,
+						Category:   category,
+						ErrorClass: classifyFetchError(err),
+						Attempts:   attempts,
+					}
+					if res != nil {
+						outcome.FinalURL = res.FinalURL
+						outcome.StatusCode = res.StatusCode
+						outcome.ContentType = res.ContentType
+						outcome.Redirects = res.Redirects
+						outcome.Latency = res.Latency
+					}
+					if err != nil {
+						outcome.Error = err.Error()
+						recordFetch(outcome)
+						errLog("Failed fetch %s: %v", pageURL, err)
 						return
 					}
-					// domain restriction (allow subdomains)
-					u, perr := url.Parse(abs)
-					if perr != nil {
+					finalURL := res.FinalURL
+					if u, err := url.Parse(finalURL); err == nil {
+						finalURL = normalizeURL(u) // the form visited keys and pages.url take
+					}
+					if len(res.Redirects) > 0 {
+						recordAliases(res.Redirects, finalURL)
+					}
+					if !claim(finalURL) {
+						recordFetch(outcome)
+						info("[Duplicate] %s -> %s already crawled", pageURL, finalURL)
 						return
 					}
-					if strings.HasSuffix(u.Hostname(), domain) {
-						// normalize (strip fragment)
-						u.Fragment = ""
-						abs = u.String()
-						visitedMu.Lock()
-						if _, seen := visited[abs]; !seen {
-							visited[abs] = struct{}{}
-							// only enqueue if limit not reached
-							crawledMu.Lock()
-							if crawledCount < MaxPagesPerDomain {
-								select {
-								case toCrawl <- abs:
-								default:
-									// queue full, skip
-								}
-							}
-							crawledMu.Unlock()
-						}
-						visitedMu.Unlock()
+					if res.Truncated {
+						outcome.ErrorClass = classTruncated
+						outcome.Error = fmt.Sprintf("body truncated at %d bytes", MaxBodyBytes)
+						warn("[Truncated] %s: body exceeded %d bytes", finalURL, MaxBodyBytes)
 					}
-				})
-			}(u)
 
-		case <-time.After(500 * time.Millisecond):
-			// nothing enqueued recently; if workers idle and queue empty, finish
-			crawledMu.Lock()
-			if crawledCount >= MaxPagesPerDomain {
-				crawledMu.Unlock()
-				break
-			}
-			crawledMu.Unlock()
-			// check stop signal
-			select {
-			case <-stop:
-				info("stop signal received for domain %s", domain)
-				break
-			default:
+					// parse and extract (transcoded to UTF-8 first)
+					body, enc := decodeHTMLBody(bytes.NewReader(res.Body), res.ContentType)
+					if debugMode && enc != "utf-8" {
+						info("Transcoding %s from %s", finalURL, enc)
+					}
+					doc, err := goquery.NewDocumentFromReader(body)
+					if err != nil {
+						outcome.ErrorClass = classParse
+						outcome.Error = err.Error()
+						recordFetch(outcome)
+						errLog("Failed parse HTML %s: %v", pageURL, err)
+						return
+					}
+					recordFetch(outcome)
+
+					title := strings.TrimSpace(doc.Find("title").First().Text())
+					if title == "" {
+						title = "No Title"
+					}
+					content := extractMainContent(doc)
+					snippet := ""
+					if desc, ok := doc.Find(`meta[name="description"]`).Attr("content"); ok {
+						snippet = strings.TrimSpace(desc)
+					} else if desc, ok := doc.Find(`meta[property="og:description"]`).Attr("content"); ok {
+						snippet = strings.TrimSpace(desc)
+					}
+					if snippet == "" {
+						snippet = contentSnippet(content.Text)
+					}
+
+					// structured data, with dates completed from meta tags, <time> and headers
+					rich := extractStructuredData(doc, finalURL)
+					rich.PublishedAt, rich.ModifiedAt = pageDates(doc, rich, res.LastModified)
+
+					// persist
+					if err := savePage(Page{
+						URL:      finalURL,
+						Title:    title,
+						Snippet:  snippet,
+						Category: category,
+						Content:  content.Text,
+						HTML:     content.HTML,
+						SimHash:  simHash(title + " " + content.Text),
+						Rich:     rich,
+						Domain:   siteconfig.PageDomain(finalURL),
+						Lang:     pageLanguage(doc, res.Language, content.Text),
+					}); err != nil {
+						errLog("DB save failed for %s: %v", finalURL, err)
+					} else {
+						info("[Saved] %s", finalURL)
+					}
+
+					// increment count
+					crawledMu.Lock()
+					crawledCount++
+					crawledMu.Unlock()
+
+					// discover links: every edge goes into the link graph,
+					// internal ones are also enqueued
+					var edges []link
+					linked := map[string]bool{}
+					doc.Find("a[href]").Each(func(i int, s *goquery.Selection) {
+						href, ok := s.Attr("href")
+						if !ok {
+							return
+						}
+						u, target, ok := resolveLink(finalURL, href)
+						if !ok {
+							return
+						}
+						edges = append(edges, link{Target: target, Anchor: anchorText(s)})
+						// domain restriction (subdomain policy of the entry)
+						if !site.AllowsHost(u.Hostname()) || !site.AllowsPath(u) || (site.MaxDepth > 0 && depth+1 > site.MaxDepth) {
+							return
+						}
+						// count each target once per page
+						if linked[target] {
+							return
+						}
+						linked[target] = true
+						enqueue(u, depth+1, -1)
+					})
+					if err := saveLinks(finalURL, edges); err != nil {
+						errLog("Saving links failed for %s: %v", finalURL, err)
+					}
+					if err := saveSections(finalURL, extractSections(doc)); err != nil {
+						errLog("Saving sections failed for %s: %v", finalURL, err)
+					}
+				}(u, item.Depth)
+				continue
 			}
 		}
+
+		select {
+		case <-ctx.Done():
+			info("context cancelled for domain %s", domain)
+			break crawl
+		case <-wake:
+		}
 	}
 
 	// wait for workers finish
@@ -472,18 +762,29 @@ func crawlDomain(ctx context.Context, category, domain string) error {
 // ----------------------
 // Helpers: HTTP, Robots, Fetching
 // ----------------------
-func testURLReachable(u string) bool {
-	req, _ := http.NewRequest("HEAD", u, nil)
+
+// probeSeed sends a HEAD request to a candidate seed; statuses outside
+// 2xx/3xx are returned as an *httpStatusError
+func probeSeed(u string) (status int, err error) {
+	req, err := http.NewRequest("HEAD", u, nil)
+	if err != nil {
+		return 0, err
+	}
 	req.Header.Set("User-Agent", UserAgent)
 	resp, err := httpClient.Do(req)
 	if err != nil {
-		return false
+		return 0, err
 	}
 	defer resp.Body.Close()
-	return resp.StatusCode >= 200 && resp.StatusCode < 400
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return resp.StatusCode, &httpStatusError{Code: resp.StatusCode}
+	}
+	return resp.StatusCode, nil
 }
 
-func fetchRobotsForDomain(domain string) (*robotstxt.Group, error) {
+// fetchRobotsForDomain returns the robots.txt group for our user agent and
+// the sitemaps robots.txt lists
+func fetchRobotsForDomain(domain string) (*robotstxt.Group, []string, error) {
 	robotsURL := "https://" + domain + "/robots.txt"
 	req, _ := http.NewRequest("GET", robotsURL, nil)
 	req.Header.Set("User-Agent", UserAgent)
@@ -495,60 +796,145 @@ func fetchRobotsForDomain(domain string) (*robotstxt.Group, error) {
 		req.Header.Set("User-Agent", UserAgent)
 		resp, err = httpClient.Do(req)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("robots returned status %d", resp.StatusCode)
+		return nil, nil, fmt.Errorf("robots returned status %d", resp.StatusCode)
 	}
 	data, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	robots, err := robotstxt.FromBytes(data)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	group := robots.FindGroup(UserAgent)
-	return group, nil
+	return group, robots.Sitemaps, nil
+}
+
+// fetchResult describes a fetch; on error it carries whatever response
+// metadata was received before the failure
+type fetchResult struct {
+	FinalURL     string        // URL after redirects
+	StatusCode   int           // 0 if no response arrived
+	ContentType  string        // raw Content-Type header (carries the charset)
+	Redirects    []redirectHop // hops taken before FinalURL, in order
+	Latency      time.Duration // time until the body was read (or the fetch failed)
+	Body         []byte        // decoded body, at most MaxBodyBytes
+	Truncated    bool          // body was cut at MaxBodyBytes
+	LastModified string        // Last-Modified header, if any
+	Language     string        // Content-Language header, if any
 }
 
-// fetchURLWithBody GETs URL and returns final URL (after redirects) and response body reader
-func fetchURLWithBody(u string) (string, io.ReadCloser, error) {
-	req, _ := http.NewRequest("GET", u, nil)
+// fetchURLWithBody GETs URL and returns final URL (after redirects) and the decoded body.
+// Redirects are limited by checkRedirect and the scope carried in ctx.
+func fetchURLWithBody(ctx context.Context, u string) (*fetchResult, error) {
+	start := time.Now()
+	req, _ := http.NewRequestWithContext(ctx, "GET", u, nil)
 	req.Header.Set("User-Agent", UserAgent)
+	req.Header.Set("Accept-Encoding", acceptEncoding)
 	resp, err := httpClient.Do(req)
 	if err != nil {
-		return "", nil, err
+		res := &fetchResult{FinalURL: u, Latency: time.Since(start)}
+		if resp != nil {
+			// refused redirect: resp is the last 3xx, its body already closed
+			res.StatusCode = resp.StatusCode
+			res.Redirects = append(redirectChain(resp), redirectHop{URL: resp.Request.URL.String(), Status: resp.StatusCode})
+		}
+		return res, err
+	}
+	defer resp.Body.Close()
+	res := &fetchResult{
+		FinalURL:     resp.Request.URL.String(),
+		StatusCode:   resp.StatusCode,
+		ContentType:  resp.Header.Get("Content-Type"),
+		Redirects:    redirectChain(resp),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Language:     resp.Header.Get("Content-Language"),
 	}
 	// accept only HTML
-	ct := resp.Header.Get("Content-Type")
 	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
-		resp.Body.Close()
-		return "", nil, fmt.Errorf("status %d", resp.StatusCode)
+		res.Latency = time.Since(start)
+		return res, &httpStatusError{Code: resp.StatusCode}
 	}
-	if !strings.Contains(ct, "html") {
-		// read body then close and return error — we don't index non-HTML
-		resp.Body.Close()
-		return "", nil, errors.New("non-html content")
+	if !strings.Contains(res.ContentType, "html") {
+		// we don't index non-HTML
+		res.Latency = time.Since(start)
+		return res, errNonHTML
 	}
-	// resp.Body will be closed by caller
-	return resp.Request.URL.String(), resp.Body, nil
+	res.Body, res.Truncated, err = readBody(resp)
+	res.Latency = time.Since(start)
+	return res, err
+}
+
+// retryable reports whether a failed fetch is worth another attempt; client
+// errors, non-HTML and size/encoding rejections fail the same way every time
+func retryable(err error) bool {
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Code >= 500 || statusErr.Code == http.StatusTooManyRequests ||
+			statusErr.Code == http.StatusRequestTimeout
+	}
+	return !errors.Is(err, errNonHTML) &&
+		!errors.Is(err, errTooManyRedirects) &&
+		!errors.Is(err, errOffsiteRedirect) &&
+		!errors.Is(err, errBodyTooLarge) &&
+		!errors.Is(err, errDecompressionBomb) &&
+		!errors.Is(err, errUnsupportedEncoding)
 }
 
 // ----------------------
 // DB persistence
 // ----------------------
+// savePage updates the stored row for p.URL, or inserts one if the page is new.
+// A page stored under its own URL is no longer an alias of anything. The
+// content markup is sanitised here, so content_html never holds page scripts.
 func savePage(p Page) error {
-	stmt := `INSERT INTO pages (url, title, snippet, category) VALUES (?, ?, ?, ?)`
-	_, err := db.Exec(stmt, p.URL, p.Title, p.Snippet, p.Category)
+	now := time.Now().UTC().Format(time.RFC3339)
+	p.HTML = sanitize.HTML(p.HTML, p.URL, nil)
+	rich := []interface{}{p.Rich.Type, p.Rich.Image, p.Rich.Author, nullIfEmpty(p.Rich.PublishedAt),
+		nullIfEmpty(p.Rich.ModifiedAt), p.Rich.Price, nullIfZero(p.Rich.Rating)}
+	args := append([]interface{}{p.Title, p.Snippet, p.Content, p.HTML, p.Category, int64(p.SimHash), now}, rich...)
+	args = append(args, p.Domain, p.Lang)
+	res, err := db.Exec(`UPDATE pages SET title = ?, snippet = ?, content = ?, content_html = ?, category = ?, simhash = ?, crawled_at = ?,
+		schema_type = ?, image = ?, author = ?, published_at = ?, modified_at = ?, price = ?, rating = ?, domain = ?, lang = ? WHERE url = ?`,
+		append(args, p.URL)...)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		stmt := `INSERT INTO pages (title, snippet, content, content_html, category, simhash, crawled_at,
+			schema_type, image, author, published_at, modified_at, price, rating, domain, lang, url) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+		if _, err := db.Exec(stmt, append(args, p.URL)...); err != nil {
+			return err
+		}
+	}
+	_, err = db.Exec(`DELETE FROM url_aliases WHERE alias = ?`, p.URL)
 	return err
 }
 
 // ----------------------
 // Utilities
 // ----------------------
+
+// nullIfEmpty and nullIfZero store missing optional values as NULL
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func nullIfZero(v float64) interface{} {
+	if v == 0 {
+		return nil
+	}
+	return v
+}
+
 func toAbsoluteURL(base, href string) string {
 	href = strings.TrimSpace(href)
 	if href == "" {
@@ -620,4 +1006,3 @@ func logFatal(format string, a ...interface{}) {
 	colorRed("[FATAL] "+format+"\n", a...)
 	os.Exit(1)
 }
-
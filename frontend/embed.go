@@ -0,0 +1,10 @@
+// Package frontend embeds the web UI so the server binary serves it without
+// reading the file system.
+package frontend
+
+import "embed"
+
+// Files holds index.html, script.js, style.css and assets/.
+//
+//go:embed index.html script.js style.css assets
+var Files embed.FS
@@ -3,62 +3,223 @@ document.addEventListener("DOMContentLoaded", () => {
   const categorySelect = document.getElementById("category");
   const searchBtn = document.getElementById("searchBtn");
   const resultsDiv = document.getElementById("results");
+  const facetsDiv = document.getElementById("facets");
+
+  // facet filters; each facet may have several values selected
+  const selected = { category: new Set(), domain: new Set(), lang: new Set() };
+  const categoryLabels = {};
 
   // Load categories from backend
   fetch("/categories")
     .then(res => res.json())
     .then(data => {
+      // the server sends categories already sorted
       data.forEach(cat => {
+        categoryLabels[cat.name] = cat.label;
         const opt = document.createElement("option");
-        opt.value = cat;
-        opt.textContent = cat.charAt(0).toUpperCase() + cat.slice(1);
+        opt.value = cat.name;
+        let label = cat.label === cat.name ? cat.label.charAt(0).toUpperCase() + cat.label.slice(1) : cat.label;
+        if (cat.label_hi) label += ` · ${cat.label_hi}`;
+        opt.textContent = `${label} (${cat.pages})`;
+        if (cat.description) opt.title = cat.description;
         categorySelect.appendChild(opt);
       });
     })
     .catch(err => console.error("Category load error:", err));
 
-  // Search function
+  // Rich result line built from the page's structured data
+  function richMeta(item) {
+    const parts = [];
+    if (item.schema_type) parts.push(item.schema_type);
+    if (item.author) parts.push(`by ${item.author}`);
+    if (item.published_at) parts.push(new Date(item.published_at).toLocaleDateString());
+    if (item.price) parts.push(item.price);
+    if (item.rating) parts.push(`★ ${item.rating}`);
+    return parts.join(" · ");
+  }
+
+  // Crawled pages are untrusted: results are built with textContent and
+  // only http(s) URLs are ever placed in href/src
+  function safeURL(url) {
+    try {
+      const u = new URL(url, window.location.href);
+      return u.protocol === "http:" || u.protocol === "https:" ? u.href : null;
+    } catch {
+      return null;
+    }
+  }
+
+  function el(tag, className, text) {
+    const node = document.createElement(tag);
+    if (className) node.className = className;
+    if (text !== undefined) node.textContent = text;
+    return node;
+  }
+
+  function link(url, text, className) {
+    const a = el("a", className, text);
+    const href = safeURL(url);
+    if (href) a.href = href;
+    a.target = "_blank";
+    a.rel = "noopener noreferrer";
+    return a;
+  }
+
+  function smallLine(text, className) {
+    const p = el("p", className);
+    p.appendChild(el("small", "", text));
+    return p;
+  }
+
+  function renderResult(item) {
+    const div = el("div", "result-item");
+
+    const image = item.image && safeURL(item.image);
+    if (image) {
+      div.classList.add("has-thumb");
+      const img = el("img", "result-thumb");
+      img.src = image;
+      img.alt = "";
+      img.loading = "lazy";
+      img.referrerPolicy = "no-referrer";
+      div.appendChild(img);
+    }
+
+    div.appendChild(link(item.url, item.title));
+
+    const meta = richMeta(item);
+    if (meta) div.appendChild(smallLine(meta, "rich-meta"));
+
+    div.appendChild(el("p", "", item.snippet || "No description available."));
+
+    if (item.section) {
+      const p = el("p", "section-match");
+      p.appendChild(link(item.section.url, `§ ${item.section.heading}`));
+      if (item.section.excerpt) p.appendChild(document.createTextNode(` — ${item.section.excerpt}`));
+      div.appendChild(p);
+    }
+
+    const info = smallLine(item.domain ? `${item.domain} · Category: ${item.category}` : `Category: ${item.category}`);
+    const cached = el("a", "cache-link", "Cached");
+    cached.href = `/cache?url=${encodeURIComponent(item.url)}`;
+    cached.target = "_blank";
+    info.appendChild(document.createTextNode(" · "));
+    info.appendChild(cached);
+    div.appendChild(info);
+
+    // results are capped per site; this searches the rest of this one
+    if (item.more_from_site && item.domain) {
+      const more = el("a", "more-link", `More results from ${item.domain}`);
+      more.href = "#";
+      more.addEventListener("click", e => {
+        e.preventDefault();
+        const text = queryInput.value.split(/\s+/).filter(w => w && !/^site:/i.test(w));
+        queryInput.value = [`site:${item.domain}`, ...text].join(" ");
+        search();
+      });
+      const p = el("p", "more-from-site");
+      p.appendChild(more);
+      div.appendChild(p);
+    }
+
+    if (item.similar_count) {
+      div.appendChild(smallLine(`+ ${item.similar_count} similar page${item.similar_count > 1 ? "s" : ""}`));
+    }
+    return div;
+  }
+
+  const facetTitles = { category: "Category", domain: "Site", lang: "Language" };
+  const languageNames = window.Intl && Intl.DisplayNames ? new Intl.DisplayNames(["en"], { type: "language" }) : null;
+
+  function facetLabel(facet, value) {
+    if (facet === "category") return categoryLabels[value] || value;
+    if (facet === "lang") {
+      if (value === "und") return "Unknown";
+      try {
+        return (languageNames && languageNames.of(value)) || value;
+      } catch {
+        return value;
+      }
+    }
+    return value;
+  }
+
+  function renderFacets(facets, total) {
+    facetsDiv.replaceChildren();
+    if (!facets) return;
+    facetsDiv.appendChild(el("p", "facet-total", `${total} result${total === 1 ? "" : "s"}`));
+    Object.keys(facetTitles).forEach(facet => {
+      const values = facets[facet] || [];
+      if (!values.length) return;
+      const group = el("div", "facet-group");
+      group.appendChild(el("h3", "", facetTitles[facet]));
+      values.forEach(v => {
+        const label = el("label", "facet-value");
+        const box = document.createElement("input");
+        box.type = "checkbox";
+        box.checked = !!v.selected;
+        box.addEventListener("change", () => {
+          if (box.checked) selected[facet].add(v.value);
+          else selected[facet].delete(v.value);
+          if (facet === "category") {
+            categorySelect.value = selected.category.size === 1 ? [...selected.category][0] : "";
+          }
+          runSearch();
+        });
+        label.appendChild(box);
+        label.appendChild(document.createTextNode(` ${facetLabel(facet, v.value)} (${v.count})`));
+        group.appendChild(label);
+      });
+      facetsDiv.appendChild(group);
+    });
+  }
+
+  // A new search starts from the dropdown's category; facet clicks refine it
   function search() {
+    selected.category = new Set(categorySelect.value ? [categorySelect.value] : []);
+    selected.domain.clear();
+    selected.lang.clear();
+    runSearch();
+  }
+
+  function runSearch() {
     const query = queryInput.value.trim();
-    const category = categorySelect.value;
 
     if (!query) {
+      facetsDiv.replaceChildren();
       resultsDiv.innerHTML = "<p> Null search... Enter somthing to search... </p>";
       return;
     }
 
     resultsDiv.innerHTML = "<p>Loading...</p>";
 
-    fetch(`/search?query=${encodeURIComponent(query)}&category=${encodeURIComponent(category)}`)
+    const params = new URLSearchParams({ query });
+    Object.keys(selected).forEach(facet => selected[facet].forEach(v => params.append(facet, v)));
+
+    fetch(`/v2/search?${params}`)
       .then(res => res.json())
       .then(data => {
-        if (!data.length) {
+        renderFacets(data.facets, data.total);
+        if (!data.results || !data.results.length) {
           resultsDiv.innerHTML = "<p>No results found.</p>";
           return;
         }
 
         resultsDiv.innerHTML = "";
-        data.forEach(item => {
-          const div = document.createElement("div");
-          div.classList.add("result-item");
-
-          div.innerHTML = `
-            <a href="${item.url}" target="_blank">${item.title}</a>
-            <p>${item.snippet || "No description available."}</p>
-            <p><small>Category: ${item.category}</small></p>
-          `;
-
-          resultsDiv.appendChild(div);
-        });
+        data.results.forEach(item => resultsDiv.appendChild(renderResult(item)));
       })
       .catch(err => {
         console.error("Search error:", err);
+        facetsDiv.replaceChildren();
         resultsDiv.innerHTML = "<p>Something went wrong.</p>";
       });
   }
 
   // Event listeners
   searchBtn.addEventListener("click", search);
+  categorySelect.addEventListener("change", () => {
+    if (queryInput.value.trim()) search();
+  });
   queryInput.addEventListener("keypress", e => {
     if (e.key === "Enter") search();
   });
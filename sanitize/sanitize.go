@@ -0,0 +1,205 @@
+// Package sanitize reduces crawled HTML to a small allowlist of tags and
+// attributes. The crawler stores main content only after passing it through
+// HTML, and the server's cached page viewer passes it through again when
+// rendering, for rows stored before that.
+package sanitize
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// --- Allowlist ---
+
+// allowedTags are kept (with allowed attributes only); any other element
+// is unwrapped so its text survives, except droppedTags which go
+// entirely, contents included
+var allowedTags = map[string]bool{
+	"p": true, "br": true, "hr": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"ul": true, "ol": true, "li": true, "dl": true, "dt": true, "dd": true,
+	"blockquote": true, "pre": true, "code": true, "kbd": true, "samp": true, "var": true,
+	"em": true, "strong": true, "b": true, "i": true, "u": true, "s": true, "del": true, "ins": true,
+	"sub": true, "sup": true, "small": true, "mark": true, "abbr": true, "cite": true, "q": true, "time": true,
+	"figure": true, "figcaption": true, "img": true, "a": true, "span": true, "div": true,
+	"section": true, "article": true, "header": true, "footer": true,
+	"table": true, "caption": true, "thead": true, "tbody": true, "tfoot": true, "tr": true, "th": true, "td": true,
+}
+
+var droppedTags = map[string]bool{
+	"script": true, "style": true, "noscript": true, "template": true, "iframe": true, "frame": true,
+	"frameset": true, "object": true, "embed": true, "applet": true, "form": true, "input": true,
+	"button": true, "select": true, "option": true, "textarea": true, "svg": true, "math": true,
+	"canvas": true, "audio": true, "video": true, "source": true, "track": true, "link": true,
+	"meta": true, "base": true, "head": true, "title": true, "dialog": true, "portal": true,
+}
+
+// allowedAttrs lists attributes per tag; "*" applies to every kept tag.
+// href and src are checked and rewritten separately.
+var allowedAttrs = map[string]map[string]bool{
+	"*":    {"id": true, "title": true, "lang": true, "dir": true},
+	"a":    {"href": true},
+	"img":  {"src": true, "alt": true, "width": true, "height": true},
+	"td":   {"colspan": true, "rowspan": true},
+	"th":   {"colspan": true, "rowspan": true, "scope": true},
+	"ol":   {"start": true, "reversed": true},
+	"time": {"datetime": true},
+}
+
+var (
+	safeID        = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_:.-]{0,99}$`)
+	safeDimension = regexp.MustCompile(`^[0-9]{1,4}$`)
+	safeDataImage = regexp.MustCompile(`^data:image/(png|gif|jpeg|webp);base64,[A-Za-z0-9+/=]+$`)
+)
+
+// --- Sanitizer ---
+
+// HTML reduces markup to the allowlist above. Links are made absolute
+// against base and limited to http(s); images must be https or inline raster
+// data. rewriteLinks, when given, sees every kept <a> with an href before
+// rendering and may change its attributes (only with values it trusts).
+func HTML(src, base string, rewriteLinks func(links []*html.Node)) string {
+	if strings.TrimSpace(src) == "" {
+		return ""
+	}
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return ""
+	}
+	ctx := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(src), ctx)
+	if err != nil {
+		return ""
+	}
+
+	var links []*html.Node
+	var clean func(n *html.Node) []*html.Node
+	clean = func(n *html.Node) []*html.Node {
+		switch n.Type {
+		case html.TextNode:
+			return []*html.Node{{Type: html.TextNode, Data: n.Data}}
+		case html.ElementNode:
+		default:
+			return nil
+		}
+		tag := strings.ToLower(n.Data)
+		if droppedTags[tag] || n.Namespace != "" {
+			return nil
+		}
+		var children []*html.Node
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			children = append(children, clean(c)...)
+		}
+		if !allowedTags[tag] {
+			return children // unwrap
+		}
+		out := &html.Node{Type: html.ElementNode, Data: tag, DataAtom: atom.Lookup([]byte(tag))}
+		for _, a := range n.Attr {
+			if v, ok := cleanAttr(tag, a, baseURL); ok {
+				out.Attr = append(out.Attr, html.Attribute{Key: strings.ToLower(a.Key), Val: v})
+			}
+		}
+		if tag == "img" && Attr(out, "src") == "" {
+			if v := lazySrc(n, baseURL); v != "" {
+				out.Attr = append(out.Attr, html.Attribute{Key: "src", Val: v})
+			} else {
+				return nil
+			}
+		}
+		if tag == "a" && Attr(out, "href") != "" {
+			links = append(links, out)
+		}
+		for _, c := range children {
+			out.AppendChild(c)
+		}
+		return []*html.Node{out}
+	}
+
+	var cleaned []*html.Node
+	for _, n := range nodes {
+		cleaned = append(cleaned, clean(n)...)
+	}
+	if rewriteLinks != nil {
+		rewriteLinks(links)
+	}
+
+	var sb strings.Builder
+	for _, n := range cleaned {
+		if err := html.Render(&sb, n); err != nil {
+			return ""
+		}
+	}
+	return sb.String()
+}
+
+// cleanAttr returns the value to keep for attribute a of a kept tag
+func cleanAttr(tag string, a html.Attribute, base *url.URL) (string, bool) {
+	key := strings.ToLower(a.Key)
+	if a.Namespace != "" || (!allowedAttrs["*"][key] && !allowedAttrs[tag][key]) {
+		return "", false
+	}
+	val := strings.TrimSpace(a.Val)
+	switch key {
+	case "id":
+		return val, safeID.MatchString(val)
+	case "width", "height", "colspan", "rowspan", "start":
+		return val, safeDimension.MatchString(val)
+	case "href":
+		if strings.HasPrefix(val, "#") {
+			return val, safeID.MatchString(val[1:])
+		}
+		return absoluteHTTP(val, base)
+	case "src":
+		return imageSrc(val, base)
+	}
+	return val, true
+}
+
+// absoluteHTTP resolves ref against base and accepts only http(s) results
+func absoluteHTTP(ref string, base *url.URL) (string, bool) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", false
+	}
+	u = base.ResolveReference(u)
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", false
+	}
+	return u.String(), true
+}
+
+func imageSrc(ref string, base *url.URL) (string, bool) {
+	if strings.HasPrefix(ref, "data:") {
+		return ref, safeDataImage.MatchString(ref)
+	}
+	u, ok := absoluteHTTP(ref, base)
+	if !ok || !strings.HasPrefix(u, "https://") {
+		return "", false
+	}
+	return u, true
+}
+
+// lazySrc picks up images that only carry their URL in a lazy-loading attribute
+func lazySrc(n *html.Node, base *url.URL) string {
+	for _, key := range []string{"data-src", "data-original", "data-lazy-src"} {
+		if v := Attr(n, key); v != "" {
+			if u, ok := imageSrc(v, base); ok {
+				return u
+			}
+		}
+	}
+	return ""
+}
+
+// Attr returns the value of n's attribute key, "" when it has none
+func Attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
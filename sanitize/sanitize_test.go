@@ -0,0 +1,79 @@
+package sanitize
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+const base = "https://news.test/2024/story"
+
+func TestHTML(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"plain", `<p>Hello <b>world</b></p>`, `<p>Hello <b>world</b></p>`},
+		{"script dropped with contents", `<p>a</p><script>alert(1)</script><p>b</p>`, `<p>a</p><p>b</p>`},
+		{"style dropped", `<style>p{}</style><p>a</p>`, `<p>a</p>`},
+		{"unknown tag unwrapped", `<font color="red">text</font>`, `text`},
+		{"event handler", `<p onclick="alert(1)" class="x" style="color:red">a</p>`, `<p>a</p>`},
+		{"javascript link", `<a href="javascript:alert(1)">x</a>`, `<a>x</a>`},
+		{"obfuscated javascript link", `<a href=" JaVaScRiPt:alert(1)">x</a>`, `<a>x</a>`},
+		{"data link", `<a href="data:text/html,<script>alert(1)</script>">x</a>`, `<a>x</a>`},
+		{"relative link", `<a href="../other">x</a>`, `<a href="https://news.test/other">x</a>`},
+		{"fragment link", `<a href="#part-2">x</a>`, `<a href="#part-2">x</a>`},
+		{"bad fragment", `<a href="#&quot;onmouseover">x</a>`, `<a>x</a>`},
+		{"https image", `<img src="/i.png" alt="i">`, `<img src="https://news.test/i.png" alt="i"/>`},
+		{"http image dropped", `<p><img src="http://cdn.test/i.png">a</p>`, `<p>a</p>`},
+		{"lazy image", `<img data-src="https://cdn.test/i.png">`, `<img src="https://cdn.test/i.png"/>`},
+		{"raster data image", `<img src="data:image/png;base64,iVBORw0KGgo=">`, `<img src="data:image/png;base64,iVBORw0KGgo="/>`},
+		{"svg data image", `<img src="data:image/svg+xml;base64,PHN2Zz4=">`, ``},
+		{"inline svg", `<svg><script>alert(1)</script></svg><p>a</p>`, `<p>a</p>`},
+		{"iframe", `<iframe src="https://evil.test"></iframe>`, ``},
+		{"form", `<form action="https://evil.test"><input name="q"></form>`, ``},
+		{"safe id", `<h2 id="intro">a</h2>`, `<h2 id="intro">a</h2>`},
+		{"unsafe id", `<h2 id="1 x">a</h2>`, `<h2>a</h2>`},
+		{"dimensions", `<table><tr><td colspan="2" rowspan="x">a</td></tr></table>`, `<table><tbody><tr><td colspan="2">a</td></tr></tbody></table>`},
+		{"comment", `<p>a<!-- <script>x</script> --></p>`, `<p>a</p>`},
+		{"escaped text", `<p>&lt;script&gt;</p>`, `<p>&lt;script&gt;</p>`},
+		{"empty", "  ", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HTML(tt.in, base, nil); got != tt.want {
+				t.Errorf("HTML(%s)\n got %s\nwant %s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTMLIdempotent(t *testing.T) {
+	in := `<div class="c"><h2 id="a">T</h2><p>x <a href="/y">y</a> <img data-src="https://cdn.test/i.png"></p><script>z</script></div>`
+	once := HTML(in, base, nil)
+	if twice := HTML(once, base, nil); twice != once {
+		t.Errorf("second pass changed the markup:\n once %s\ntwice %s", once, twice)
+	}
+}
+
+func TestHTMLRewriteLinks(t *testing.T) {
+	var seen []string
+	got := HTML(`<a href="/a">a</a><a href="#b">b</a><a>c</a>`, base, func(links []*html.Node) {
+		for _, a := range links {
+			seen = append(seen, Attr(a, "href"))
+			a.Attr = append(a.Attr, html.Attribute{Key: "rel", Val: "nofollow"})
+		}
+	})
+	if strings.Join(seen, " ") != "https://news.test/a #b" {
+		t.Errorf("rewriteLinks saw %q", seen)
+	}
+	if want := `<a href="https://news.test/a" rel="nofollow">a</a><a href="#b" rel="nofollow">b</a><a>c</a>`; got != want {
+		t.Errorf("got %s\nwant %s", got, want)
+	}
+}
+
+func TestHTMLBadBase(t *testing.T) {
+	if got := HTML(`<p>a</p>`, "://", nil); got != "" {
+		t.Errorf("unparsable base: got %q, want empty", got)
+	}
+}
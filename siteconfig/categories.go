@@ -0,0 +1,120 @@
+package siteconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Meta holds the optional display fields of a category, given when it is
+// written in object form:
+//
+//	"news": {
+//	  "label": "Technology News",
+//	  "label_hi": "प्रौद्योगिकी समाचार",
+//	  "description": "Tech journalism and reviews",
+//	  "sites": ["wired.com", {"domain": "arstechnica.com", "max_pages": 50}]
+//	}
+type Meta struct {
+	Label       string `json:"label"`
+	LabelHi     string `json:"label_hi"`
+	Description string `json:"description"`
+}
+
+// Categories is a parsed and validated categories.json
+type Categories struct {
+	Sites map[string][]Site // by category name
+	Meta  map[string]Meta   // by category name, for categories in object form
+
+	// Repeats notes entries dropped because their domain was already listed
+	// in the same category; the first entry is kept
+	Repeats []string
+}
+
+// Problems lists every invalid entry of a categories.json with its category
+// and position
+type Problems []string
+
+func (p Problems) Error() string {
+	return "invalid categories.json: " + strings.Join(p, "; ")
+}
+
+// Parse decodes categories.json and validates every entry. Any invalid entry
+// fails the whole file with a Problems error, so one typo can't half-apply.
+func Parse(data []byte) (*Categories, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("categories must be a JSON object: %v", err)
+	}
+	c := &Categories{
+		Sites: make(map[string][]Site, len(raw)),
+		Meta:  make(map[string]Meta, len(raw)),
+	}
+	var problems Problems
+	for name, rawCategory := range raw {
+		if strings.TrimSpace(name) == "" {
+			problems = append(problems, "empty category name")
+			continue
+		}
+		meta, entries, err := categorySites(rawCategory)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		if meta != nil {
+			c.Meta[name] = *meta
+		}
+		sites := make([]Site, 0, len(entries))
+		seen := map[string]bool{}
+		for i, entry := range entries {
+			var s Site
+			if err := json.Unmarshal(entry, &s); err != nil {
+				problems = append(problems, fmt.Sprintf("%s[%d]: %v", name, i, err))
+				continue
+			}
+			if err := s.Validate(); err != nil {
+				problems = append(problems, fmt.Sprintf("%s[%d]: %v", name, i, err))
+				continue
+			}
+			if seen[s.Domain] {
+				c.Repeats = append(c.Repeats, fmt.Sprintf("%s[%d] repeats %s", name, i, s.Domain))
+				continue
+			}
+			seen[s.Domain] = true
+			sites = append(sites, s)
+		}
+		c.Sites[name] = sites
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return nil, problems
+	}
+	sort.Strings(c.Repeats)
+	return c, nil
+}
+
+// categorySites splits a category into its display fields (nil for the
+// plain list form) and its site entries
+func categorySites(b json.RawMessage) (*Meta, []json.RawMessage, error) {
+	b = bytes.TrimSpace(b)
+	if len(b) > 0 && b[0] == '{' {
+		var obj struct {
+			Meta
+			Sites []json.RawMessage `json:"sites"`
+		}
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&obj); err != nil {
+			return nil, nil, err
+		}
+		return &obj.Meta, obj.Sites, nil
+	}
+	var entries []json.RawMessage
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, nil, errors.New(`must be a list of sites or an object with "sites"`)
+	}
+	return nil, entries, nil
+}
@@ -0,0 +1,78 @@
+package siteconfig
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	c, err := Parse([]byte(`{
+		"linux": ["kali.org", {"domain": "archlinux.org", "max_pages": 50}, "KALI.org"],
+		"news": {
+			"label": "Technology News",
+			"label_hi": "प्रौद्योगिकी समाचार",
+			"sites": ["wired.com"]
+		},
+		"empty": []
+	}`))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if got := len(c.Sites["linux"]); got != 2 {
+		t.Errorf("linux has %d sites, want 2 (the repeat dropped)", got)
+	}
+	if len(c.Repeats) != 1 || !strings.Contains(c.Repeats[0], "linux[2] repeats kali.org") {
+		t.Errorf("Repeats = %q", c.Repeats)
+	}
+	if c.Sites["linux"][1].MaxPages != 50 {
+		t.Errorf("archlinux.org max_pages = %d, want 50", c.Sites["linux"][1].MaxPages)
+	}
+	if m := c.Meta["news"]; m.Label != "Technology News" || m.LabelHi == "" {
+		t.Errorf("news meta = %+v", m)
+	}
+	if _, ok := c.Meta["linux"]; ok {
+		t.Error("list-form category has meta")
+	}
+	if sites, ok := c.Sites["empty"]; !ok || len(sites) != 0 {
+		t.Errorf("empty category = %v, %v", sites, ok)
+	}
+}
+
+func TestParseProblems(t *testing.T) {
+	_, err := Parse([]byte(`{
+		"a": [{"domain": "kali.org", "bogus": 1}],
+		"b": [{"domain": "kali.org", "include": ["("]}],
+		"c": ["co.in", {"domain": "kali.org", "delay": "0s"}],
+		"d": {"label": "D", "colour": "red", "sites": []},
+		"e": "kali.org",
+		"f": ["kali.org"]
+	}`))
+	var problems Problems
+	if !errors.As(err, &problems) {
+		t.Fatalf("err = %v, want Problems", err)
+	}
+	for _, want := range []string{
+		`a[0]: json: unknown field "bogus"`,
+		`b[0]: pattern "("`,
+		`c[0]: domain "co.in" is a public suffix`,
+		`c[1]: delay: "0s" must be longer than zero`,
+		`d: json: unknown field "colour"`,
+		`e: must be a list of sites`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error lacks %q:\n%v", want, err)
+		}
+	}
+	if len(problems) != 6 {
+		t.Errorf("%d problems, want 6: %q", len(problems), problems)
+	}
+}
+
+func TestParseNotAnObject(t *testing.T) {
+	for _, src := range []string{``, `[]`, `{"a": ["kali.org"]`} {
+		if _, err := Parse([]byte(src)); err == nil {
+			t.Errorf("Parse(%q) accepted", src)
+		}
+	}
+}
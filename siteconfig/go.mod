@@ -0,0 +1,5 @@
+module siteconfig
+
+go 1.25.1
+
+require golang.org/x/net v0.39.0
@@ -0,0 +1,72 @@
+package siteconfig
+
+import (
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// Subdomain policies for a categories.json entry (subdomain_policy)
+const (
+	PolicyAll    = "all"    // the domain and every subdomain of it (default)
+	PolicySite   = "site"   // anything under the same registrable domain, e.g. web.whatsapp.com -> *.whatsapp.com
+	PolicyListed = "listed" // the domain plus the labels in "subdomains"
+	PolicyNone   = "none"   // the domain itself (and its www. twin) only
+)
+
+// --- Domain scope (Public Suffix List) ---
+
+// RegistrableDomain returns host's eTLD+1 ("news.bbc.co.uk" -> "bbc.co.uk")
+// using the Public Suffix List compiled into x/net/publicsuffix, so no
+// network access is needed. It returns "" for public suffixes themselves.
+func RegistrableDomain(host string) string {
+	host = NormalizeHost(host)
+	if host == "" {
+		return ""
+	}
+	rd, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return ""
+	}
+	return rd
+}
+
+// IsPublicSuffix reports whether domain is a suffix under which anyone can
+// register names (co.in, github.io, ...); such a domain scopes nothing
+func IsPublicSuffix(domain string) bool {
+	return RegistrableDomain(domain) == ""
+}
+
+// InScope reports whether host is domain or a subdomain of it. Matching is
+// on label boundaries ("evilkali.org" is not under "kali.org") and both must
+// share a registrable domain, so a configured public suffix matches nothing.
+func InScope(host, domain string) bool {
+	host, domain = NormalizeHost(host), NormalizeHost(domain)
+	if host != domain && !strings.HasSuffix(host, "."+domain) {
+		return false
+	}
+	rd := RegistrableDomain(domain)
+	return rd != "" && rd == RegistrableDomain(host)
+}
+
+// SameSite reports whether host and domain share a registrable domain
+func SameSite(host, domain string) bool {
+	rd := RegistrableDomain(domain)
+	return rd != "" && rd == RegistrableDomain(host)
+}
+
+// NormalizeHost lower-cases host and drops a trailing root dot
+func NormalizeHost(h string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(h)), ".")
+}
+
+// PageDomain is the host a page is grouped under in search facets and
+// site: matches: lower case, without a leading "www."; "" for a bad URL
+func PageDomain(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(NormalizeHost(u.Hostname()), "www.")
+}
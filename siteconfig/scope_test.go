@@ -0,0 +1,83 @@
+package siteconfig
+
+import "testing"
+
+func TestRegistrableDomain(t *testing.T) {
+	tests := []struct{ host, want string }{
+		{"news.bbc.co.uk", "bbc.co.uk"},
+		{"WWW.Kali.Org.", "kali.org"},
+		{"shop.flipkart.com", "flipkart.com"},
+		{"user.github.io", "user.github.io"},
+		{"co.in", ""},
+		{"github.io", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := RegistrableDomain(tt.host); got != tt.want {
+			t.Errorf("RegistrableDomain(%q) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestInScope(t *testing.T) {
+	tests := []struct {
+		host, domain string
+		want         bool
+	}{
+		{"kali.org", "kali.org", true},
+		{"www.kali.org", "kali.org", true},
+		{"docs.kali.org", "kali.org", true},
+		{"Docs.Kali.Org.", "kali.org", true},
+		// look-alikes sharing a suffix but not a label boundary
+		{"evilkali.org", "kali.org", false},
+		{"kali.org.evil.com", "kali.org", false},
+		{"kali.org-evil.com", "kali.org", false},
+		// a subdomain entry does not cover its parent or siblings
+		{"kali.org", "docs.kali.org", false},
+		{"forums.kali.org", "docs.kali.org", false},
+		// a public suffix scopes nothing, not even its own registrations
+		{"someone.github.io", "github.io", false},
+		{"example.co.in", "co.in", false},
+		{"user.github.io", "user.github.io", true},
+		{"other.github.io", "user.github.io", false},
+	}
+	for _, tt := range tests {
+		if got := InScope(tt.host, tt.domain); got != tt.want {
+			t.Errorf("InScope(%q, %q) = %v, want %v", tt.host, tt.domain, got, tt.want)
+		}
+	}
+}
+
+func TestSameSite(t *testing.T) {
+	tests := []struct {
+		host, domain string
+		want         bool
+	}{
+		{"whatsapp.com", "web.whatsapp.com", true},
+		{"faq.whatsapp.com", "web.whatsapp.com", true},
+		{"whatsapp.net", "web.whatsapp.com", false},
+		{"evilwhatsapp.com", "whatsapp.com", false},
+		{"a.github.io", "b.github.io", false},
+	}
+	for _, tt := range tests {
+		if got := SameSite(tt.host, tt.domain); got != tt.want {
+			t.Errorf("SameSite(%q, %q) = %v, want %v", tt.host, tt.domain, got, tt.want)
+		}
+	}
+}
+
+func TestPageDomain(t *testing.T) {
+	tests := []struct{ url, want string }{
+		{"https://www.Kali.org/docs/", "kali.org"},
+		{"https://docs.kali.org", "docs.kali.org"},
+		{"http://KALI.ORG.:8080/", "kali.org"},
+		{"https://wwwkali.org/", "wwwkali.org"},
+		{"/relative", ""},
+		{"://bad", ""},
+	}
+	for _, tt := range tests {
+		if got := PageDomain(tt.url); got != tt.want {
+			t.Errorf("PageDomain(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
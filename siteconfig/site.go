@@ -0,0 +1,204 @@
+// Package siteconfig defines categories.json, the list of sites the crawler
+// fetches and the server filters by. Both load it through Parse, so a file
+// the server accepts is one the crawler can run.
+package siteconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Site is one entry of a category in categories.json. An entry is either a
+// bare domain string (all defaults) or an object:
+//
+//	{
+//	  "domain": "kali.org",
+//	  "seeds": ["https://www.kali.org/docs/"],
+//	  "include": ["^/docs/"],
+//	  "exclude": ["/tag/", "\\?replytocom="],
+//	  "max_pages": 100,
+//	  "max_depth": 4,
+//	  "delay": "1.5s",
+//	  "subdomain_policy": "listed",
+//	  "subdomains": ["www", "docs"],
+//	  "recrawl": "24h"
+//	}
+//
+// include/exclude are regexes matched against a URL's path and query. A URL
+// must match at least one include (when any are given) and no exclude.
+// subdomain_policy is one of "all" (default), "site", "listed" or "none"; see
+// scope.go. Listing subdomains without a policy implies "listed".
+type Site struct {
+	Domain     string   `json:"domain"`
+	Seeds      []string `json:"seeds,omitempty"`
+	Include    []string `json:"include,omitempty"`
+	Exclude    []string `json:"exclude,omitempty"`
+	MaxPages   int      `json:"max_pages,omitempty"`  // 0: the crawler's default
+	MaxDepth   int      `json:"max_depth,omitempty"`  // link hops from a seed; 0 means unlimited
+	Delay      string   `json:"delay,omitempty"`      // between requests; the crawler's default when unset
+	Subdomains []string `json:"subdomains,omitempty"` // subdomain labels allowed by the "listed" policy
+	Recrawl    string   `json:"recrawl,omitempty"`    // skip the domain if crawled more recently than this
+
+	SubdomainPolicy string `json:"subdomain_policy,omitempty"`
+
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+	delay   time.Duration
+	recrawl time.Duration
+}
+
+// UnmarshalJSON accepts the bare "example.com" form as well as the object
+// form, in which unknown fields are an error
+func (s *Site) UnmarshalJSON(b []byte) error {
+	b = bytes.TrimSpace(b)
+	if len(b) > 0 && b[0] == '"' {
+		return json.Unmarshal(b, &s.Domain)
+	}
+	if len(b) == 0 || b[0] != '{' {
+		return errors.New("must be a domain or an object")
+	}
+	type plain Site
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	return dec.Decode((*plain)(s))
+}
+
+// Validate normalises the entry and compiles its patterns
+func (s *Site) Validate() error {
+	s.Domain = strings.ToLower(strings.TrimSpace(s.Domain))
+	if s.Domain == "" {
+		return errors.New("empty domain")
+	}
+	if strings.ContainsAny(s.Domain, "/:?# ") {
+		return fmt.Errorf("domain %q must be a bare host name, without scheme, port or path", s.Domain)
+	}
+	if IsPublicSuffix(s.Domain) {
+		return fmt.Errorf("domain %q is a public suffix, not a site", s.Domain)
+	}
+	for i, sub := range s.Subdomains {
+		s.Subdomains[i] = strings.ToLower(strings.Trim(strings.TrimSpace(sub), "."))
+		if s.Subdomains[i] == "" {
+			return errors.New("empty subdomain label")
+		}
+	}
+	switch s.SubdomainPolicy {
+	case "":
+		s.SubdomainPolicy = PolicyAll
+		if len(s.Subdomains) > 0 {
+			s.SubdomainPolicy = PolicyListed
+		}
+	case PolicyListed:
+		if len(s.Subdomains) == 0 {
+			return errors.New(`subdomain_policy "listed" needs a "subdomains" list`)
+		}
+	case PolicyAll, PolicySite, PolicyNone:
+		if len(s.Subdomains) > 0 {
+			return fmt.Errorf(`"subdomains" only applies to subdomain_policy "listed", not %q`, s.SubdomainPolicy)
+		}
+	default:
+		return fmt.Errorf("unknown subdomain_policy %q", s.SubdomainPolicy)
+	}
+	for _, seed := range s.Seeds {
+		u, err := url.Parse(seed)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("seed %q is not an absolute http(s) URL", seed)
+		}
+		if !s.AllowsHost(u.Hostname()) {
+			return fmt.Errorf("seed %q is outside %s", seed, s.Domain)
+		}
+	}
+	for _, list := range []struct {
+		src []string
+		dst *[]*regexp.Regexp
+	}{{s.Include, &s.include}, {s.Exclude, &s.exclude}} {
+		*list.dst = nil
+		for _, pattern := range list.src {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return fmt.Errorf("pattern %q: %v", pattern, err)
+			}
+			*list.dst = append(*list.dst, re)
+		}
+	}
+	if s.MaxPages < 0 || s.MaxDepth < 0 {
+		return errors.New("max_pages and max_depth cannot be negative")
+	}
+	var err error
+	if s.delay, err = parseOptionalDuration(s.Delay); err != nil {
+		return fmt.Errorf("delay: %v", err)
+	}
+	if s.Delay != "" && s.delay == 0 {
+		return fmt.Errorf("delay: %q must be longer than zero", s.Delay) // the crawler's per-domain ticker needs a positive period
+	}
+	if s.recrawl, err = parseOptionalDuration(s.Recrawl); err != nil {
+		return fmt.Errorf("recrawl: %v", err)
+	}
+	return nil
+}
+
+func parseOptionalDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if v < 0 {
+		return 0, fmt.Errorf("%q is negative", s)
+	}
+	return v, nil
+}
+
+// CrawlDelay is the validated delay, 0 when the entry sets none
+func (s *Site) CrawlDelay() time.Duration { return s.delay }
+
+// RecrawlAfter is the validated recrawl interval, 0 when the entry sets none
+func (s *Site) RecrawlAfter() time.Duration { return s.recrawl }
+
+// AllowsHost reports whether host is in scope under the entry's subdomain policy
+func (s *Site) AllowsHost(host string) bool {
+	host = NormalizeHost(host)
+	switch s.SubdomainPolicy {
+	case PolicySite:
+		return SameSite(host, s.Domain)
+	case PolicyNone:
+		return host == s.Domain || host == "www."+s.Domain
+	case PolicyListed:
+		if host == s.Domain {
+			return true
+		}
+		for _, sub := range s.Subdomains {
+			if host == sub+"."+s.Domain || (sub == "*" && InScope(host, s.Domain)) {
+				return true
+			}
+		}
+		return false
+	}
+	return InScope(host, s.Domain)
+}
+
+// AllowsPath applies the include/exclude rules to u's path and query
+func (s *Site) AllowsPath(u *url.URL) bool {
+	target := u.RequestURI()
+	for _, re := range s.exclude {
+		if re.MatchString(target) {
+			return false
+		}
+	}
+	if len(s.include) == 0 {
+		return true
+	}
+	for _, re := range s.include {
+		if re.MatchString(target) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package siteconfig
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func parseSite(t *testing.T, src string) Site {
+	t.Helper()
+	var s Site
+	if err := json.Unmarshal([]byte(src), &s); err != nil {
+		t.Fatalf("unmarshal %s: %v", src, err)
+	}
+	if err := s.Validate(); err != nil {
+		t.Fatalf("validate %s: %v", src, err)
+	}
+	return s
+}
+
+func TestSiteValidate(t *testing.T) {
+	tests := []struct {
+		src     string
+		wantErr string // substring; "" when valid
+	}{
+		{`"kali.org"`, ""},
+		{`" Kali.ORG "`, ""},
+		{`{"domain": "kali.org", "delay": "1.5s", "recrawl": "24h"}`, ""},
+		{`{"domain": "kali.org", "recrawl": "0s"}`, ""},
+		{`{"domain": "kali.org", "seeds": ["https://www.kali.org/docs/"], "include": ["^/docs/"]}`, ""},
+		{`""`, "empty domain"},
+		{`"https://kali.org"`, "bare host name"},
+		{`"kali.org/docs"`, "bare host name"},
+		{`"co.in"`, "public suffix"},
+		{`"github.io"`, "public suffix"},
+		{`{"domain": "kali.org", "delay": "0s"}`, "longer than zero"},
+		{`{"domain": "kali.org", "delay": "-1s"}`, "negative"},
+		{`{"domain": "kali.org", "delay": "soon"}`, "delay"},
+		{`{"domain": "kali.org", "recrawl": "-24h"}`, "negative"},
+		{`{"domain": "kali.org", "include": ["("]}`, "pattern"},
+		{`{"domain": "kali.org", "max_pages": -1}`, "negative"},
+		{`{"domain": "kali.org", "seeds": ["/docs/"]}`, "absolute"},
+		{`{"domain": "kali.org", "seeds": ["https://evilkali.org/"]}`, "outside"},
+		{`{"domain": "kali.org", "subdomain_policy": "listed"}`, "needs a"},
+		{`{"domain": "kali.org", "subdomain_policy": "none", "subdomains": ["docs"]}`, "only applies"},
+		{`{"domain": "kali.org", "subdomain_policy": "some"}`, "unknown subdomain_policy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			var s Site
+			err := json.Unmarshal([]byte(tt.src), &s)
+			if err == nil {
+				err = s.Validate()
+			}
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("err = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSiteUnmarshalStrict(t *testing.T) {
+	for _, src := range []string{`{"domain": "kali.org", "max_page": 5}`, `42`, `["kali.org"]`} {
+		var s Site
+		if err := json.Unmarshal([]byte(src), &s); err == nil {
+			t.Errorf("%s: accepted", src)
+		}
+	}
+}
+
+func TestSiteDurations(t *testing.T) {
+	s := parseSite(t, `{"domain": "kali.org", "delay": "1500ms", "recrawl": "24h"}`)
+	if s.CrawlDelay() != 1500*time.Millisecond || s.RecrawlAfter() != 24*time.Hour {
+		t.Errorf("CrawlDelay, RecrawlAfter = %v, %v", s.CrawlDelay(), s.RecrawlAfter())
+	}
+	if s := parseSite(t, `"kali.org"`); s.CrawlDelay() != 0 || s.RecrawlAfter() != 0 {
+		t.Errorf("unset durations = %v, %v, want 0", s.CrawlDelay(), s.RecrawlAfter())
+	}
+}
+
+func TestSiteAllowsHost(t *testing.T) {
+	tests := []struct {
+		src   string
+		host  string
+		allow bool
+	}{
+		// "all" (default): the domain and its subdomains, on label boundaries
+		{`"kali.org"`, "kali.org", true},
+		{`"kali.org"`, "docs.kali.org", true},
+		{`"kali.org"`, "evilkali.org", false},
+		{`"kali.org"`, "kali.org.evil.com", false},
+		// "site": anything under the registrable domain
+		{`{"domain": "web.whatsapp.com", "subdomain_policy": "site"}`, "faq.whatsapp.com", true},
+		{`{"domain": "web.whatsapp.com", "subdomain_policy": "site"}`, "whatsapp.com.evil.io", false},
+		// "none": the domain and its www. twin
+		{`{"domain": "kali.org", "subdomain_policy": "none"}`, "www.kali.org", true},
+		{`{"domain": "kali.org", "subdomain_policy": "none"}`, "docs.kali.org", false},
+		// "listed": only the labels given
+		{`{"domain": "kali.org", "subdomains": ["docs"]}`, "docs.kali.org", true},
+		{`{"domain": "kali.org", "subdomains": ["docs"]}`, "kali.org", true},
+		{`{"domain": "kali.org", "subdomains": ["docs"]}`, "forums.kali.org", false},
+		{`{"domain": "kali.org", "subdomains": ["docs"]}`, "docs.kali.org.evil.com", false},
+		{`{"domain": "kali.org", "subdomains": ["*"]}`, "a.b.kali.org", true},
+		{`{"domain": "kali.org", "subdomains": ["*"]}`, "evilkali.org", false},
+	}
+	for _, tt := range tests {
+		s := parseSite(t, tt.src)
+		if got := s.AllowsHost(tt.host); got != tt.allow {
+			t.Errorf("%s: AllowsHost(%q) = %v, want %v", tt.src, tt.host, got, tt.allow)
+		}
+	}
+}
+
+func TestSiteAllowsPath(t *testing.T) {
+	s := parseSite(t, `{"domain": "kali.org", "include": ["^/docs/", "^/blog/"], "exclude": ["/tag/", "\\?replytocom="]}`)
+	tests := []struct {
+		path  string
+		allow bool
+	}{
+		{"/docs/install/", true},
+		{"/blog/2024/release", true},
+		{"/about/", false},
+		{"/docs/tag/arm/", false},
+		{"/blog/post?replytocom=5", false},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse("https://kali.org" + tt.path)
+		if got := s.AllowsPath(u); got != tt.allow {
+			t.Errorf("AllowsPath(%s) = %v, want %v", tt.path, got, tt.allow)
+		}
+	}
+}